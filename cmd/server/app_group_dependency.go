@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// appGroupDependencyTimeout limits the wait for the dependencies of an app group.
+var appGroupDependencyTimeout = time.Hour
+
+// validateAppGroupDependencies checks that every dependency is the name of an app group type,
+// and that the dependencies have no cycle.
+func validateAppGroupDependencies(types map[string]*appGroupType) error {
+	// Types with the same name are alternatives, eg. LMA and LMA_EFK are both "lma".
+	deps := map[string][]string{}
+	for _, t := range types {
+		if _, ok := deps[t.Name]; !ok {
+			deps[t.Name] = []string{}
+		}
+		deps[t.Name] = append(deps[t.Name], t.DependsOn...)
+	}
+	for name, names := range deps {
+		for _, dep := range names {
+			if _, ok := deps[dep]; !ok {
+				return fmt.Errorf("%s depends on unknown app group %s", name, dep)
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortAppGroups orders the app groups so that each one comes after the app groups of the same cluster
+// which it depends on. The order of the request is kept otherwise.
+// App groups of unknown types have no dependencies.
+func sortAppGroups(appGroups []*pb.AppGroup) ([]*pb.AppGroup, error) {
+	types := make([]*appGroupType, len(appGroups))
+	for i, appGroup := range appGroups {
+		t, err := appGroupTypes.get(appGroup.GetType())
+		if err != nil {
+			t = &appGroupType{}
+		}
+		types[i] = t
+	}
+
+	// dependsOn[i] has the app groups which the app group i depends on.
+	dependsOn := make([]map[int]bool, len(appGroups))
+	for i, appGroup := range appGroups {
+		dependsOn[i] = map[int]bool{}
+		for j, other := range appGroups {
+			if i != j && other.GetClusterId() == appGroup.GetClusterId() && containsString(types[i].DependsOn, types[j].Name) {
+				dependsOn[i][j] = true
+			}
+		}
+	}
+
+	sorted := make([]*pb.AppGroup, 0, len(appGroups))
+	done := make([]bool, len(appGroups))
+	for len(sorted) < len(appGroups) {
+		next := -1
+		for i := range appGroups {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for j := range dependsOn[i] {
+				if !done[j] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			cycle := []string{}
+			for i, appGroup := range appGroups {
+				if !done[i] {
+					cycle = append(cycle, appGroup.GetAppGroupName())
+				}
+			}
+			return nil, fmt.Errorf("app groups %s have a dependency cycle", strings.Join(cycle, ", "))
+		}
+		done[next] = true
+		sorted = append(sorted, appGroups[next])
+	}
+	return sorted, nil
+}
+
+// appGroupDependencies returns the app groups to wait for, per dependency of the app group type.
+// A dependency is met by a running app group of the name, and no wait is needed then.
+// Otherwise any of the installing app groups of the name may meet it.
+func appGroupDependencies(t *appGroupType, clusterAppGroups []*pb.AppGroup) ([][]string, error) {
+	waits := [][]string{}
+	for _, dep := range t.DependsOn {
+		running := false
+		installing := []string{}
+		for _, appGroup := range clusterAppGroups {
+			other, err := appGroupTypes.get(appGroup.GetType())
+			if err != nil || other.Name != dep {
+				continue
+			}
+			switch appGroup.GetStatus() {
+			case pb.AppGroupStatus_APP_GROUP_RUNNING:
+				running = true
+			case pb.AppGroupStatus_APP_GROUP_INSTALLING:
+				installing = append(installing, appGroup.GetAppGroupId())
+			}
+		}
+		if running {
+			continue
+		}
+		if len(installing) == 0 {
+			return nil, fmt.Errorf("%s depends on %s, which is not installed in the cluster", t.Name, dep)
+		}
+		waits = append(waits, installing)
+	}
+	return waits, nil
+}
+
+// appGroupDependents returns the app groups which depend on the app group and would be left without the dependency.
+// The app groups in removing are regarded as removed.
+func appGroupDependents(appGroup *pb.AppGroup, clusterAppGroups []*pb.AppGroup, removing map[string]bool) []string {
+	t, err := appGroupTypes.get(appGroup.GetType())
+	if err != nil {
+		return nil
+	}
+
+	remaining := []*pb.AppGroup{}
+	for _, other := range clusterAppGroups {
+		if removing[other.GetAppGroupId()] || other.GetAppGroupId() == appGroup.GetAppGroupId() {
+			continue
+		}
+		if other.GetStatus() == pb.AppGroupStatus_APP_GROUP_DELETING || other.GetStatus() == pb.AppGroupStatus_APP_GROUP_DELETED {
+			continue
+		}
+		remaining = append(remaining, other)
+	}
+
+	// Another app group of the same name keeps the dependency met.
+	for _, other := range remaining {
+		if otherType, err := appGroupTypes.get(other.GetType()); err == nil && otherType.Name == t.Name {
+			return nil
+		}
+	}
+
+	dependents := []string{}
+	for _, other := range remaining {
+		if otherType, err := appGroupTypes.get(other.GetType()); err == nil && containsString(otherType.DependsOn, t.Name) {
+			dependents = append(dependents, other.GetAppGroupId())
+		}
+	}
+	return dependents
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
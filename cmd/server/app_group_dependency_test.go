@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	mockargo "github.com/openinfradev/tks-common/pkg/argowf/mock"
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+	mocktks "github.com/openinfradev/tks-proto/tks_pb/mock"
+)
+
+func TestSortAppGroups(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+	otherClusterId := helper.GenerateClusterId()
+	serviceMesh := &pb.AppGroup{AppGroupName: "service-mesh", Type: pb.AppGroupType_SERVICE_MESH, ClusterId: clusterId}
+	lma := &pb.AppGroup{AppGroupName: "lma", Type: pb.AppGroupType_LMA, ClusterId: clusterId}
+	otherServiceMesh := &pb.AppGroup{AppGroupName: "other-service-mesh", Type: pb.AppGroupType_SERVICE_MESH, ClusterId: otherClusterId}
+
+	sorted, err := sortAppGroups([]*pb.AppGroup{serviceMesh, otherServiceMesh, lma})
+	require.NoError(t, err)
+	require.Equal(t, []*pb.AppGroup{otherServiceMesh, lma, serviceMesh}, sorted)
+}
+
+func TestAppGroupDependencies(t *testing.T) {
+	serviceMesh, err := appGroupTypes.get(pb.AppGroupType_SERVICE_MESH)
+	require.NoError(t, err)
+	runningLma := &pb.AppGroup{AppGroupId: helper.GenerateApplicaionGroupId(), Type: pb.AppGroupType_LMA, Status: pb.AppGroupStatus_APP_GROUP_RUNNING}
+	installingLma := &pb.AppGroup{AppGroupId: helper.GenerateApplicaionGroupId(), Type: pb.AppGroupType_LMA_EFK, Status: pb.AppGroupStatus_APP_GROUP_INSTALLING}
+	failedLma := &pb.AppGroup{AppGroupId: helper.GenerateApplicaionGroupId(), Type: pb.AppGroupType_LMA, Status: pb.AppGroupStatus_APP_GROUP_ERROR}
+
+	waits, err := appGroupDependencies(serviceMesh, []*pb.AppGroup{runningLma, installingLma})
+	require.NoError(t, err)
+	require.Empty(t, waits)
+
+	waits, err = appGroupDependencies(serviceMesh, []*pb.AppGroup{installingLma, failedLma})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{installingLma.AppGroupId}}, waits)
+
+	_, err = appGroupDependencies(serviceMesh, []*pb.AppGroup{failedLma})
+	require.Error(t, err)
+}
+
+func TestAppGroupDependents(t *testing.T) {
+	lma := &pb.AppGroup{AppGroupId: helper.GenerateApplicaionGroupId(), Type: pb.AppGroupType_LMA, Status: pb.AppGroupStatus_APP_GROUP_RUNNING}
+	efk := &pb.AppGroup{AppGroupId: helper.GenerateApplicaionGroupId(), Type: pb.AppGroupType_LMA_EFK, Status: pb.AppGroupStatus_APP_GROUP_RUNNING}
+	serviceMesh := &pb.AppGroup{AppGroupId: helper.GenerateApplicaionGroupId(), Type: pb.AppGroupType_SERVICE_MESH, Status: pb.AppGroupStatus_APP_GROUP_RUNNING}
+
+	require.Equal(t, []string{serviceMesh.AppGroupId}, appGroupDependents(lma, []*pb.AppGroup{lma, serviceMesh}, map[string]bool{}))
+	require.Empty(t, appGroupDependents(lma, []*pb.AppGroup{lma, serviceMesh}, map[string]bool{serviceMesh.AppGroupId: true}))
+	// Another app group of the same name keeps the service mesh working.
+	require.Empty(t, appGroupDependents(lma, []*pb.AppGroup{lma, efk, serviceMesh}, map[string]bool{}))
+	require.Empty(t, appGroupDependents(serviceMesh, []*pb.AppGroup{lma, serviceMesh}, map[string]bool{}))
+}
+
+func TestInstallAppGroupsWithDependencies(t *testing.T) {
+	testCases := []struct {
+		name          string
+		lmaStatus     pb.AppGroupStatus
+		buildStubs    func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient)
+		expectedPhase string
+	}{
+		{
+			name:      "OK",
+			lmaStatus: pb.AppGroupStatus_APP_GROUP_RUNNING,
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-service-mesh", "argo", gomock.Any()).Times(1).
+					Return("service-mesh-workflow", nil)
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateAppGroupStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.AppGroupStatus_APP_GROUP_INSTALLING, in.GetStatus())
+						require.Equal(t, "service-mesh-workflow", in.GetWorkflowId())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+			expectedPhase: operationPhaseRunning,
+		},
+		{
+			name:      "DEPENDENCY_FAILED",
+			lmaStatus: pb.AppGroupStatus_APP_GROUP_ERROR,
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateAppGroupStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.AppGroupStatus_APP_GROUP_ERROR, in.GetStatus())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+			expectedPhase: operationPhaseFailed,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+			appInfoClient = mockAppInfoClient
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
+			operations = newTestOperationStore(t)
+
+			clusterId := helper.GenerateClusterId()
+			lmaId := helper.GenerateApplicaionGroupId()
+			serviceMeshId := helper.GenerateApplicaionGroupId()
+
+			mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(2).
+				Return(&pb.GetClusterResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+				Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			mockAppInfoClient.EXPECT().CreateAppGroup(gomock.Any(), gomock.Any()).Times(2).
+				DoAndReturn(func(ctx context.Context, in *pb.CreateAppGroupRequest, opts ...interface{}) (*pb.IDResponse, error) {
+					if in.GetAppGroup().GetType() == pb.AppGroupType_LMA {
+						return &pb.IDResponse{Code: pb.Code_OK_UNSPECIFIED, Id: lmaId}, nil
+					}
+					return &pb.IDResponse{Code: pb.Code_OK_UNSPECIFIED, Id: serviceMeshId}, nil
+				})
+
+			// The LMA is submitted at once, and the service mesh waits for it without a workflow.
+			mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-lma-federation", "argo", gomock.Any()).Times(1).
+				Return("lma-workflow", nil)
+			mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), &pb.UpdateAppGroupStatusRequest{
+				AppGroupId: lmaId,
+				Status:     pb.AppGroupStatus_APP_GROUP_INSTALLING,
+				WorkflowId: "lma-workflow",
+			}).Times(1).Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), &pb.UpdateAppGroupStatusRequest{
+				AppGroupId: serviceMeshId,
+				Status:     pb.AppGroupStatus_APP_GROUP_INSTALLING,
+			}).Times(1).Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			mockArgoClient.EXPECT().GetWorkflow("argo", "lma-workflow").Times(1).
+				Return(workflowWithPhase("Running"), nil)
+			mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), &pb.GetAppGroupRequest{AppGroupId: lmaId}).Times(1).
+				Return(&pb.GetAppGroupResponse{
+					Code:     pb.Code_OK_UNSPECIFIED,
+					AppGroup: &pb.AppGroup{AppGroupId: lmaId, Status: tc.lmaStatus},
+				}, nil)
+			tc.buildStubs(mockArgoClient, mockAppInfoClient)
+
+			s := server{}
+			res, err := s.InstallAppGroups(context.Background(), &pb.InstallAppGroupsRequest{
+				AppGroups: []*pb.AppGroup{
+					{
+						AppGroupName:  randomString("APPGROUP"),
+						Type:          pb.AppGroupType_SERVICE_MESH,
+						ClusterId:     clusterId,
+						ExternalLabel: randomString("EXTERNAL_LABEL"),
+					},
+					{
+						AppGroupName:  randomString("APPGROUP"),
+						Type:          pb.AppGroupType_LMA,
+						ClusterId:     clusterId,
+						ExternalLabel: randomString("EXTERNAL_LABEL"),
+					},
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+			require.Equal(t, []string{serviceMeshId, lmaId}, res.Ids)
+
+			op, err := operations.last(serviceMeshId, operationInstallAppGroup)
+			require.NoError(t, err)
+			require.NotNil(t, op.Pending)
+			require.Empty(t, op.WorkflowId)
+
+			// The reconciler submits the installation once the LMA is running, even after a restart.
+			operations, err = newOperationStore(operations.store, 0)
+			require.NoError(t, err)
+			newReconciler(time.Second, time.Hour, time.Hour).reconcileOperations(context.Background())
+
+			op, err = operations.last(serviceMeshId, operationInstallAppGroup)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedPhase, op.Phase)
+			require.Nil(t, op.Pending)
+			require.Equal(t, tc.expectedPhase == operationPhaseRunning, op.WorkflowId != "")
+		})
+	}
+}
+
+func TestInstallAppGroupsWithoutDependency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockArgoClient := mockargo.NewMockClient(ctrl)
+	argowfClient = mockArgoClient
+	mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+	appInfoClient = mockAppInfoClient
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+
+	mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.GetClusterResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+	mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+	s := server{}
+	res, err := s.InstallAppGroups(context.Background(), &pb.InstallAppGroupsRequest{
+		AppGroups: []*pb.AppGroup{
+			{
+				AppGroupName:  randomString("APPGROUP"),
+				Type:          pb.AppGroupType_SERVICE_MESH,
+				ClusterId:     helper.GenerateClusterId(),
+				ExternalLabel: randomString("EXTERNAL_LABEL"),
+			},
+		},
+	})
+	require.Error(t, err)
+	require.Equal(t, pb.Code_FAILED_PRECONDITION, res.Code)
+	require.Empty(t, res.Ids)
+}
+
+func TestUninstallAppGroupsWithDependents(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+	lma := &pb.AppGroup{
+		AppGroupId: helper.GenerateApplicaionGroupId(),
+		ClusterId:  clusterId,
+		Type:       pb.AppGroupType_LMA,
+		Status:     pb.AppGroupStatus_APP_GROUP_RUNNING,
+	}
+	serviceMesh := &pb.AppGroup{
+		AppGroupId: helper.GenerateApplicaionGroupId(),
+		ClusterId:  clusterId,
+		Type:       pb.AppGroupType_SERVICE_MESH,
+		Status:     pb.AppGroupStatus_APP_GROUP_RUNNING,
+	}
+
+	testCases := []struct {
+		name         string
+		appGroupIds  []string
+		buildStubs   func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient)
+		expectedCode pb.Code
+		expectedIds  []string
+	}{
+		{
+			name:        "DEPENDED_ON",
+			appGroupIds: []string{lma.AppGroupId},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			},
+			expectedCode: pb.Code_FAILED_PRECONDITION,
+			expectedIds:  []string{},
+		},
+		{
+			name:        "WITH_DEPENDENTS",
+			appGroupIds: []string{lma.AppGroupId, serviceMesh.AppGroupId},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				// The service mesh is removed before the LMA.
+				gomock.InOrder(
+					mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-remove-servicemesh", "argo", gomock.Any()).Times(1).
+						Return("remove-servicemesh-workflow", nil),
+					mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-remove-lma-federation", "argo", gomock.Any()).Times(1).
+						Return("remove-lma-workflow", nil),
+				)
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(2).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+			expectedCode: pb.Code_OK_UNSPECIFIED,
+			expectedIds:  []string{lma.AppGroupId, serviceMesh.AppGroupId},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+			appInfoClient = mockAppInfoClient
+			operations = newTestOperationStore(t)
+
+			mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).AnyTimes().
+				DoAndReturn(func(ctx context.Context, in *pb.GetAppGroupRequest, opts ...interface{}) (*pb.GetAppGroupResponse, error) {
+					appGroup := lma
+					if in.GetAppGroupId() == serviceMesh.AppGroupId {
+						appGroup = serviceMesh
+					}
+					return &pb.GetAppGroupResponse{Code: pb.Code_OK_UNSPECIFIED, AppGroup: appGroup}, nil
+				})
+			mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+				Return(&pb.GetAppGroupsResponse{
+					Code:      pb.Code_OK_UNSPECIFIED,
+					AppGroups: []*pb.AppGroup{lma, serviceMesh},
+				}, nil)
+			tc.buildStubs(mockArgoClient, mockAppInfoClient)
+
+			s := server{}
+			res, err := s.UninstallAppGroups(context.Background(), &pb.UninstallAppGroupsRequest{AppGroupIds: tc.appGroupIds})
+			require.Equal(t, tc.expectedCode == pb.Code_OK_UNSPECIFIED, err == nil)
+			require.Equal(t, tc.expectedCode, res.Code)
+			require.Equal(t, tc.expectedIds, res.Ids)
+		})
+	}
+}
+
+func TestUninstallAppGroupsDependencyCycle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+	appInfoClient = mockAppInfoClient
+
+	// A registry is never loaded with a cycle, so it is built here.
+	registry := appGroupTypes
+	defer func() { appGroupTypes = registry }()
+	appGroupTypes = &appGroupTypeRegistry{types: map[string]*appGroupType{
+		pb.AppGroupType_LMA.String():          {Name: "lma", DependsOn: []string{"service-mesh"}},
+		pb.AppGroupType_SERVICE_MESH.String(): {Name: "service-mesh", DependsOn: []string{"lma"}},
+	}}
+
+	clusterId := helper.GenerateClusterId()
+	appGroups := map[string]*pb.AppGroup{}
+	for _, appGroupType := range []pb.AppGroupType{pb.AppGroupType_LMA, pb.AppGroupType_SERVICE_MESH} {
+		appGroup := &pb.AppGroup{AppGroupId: helper.GenerateApplicaionGroupId(), ClusterId: clusterId, Type: appGroupType}
+		appGroups[appGroup.AppGroupId] = appGroup
+	}
+	mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(2).
+		DoAndReturn(func(ctx context.Context, in *pb.GetAppGroupRequest, opts ...interface{}) (*pb.GetAppGroupResponse, error) {
+			return &pb.GetAppGroupResponse{Code: pb.Code_OK_UNSPECIFIED, AppGroup: appGroups[in.GetAppGroupId()]}, nil
+		})
+
+	stream := &fakeServerTransportStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	appGroupIds := []string{}
+	for appGroupId := range appGroups {
+		appGroupIds = append(appGroupIds, appGroupId)
+	}
+
+	s := server{}
+	res, err := s.UninstallAppGroups(ctx, &pb.UninstallAppGroupsRequest{AppGroupIds: appGroupIds})
+	require.Error(t, err)
+	require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+
+	// Every app group has its own result.
+	results := []*AppGroupResult{}
+	require.NoError(t, json.Unmarshal([]byte(stream.trailer.Get(appGroupResultsMetadata)[0]), &results))
+	require.Len(t, results, 2)
+	for i, result := range results {
+		require.Equal(t, appGroupIds[i], result.AppGroupId)
+		require.Equal(t, pb.Code_INVALID_ARGUMENT.String(), result.Code)
+	}
+}
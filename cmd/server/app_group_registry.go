@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+var workflowParameterRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// appGroupTypeRegistry is a registry of app group types, loaded from a JSON file.
+// It is keyed on the name of pb.AppGroupType. eg) LMA
+type appGroupTypeRegistry struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	types   map[string]*appGroupType
+}
+
+// appGroupType declares the workflows of an app group type.
+type appGroupType struct {
+	// Name is the app_group parameter of the uninstall workflow. eg) lma
+	Name              string `json:"name"`
+	InstallTemplate   string `json:"installTemplate"`
+	UninstallTemplate string `json:"uninstallTemplate"`
+	// UpgradeTemplate moves an installed app group to another revision. The type can not be upgraded without it.
+	UpgradeTemplate string `json:"upgradeTemplate,omitempty"`
+	// Parameters are added to the parameters of the install workflow. eg) {"logging_component": "loki"}
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// ExternalLabelPattern is a regular expression which the external label of the app group must match.
+	ExternalLabelPattern string `json:"externalLabelPattern,omitempty"`
+	// DependsOn are the names of app groups which must be installed in the cluster before this one. eg) ["lma"]
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Values are the custom values which the install workflow accepts, keyed by the name of the value.
+	Values map[string]*appGroupValueSchema `json:"values,omitempty"`
+
+	externalLabelRegex *regexp.Regexp
+}
+
+func newAppGroupTypeRegistry(path string) (*appGroupTypeRegistry, error) {
+	r := &appGroupTypeRegistry{path: path}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *appGroupTypeRegistry) load() error {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	types := map[string]*appGroupType{}
+	if err := json.Unmarshal(data, &types); err != nil {
+		return fmt.Errorf("failed to parse app group type registry %s : %s", r.path, err)
+	}
+	for name, t := range types {
+		if t == nil {
+			return fmt.Errorf("invalid app group type %s : null", name)
+		}
+		if err := t.compile(); err != nil {
+			return fmt.Errorf("invalid app group type %s : %s", name, err)
+		}
+	}
+	if err := validateAppGroupDependencies(types); err != nil {
+		return fmt.Errorf("invalid app group type registry %s : %s", r.path, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types = types
+	r.modTime = fi.ModTime()
+	return nil
+}
+
+// watch reloads the registry whenever the file is modified.
+// The previous registry is kept if the modified file is invalid.
+func (r *appGroupTypeRegistry) watch(ctx context.Context, interval time.Duration) {
+	watchFile(ctx, r.path, interval, r.lastModified, r.load)
+}
+
+func (r *appGroupTypeRegistry) lastModified() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.modTime
+}
+
+// get returns the declaration of the app group type.
+func (r *appGroupTypeRegistry) get(appGroupType pb.AppGroupType) (*appGroupType, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.types[appGroupType.String()]
+	if !ok {
+		return nil, fmt.Errorf("invalid appGroup type %s", appGroupType)
+	}
+	return t, nil
+}
+
+// hasDependents reports whether any app group type depends on the app group type.
+func (r *appGroupTypeRegistry) hasDependents(appGroupType pb.AppGroupType) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.types[appGroupType.String()]
+	if !ok {
+		return false
+	}
+	for _, other := range r.types {
+		if containsString(other.DependsOn, t.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *appGroupType) compile() error {
+	if t.Name == "" || t.InstallTemplate == "" || t.UninstallTemplate == "" {
+		return fmt.Errorf("name, installTemplate and uninstallTemplate must have value")
+	}
+	for key := range t.Parameters {
+		if !workflowParameterRegex.MatchString(key) {
+			return fmt.Errorf("invalid parameter name %s", key)
+		}
+	}
+	if t.ExternalLabelPattern != "" {
+		regex, err := regexp.Compile(t.ExternalLabelPattern)
+		if err != nil {
+			return fmt.Errorf("invalid externalLabelPattern : %s", err)
+		}
+		t.externalLabelRegex = regex
+	}
+	for key, value := range t.Values {
+		if !workflowParameterRegex.MatchString(key) {
+			return fmt.Errorf("invalid value name %s", key)
+		}
+		if value == nil {
+			return fmt.Errorf("value %s has no type", key)
+		}
+		if err := value.compile(); err != nil {
+			return fmt.Errorf("value %s : %s", key, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the app group against the declaration of its type.
+func (t *appGroupType) validate(appGroup *pb.AppGroup) error {
+	if t.externalLabelRegex != nil && !t.externalLabelRegex.MatchString(appGroup.GetExternalLabel()) {
+		return fmt.Errorf("ExternalLabel %s of %s must match %s", appGroup.GetExternalLabel(), appGroup.GetType(), t.ExternalLabelPattern)
+	}
+	return nil
+}
+
+// installParameters returns the extra parameters of the install workflow, ordered by name.
+func (t *appGroupType) installParameters() []string {
+	keys := make([]string, 0, len(t.Parameters))
+	for key := range t.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		params = append(params, key+"="+t.Parameters[key])
+	}
+	return params
+}
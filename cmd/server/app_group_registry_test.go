@@ -0,0 +1,99 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+func TestAppGroupTypeRegistry(t *testing.T) {
+	r, err := newAppGroupTypeRegistry("testdata/app-group-types.json")
+	require.NoError(t, err)
+
+	lma, err := r.get(pb.AppGroupType_LMA)
+	require.NoError(t, err)
+	require.Equal(t, "tks-lma-federation", lma.InstallTemplate)
+	require.Equal(t, "tks-remove-lma-federation", lma.UninstallTemplate)
+	require.Equal(t, "tks-upgrade-lma-federation", lma.UpgradeTemplate)
+	require.Equal(t, []string{"logging_component=loki"}, lma.installParameters())
+
+	efk, err := r.get(pb.AppGroupType_LMA_EFK)
+	require.NoError(t, err)
+	require.Equal(t, []string{"logging_component=efk"}, efk.installParameters())
+
+	serviceMesh, err := r.get(pb.AppGroupType_SERVICE_MESH)
+	require.NoError(t, err)
+	require.Equal(t, "service-mesh", serviceMesh.Name)
+	require.Equal(t, []string{"lma"}, serviceMesh.DependsOn)
+	require.Empty(t, serviceMesh.installParameters())
+
+	_, err = r.get(pb.AppGroupType_APP_TYPE_UNSPECIFIED)
+	require.Error(t, err)
+}
+
+func TestAppGroupTypeRegistryInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app-group-types.json")
+
+	testCases := []struct {
+		name     string
+		registry string
+	}{
+		{
+			name:     "NO_UNINSTALL_TEMPLATE",
+			registry: `{"LMA": {"name": "lma", "installTemplate": "tks-lma-federation"}}`,
+		},
+		{
+			name:     "NULL_TYPE",
+			registry: `{"LMA": null}`,
+		},
+		{
+			name:     "INVALID_PARAMETER_NAME",
+			registry: `{"LMA": {"name": "lma", "installTemplate": "a", "uninstallTemplate": "b", "parameters": {"logging-component": "loki"}}}`,
+		},
+		{
+			name:     "INVALID_EXTERNAL_LABEL_PATTERN",
+			registry: `{"LMA": {"name": "lma", "installTemplate": "a", "uninstallTemplate": "b", "externalLabelPattern": "("}}`,
+		},
+		{
+			name:     "UNKNOWN_DEPENDENCY",
+			registry: `{"SERVICE_MESH": {"name": "service-mesh", "installTemplate": "a", "uninstallTemplate": "b", "dependsOn": ["lma"]}}`,
+		},
+		{
+			name: "DEPENDENCY_CYCLE",
+			registry: `{"LMA": {"name": "lma", "installTemplate": "a", "uninstallTemplate": "b", "dependsOn": ["service-mesh"]},
+			"SERVICE_MESH": {"name": "service-mesh", "installTemplate": "c", "uninstallTemplate": "d", "dependsOn": ["lma"]}}`,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			writeTestFile(t, path, tc.registry)
+			_, err := newAppGroupTypeRegistry(path)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestAppGroupTypeValidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app-group-types.json")
+	writeTestFile(t, path, `{
+  "SERVICE_MESH": {
+    "name": "service-mesh",
+    "installTemplate": "tks-service-mesh",
+    "uninstallTemplate": "tks-remove-servicemesh",
+    "externalLabelPattern": "^[a-z0-9-]+$"
+  }
+}`)
+	r, err := newAppGroupTypeRegistry(path)
+	require.NoError(t, err)
+
+	serviceMesh, err := r.get(pb.AppGroupType_SERVICE_MESH)
+	require.NoError(t, err)
+	require.NoError(t, serviceMesh.validate(&pb.AppGroup{Type: pb.AppGroupType_SERVICE_MESH, ExternalLabel: "mesh-1"}))
+	require.Error(t, serviceMesh.validate(&pb.AppGroup{Type: pb.AppGroupType_SERVICE_MESH, ExternalLabel: "Mesh 1"}))
+}
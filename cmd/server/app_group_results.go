@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/openinfradev/tks-common/pkg/log"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// appGroupResultsMetadata is a response header and trailer of InstallAppGroups and UninstallAppGroups.
+// It carries a JSON array of the results of the app groups, in the order of the request.
+const appGroupResultsMetadata = "x-app-group-results"
+
+// partialSuccessCode is the code of a response whose request is carried out for some of the app groups only.
+// The IDs of the response are the ones which succeeded, and the results metadata tells why the others failed.
+// Only the failed app groups are to be requested again, since the others are already in progress.
+const partialSuccessCode = pb.Code_ABORTED
+
+// AppGroupResult is the result of an app group of InstallAppGroups or UninstallAppGroups.
+type AppGroupResult struct {
+	// Index is the index of the app group in the request.
+	Index      int    `json:"index"`
+	AppGroupId string `json:"appGroupId,omitempty"`
+	// WorkflowId is empty while the installation waits for the dependencies of the app group.
+	WorkflowId string `json:"workflowId,omitempty"`
+	// Code is the name of pb.Code. eg) NOT_FOUND
+	Code  string `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+type appGroupResults []*AppGroupResult
+
+func newAppGroupResults(n int) appGroupResults {
+	results := make(appGroupResults, n)
+	for i := range results {
+		results[i] = &AppGroupResult{Index: i, Code: pb.Code_UNKNOWN.String(), Error: "not processed"}
+	}
+	return results
+}
+
+func (r appGroupResults) succeed(index int, appGroupId string, workflowId string) {
+	r[index].AppGroupId = appGroupId
+	r[index].WorkflowId = workflowId
+	r[index].Code = pb.Code_OK_UNSPECIFIED.String()
+	r[index].Error = ""
+}
+
+func (r appGroupResults) fail(index int, appGroupId string, code pb.Code, err error) {
+	r[index].AppGroupId = appGroupId
+	r[index].Code = code.String()
+	r[index].Error = fmt.Sprint(err)
+}
+
+// response sends the results with the header and trailer, and returns the response of the results.
+// The code of the response is
+//   - OK_UNSPECIFIED if all of the app groups succeeded,
+//   - partialSuccessCode if some of them succeeded,
+//   - the code of the failures if none succeeded, or INTERNAL if the failures have different codes.
+//
+// The error message of the response lists the failures, and only a total failure returns an error.
+func (r appGroupResults) response(ctx context.Context) (*pb.IDsResponse, error) {
+	if data, err := json.Marshal(r); err != nil {
+		log.Error("Failed to marshal app group results. err : ", err)
+	} else {
+		// Setting metadata fails when the context is not a grpc server stream (eg. unit tests).
+		_ = grpc.SetHeader(ctx, metadata.Pairs(appGroupResultsMetadata, string(data)))
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(appGroupResultsMetadata, string(data)))
+	}
+
+	ids := []string{}
+	failures := []string{}
+	failedCode := ""
+	for _, result := range r {
+		if result.Code == pb.Code_OK_UNSPECIFIED.String() {
+			ids = append(ids, result.AppGroupId)
+			continue
+		}
+		failures = append(failures, fmt.Sprintf("#%d : %s", result.Index, result.Error))
+		if failedCode == "" {
+			failedCode = result.Code
+		} else if failedCode != result.Code {
+			failedCode = pb.Code_INTERNAL.String()
+		}
+	}
+
+	if len(failures) == 0 {
+		return &pb.IDsResponse{
+			Code:  pb.Code_OK_UNSPECIFIED,
+			Error: nil,
+			Ids:   ids,
+		}, nil
+	}
+
+	msg := fmt.Sprintf("%d of %d app groups failed. %s", len(failures), len(r), strings.Join(failures, ", "))
+	if len(ids) > 0 {
+		return &pb.IDsResponse{
+			Code: partialSuccessCode,
+			Error: &pb.Error{
+				Msg: msg,
+			},
+			Ids: ids,
+		}, nil
+	}
+	return &pb.IDsResponse{
+		Code: pb.Code(pb.Code_value[failedCode]),
+		Error: &pb.Error{
+			Msg: msg,
+		},
+		Ids: ids,
+	}, errors.New(msg)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// fakeServerTransportStream keeps the header and trailer which the handler sets.
+type fakeServerTransportStream struct {
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (s *fakeServerTransportStream) Method() string { return "" }
+func (s *fakeServerTransportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+func (s *fakeServerTransportStream) SendHeader(md metadata.MD) error { return nil }
+func (s *fakeServerTransportStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+func TestAppGroupResults(t *testing.T) {
+	testCases := []struct {
+		name         string
+		build        func(results appGroupResults)
+		expectedCode pb.Code
+		expectedIds  []string
+	}{
+		{
+			name: "OK",
+			build: func(results appGroupResults) {
+				results.succeed(0, "app-group-0", "workflow-0")
+				results.succeed(1, "app-group-1", "")
+			},
+			expectedCode: pb.Code_OK_UNSPECIFIED,
+			expectedIds:  []string{"app-group-0", "app-group-1"},
+		},
+		{
+			name: "PARTIALLY_SUCCESS",
+			build: func(results appGroupResults) {
+				results.fail(0, "", pb.Code_NOT_FOUND, errors.New("NOT_EXIST_CLUSTER_ID"))
+				results.succeed(1, "app-group-1", "workflow-1")
+			},
+			expectedCode: pb.Code_ABORTED,
+			expectedIds:  []string{"app-group-1"},
+		},
+		{
+			name: "FAILED_WITH_SAME_CODE",
+			build: func(results appGroupResults) {
+				results.fail(0, "", pb.Code_NOT_FOUND, errors.New("NOT_EXIST_CLUSTER_ID"))
+				results.fail(1, "", pb.Code_NOT_FOUND, errors.New("NOT_EXIST_CLUSTER_ID"))
+			},
+			expectedCode: pb.Code_NOT_FOUND,
+			expectedIds:  []string{},
+		},
+		{
+			name: "FAILED_WITH_DIFFERENT_CODES",
+			build: func(results appGroupResults) {
+				results.fail(0, "", pb.Code_NOT_FOUND, errors.New("NOT_EXIST_CLUSTER_ID"))
+				results.fail(1, "app-group-1", pb.Code_FAILED_PRECONDITION, errors.New("DEPENDENCY_NOT_INSTALLED"))
+			},
+			expectedCode: pb.Code_INTERNAL,
+			expectedIds:  []string{},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			stream := &fakeServerTransportStream{}
+			ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+
+			results := newAppGroupResults(2)
+			tc.build(results)
+			res, err := results.response(ctx)
+			require.Equal(t, tc.expectedCode, res.Code)
+			require.Equal(t, tc.expectedIds, res.Ids)
+			require.Equal(t, tc.name == "OK", res.Error == nil)
+			require.Equal(t, len(tc.expectedIds) > 0, err == nil)
+
+			for _, md := range []metadata.MD{stream.header, stream.trailer} {
+				values := md.Get(appGroupResultsMetadata)
+				require.Len(t, values, 1)
+				sent := []*AppGroupResult{}
+				require.NoError(t, json.Unmarshal([]byte(values[0]), &sent))
+				require.Equal(t, []*AppGroupResult(results), sent)
+			}
+		})
+	}
+}
+
+func TestAppGroupResultsNotProcessed(t *testing.T) {
+	results := newAppGroupResults(1)
+	res, err := results.response(context.Background())
+	require.Error(t, err)
+	require.Equal(t, pb.Code_UNKNOWN, res.Code)
+	require.Equal(t, pb.Code_UNKNOWN.String(), results[0].Code)
+}
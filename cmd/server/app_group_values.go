@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// appGroupValuesHeader is an optional request header of InstallAppGroups.
+// It carries a JSON object of the custom values, keyed by the name of the app group.
+// eg) {"lma-1": {"loki_retention": "168h"}, "mesh-1": {"istio_profile": "minimal"}}
+const appGroupValuesHeader = "x-app-group-values"
+
+// appGroupValuesParameter is the install workflow parameter which carries the values as a JSON object.
+const appGroupValuesParameter = "app_group_values"
+
+// Types of the custom values of app groups.
+const (
+	valueTypeString  = "string"
+	valueTypeInteger = "integer"
+	valueTypeBoolean = "boolean"
+)
+
+// maxAppGroupValueLength limits the length of a string value.
+const maxAppGroupValueLength = 1024
+
+// appGroupValueSchema declares a custom value which the app group type accepts.
+type appGroupValueSchema struct {
+	Type string `json:"type"`
+	// Pattern is a regular expression which a string value must match.
+	Pattern string `json:"pattern,omitempty"`
+	// Minimum and Maximum limit an integer value.
+	Minimum *int64 `json:"minimum,omitempty"`
+	Maximum *int64 `json:"maximum,omitempty"`
+
+	patternRegex *regexp.Regexp
+}
+
+func (v *appGroupValueSchema) compile() error {
+	switch v.Type {
+	case valueTypeString:
+		if v.Minimum != nil || v.Maximum != nil {
+			return fmt.Errorf("minimum and maximum are only for %s values", valueTypeInteger)
+		}
+		if v.Pattern != "" {
+			regex, err := regexp.Compile(v.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern : %s", err)
+			}
+			v.patternRegex = regex
+		}
+	case valueTypeInteger, valueTypeBoolean:
+		if v.Pattern != "" {
+			return fmt.Errorf("pattern is only for %s values", valueTypeString)
+		}
+		if v.Type == valueTypeBoolean && (v.Minimum != nil || v.Maximum != nil) {
+			return fmt.Errorf("minimum and maximum are only for %s values", valueTypeInteger)
+		}
+		if v.Minimum != nil && v.Maximum != nil && *v.Minimum > *v.Maximum {
+			return fmt.Errorf("minimum %d is greater than maximum %d", *v.Minimum, *v.Maximum)
+		}
+	default:
+		return fmt.Errorf("invalid type %s", v.Type)
+	}
+	return nil
+}
+
+// validate checks the value against the schema and returns it as the type of the schema.
+func (v *appGroupValueSchema) validate(value interface{}) (interface{}, error) {
+	switch v.Type {
+	case valueTypeString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a %s", valueTypeString)
+		}
+		if len(s) > maxAppGroupValueLength {
+			return nil, fmt.Errorf("must not be longer than %d", maxAppGroupValueLength)
+		}
+		if v.patternRegex != nil && !v.patternRegex.MatchString(s) {
+			return nil, fmt.Errorf("must match %s", v.Pattern)
+		}
+		return s, nil
+	case valueTypeInteger:
+		n, ok := value.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("must be an %s", valueTypeInteger)
+		}
+		i, err := strconv.ParseInt(n.String(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be an %s", valueTypeInteger)
+		}
+		if v.Minimum != nil && i < *v.Minimum {
+			return nil, fmt.Errorf("must not be less than %d", *v.Minimum)
+		}
+		if v.Maximum != nil && i > *v.Maximum {
+			return nil, fmt.Errorf("must not be greater than %d", *v.Maximum)
+		}
+		return i, nil
+	case valueTypeBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("must be a %s", valueTypeBoolean)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("invalid type %s", v.Type)
+}
+
+// getAppGroupValues returns the custom values of the incoming request header, if any.
+// Numbers are kept as json.Number to tell integers from the others.
+func getAppGroupValues(ctx context.Context) (map[string]map[string]interface{}, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	values := md.Get(appGroupValuesHeader)
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	appGroupValues := map[string]map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(values[0])))
+	decoder.UseNumber()
+	if err := decoder.Decode(&appGroupValues); err != nil {
+		return nil, fmt.Errorf("invalid %s header : %s", appGroupValuesHeader, err)
+	}
+	return appGroupValues, nil
+}
+
+// validateAppGroupValues checks the custom values against the schemas of the app group types.
+// The values of each app group are returned as the parameter of the install workflow.
+// Values must be for exactly one app group of the request.
+func validateAppGroupValues(appGroups []*pb.AppGroup, appGroupValues map[string]map[string]interface{}) (map[*pb.AppGroup]string, error) {
+	byName := map[string][]*pb.AppGroup{}
+	for _, appGroup := range appGroups {
+		byName[appGroup.GetAppGroupName()] = append(byName[appGroup.GetAppGroupName()], appGroup)
+	}
+
+	parameters := map[*pb.AppGroup]string{}
+	for name, values := range appGroupValues {
+		if len(byName[name]) == 0 {
+			return nil, fmt.Errorf("no app group %s for the values of the %s header", name, appGroupValuesHeader)
+		}
+		if len(byName[name]) > 1 {
+			return nil, fmt.Errorf("the values of the %s header are ambiguous for the app groups named %s", appGroupValuesHeader, name)
+		}
+		appGroup := byName[name][0]
+		t, err := appGroupTypes.get(appGroup.GetType())
+		if err != nil {
+			return nil, err
+		}
+
+		validated := map[string]interface{}{}
+		for key, value := range values {
+			schema, ok := t.Values[key]
+			if !ok {
+				return nil, fmt.Errorf("%s does not accept the value %s", appGroup.GetType(), key)
+			}
+			v, err := schema.validate(value)
+			if err != nil {
+				return nil, fmt.Errorf("value %s of app group %s %s", key, name, err)
+			}
+			validated[key] = v
+		}
+		if len(validated) == 0 {
+			continue
+		}
+
+		// The keys of the JSON object are sorted.
+		data, err := json.Marshal(validated)
+		if err != nil {
+			return nil, err
+		}
+		parameters[appGroup] = appGroupValuesParameter + "=" + string(data)
+	}
+	return parameters, nil
+}
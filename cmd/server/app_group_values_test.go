@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/openinfradev/tks-common/pkg/argowf"
+	mockargo "github.com/openinfradev/tks-common/pkg/argowf/mock"
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+	mocktks "github.com/openinfradev/tks-proto/tks_pb/mock"
+)
+
+func TestAppGroupValueSchemaInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app-group-types.json")
+
+	testCases := []struct {
+		name   string
+		values string
+	}{
+		{
+			name:   "INVALID_TYPE",
+			values: `{"loki_retention": {"type": "duration"}}`,
+		},
+		{
+			name:   "INVALID_VALUE_NAME",
+			values: `{"loki-retention": {"type": "string"}}`,
+		},
+		{
+			name:   "INVALID_PATTERN",
+			values: `{"loki_retention": {"type": "string", "pattern": "("}}`,
+		},
+		{
+			name:   "PATTERN_OF_INTEGER",
+			values: `{"replicas": {"type": "integer", "pattern": "^[0-9]$"}}`,
+		},
+		{
+			name:   "MINIMUM_GREATER_THAN_MAXIMUM",
+			values: `{"replicas": {"type": "integer", "minimum": 5, "maximum": 1}}`,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			writeTestFile(t, path, `{"LMA": {"name": "lma", "installTemplate": "a", "uninstallTemplate": "b", "values": `+tc.values+`}}`)
+			_, err := newAppGroupTypeRegistry(path)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestValidateAppGroupValues(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+	lma := &pb.AppGroup{AppGroupName: "lma", Type: pb.AppGroupType_LMA, ClusterId: clusterId}
+	efk := &pb.AppGroup{AppGroupName: "efk", Type: pb.AppGroupType_LMA_EFK, ClusterId: helper.GenerateClusterId()}
+	serviceMesh := &pb.AppGroup{AppGroupName: "mesh", Type: pb.AppGroupType_SERVICE_MESH, ClusterId: clusterId}
+	otherServiceMesh := &pb.AppGroup{AppGroupName: "mesh", Type: pb.AppGroupType_SERVICE_MESH, ClusterId: helper.GenerateClusterId()}
+
+	testCases := []struct {
+		name               string
+		appGroups          []*pb.AppGroup
+		header             string
+		expectedParameters map[*pb.AppGroup]string
+		expectedError      bool
+	}{
+		{
+			name:      "OK",
+			appGroups: []*pb.AppGroup{lma, efk, serviceMesh},
+			header:    `{"lma": {"prometheus_retention": "30d", "loki_retention": "168h"}, "efk": {"elasticsearch_replicas": 3}, "mesh": {"istio_profile": "minimal", "tracing_enabled": true}}`,
+			expectedParameters: map[*pb.AppGroup]string{
+				lma:         `app_group_values={"loki_retention":"168h","prometheus_retention":"30d"}`,
+				efk:         `app_group_values={"elasticsearch_replicas":3}`,
+				serviceMesh: `app_group_values={"istio_profile":"minimal","tracing_enabled":true}`,
+			},
+		},
+		{
+			name:               "NO_HEADER",
+			appGroups:          []*pb.AppGroup{lma},
+			expectedParameters: map[*pb.AppGroup]string{},
+		},
+		{
+			name:          "INVALID_JSON",
+			appGroups:     []*pb.AppGroup{lma},
+			header:        `{"lma": "168h"}`,
+			expectedError: true,
+		},
+		{
+			name:          "UNKNOWN_APP_GROUP",
+			appGroups:     []*pb.AppGroup{lma},
+			header:        `{"efk": {"elasticsearch_replicas": 3}}`,
+			expectedError: true,
+		},
+		{
+			name:          "AMBIGUOUS_APP_GROUP",
+			appGroups:     []*pb.AppGroup{serviceMesh, otherServiceMesh},
+			header:        `{"mesh": {"istio_profile": "minimal"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "UNKNOWN_VALUE",
+			appGroups:     []*pb.AppGroup{lma},
+			header:        `{"lma": {"elasticsearch_replicas": 3}}`,
+			expectedError: true,
+		},
+		{
+			name:          "PATTERN_MISMATCH",
+			appGroups:     []*pb.AppGroup{lma},
+			header:        `{"lma": {"loki_retention": "1 week"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "NOT_INTEGER",
+			appGroups:     []*pb.AppGroup{efk},
+			header:        `{"efk": {"elasticsearch_replicas": 1.5}}`,
+			expectedError: true,
+		},
+		{
+			name:          "OUT_OF_RANGE",
+			appGroups:     []*pb.AppGroup{efk},
+			header:        `{"efk": {"elasticsearch_replicas": 6}}`,
+			expectedError: true,
+		},
+		{
+			name:          "NOT_BOOLEAN",
+			appGroups:     []*pb.AppGroup{serviceMesh},
+			header:        `{"mesh": {"tracing_enabled": "true"}}`,
+			expectedError: true,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.header != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(appGroupValuesHeader, tc.header))
+			}
+
+			values, err := getAppGroupValues(ctx)
+			if err == nil {
+				var parameters map[*pb.AppGroup]string
+				parameters, err = validateAppGroupValues(tc.appGroups, values)
+				if !tc.expectedError {
+					require.Equal(t, tc.expectedParameters, parameters)
+				}
+			}
+			if tc.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestInstallAppGroupsWithValues(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockArgoClient := mockargo.NewMockClient(ctrl)
+	argowfClient = mockArgoClient
+	mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+	appInfoClient = mockAppInfoClient
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+	operations = newTestOperationStore(t)
+
+	appGroupId := helper.GenerateApplicaionGroupId()
+	mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.GetClusterResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+	mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+	mockAppInfoClient.EXPECT().CreateAppGroup(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.IDResponse{Code: pb.Code_OK_UNSPECIFIED, Id: appGroupId}, nil)
+	mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-lma-federation", "argo", gomock.Any()).Times(1).
+		DoAndReturn(func(workflowTemplate string, namespace string, opts argowf.SubmitOptions) (string, error) {
+			require.Contains(t, opts.Parameters, "logging_component=loki")
+			require.Contains(t, opts.Parameters, `app_group_values={"loki_retention":"168h"}`)
+			return "lma-workflow", nil
+		})
+	mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(appGroupValuesHeader, `{"lma": {"loki_retention": "168h"}}`))
+	s := server{}
+	res, err := s.InstallAppGroups(ctx, &pb.InstallAppGroupsRequest{
+		AppGroups: []*pb.AppGroup{
+			{
+				AppGroupName:  "lma",
+				Type:          pb.AppGroupType_LMA,
+				ClusterId:     helper.GenerateClusterId(),
+				ExternalLabel: randomString("EXTERNAL_LABEL"),
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+	require.Equal(t, []string{appGroupId}, res.Ids)
+
+	// An invalid value fails the whole request.
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(appGroupValuesHeader, `{"lma": {"loki_retention": "forever"}}`))
+	res, err = s.InstallAppGroups(ctx, &pb.InstallAppGroupsRequest{
+		AppGroups: []*pb.AppGroup{
+			{
+				AppGroupName:  "lma",
+				Type:          pb.AppGroupType_LMA,
+				ClusterId:     helper.GenerateClusterId(),
+				ExternalLabel: randomString("EXTERNAL_LABEL"),
+			},
+		},
+	})
+	require.Error(t, err)
+	require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+}
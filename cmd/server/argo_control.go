@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/openinfradev/tks-common/pkg/log"
+)
+
+// workflowController controls argo workflows which were already submitted.
+// argowf.Client of tks-common only submits and reads workflows, so this calls the argo server API directly.
+type workflowController interface {
+	TerminateWorkflow(namespace string, workflowName string) error
+	RetryWorkflow(namespace string, workflowName string) error
+}
+
+type argoWorkflowController struct {
+	client *http.Client
+	url    string
+}
+
+func newArgoWorkflowController(host string, port int) workflowController {
+	return &argoWorkflowController{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		url: fmt.Sprintf("http://%s:%d", host, port),
+	}
+}
+
+// TerminateWorkflow stops the workflow immediately without running exit handlers.
+func (c *argoWorkflowController) TerminateWorkflow(namespace string, workflowName string) error {
+	return c.action(namespace, workflowName, "terminate")
+}
+
+// RetryWorkflow reruns the failed workflow from its failed steps. The workflow keeps its name.
+func (c *argoWorkflowController) RetryWorkflow(namespace string, workflowName string) error {
+	return c.action(namespace, workflowName, "retry")
+}
+
+func (c *argoWorkflowController) action(namespace string, workflowName string, action string) error {
+	reqBody, err := json.Marshal(map[string]string{
+		"name":      workflowName,
+		"namespace": namespace,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/workflows/%s/%s/%s", c.url, namespace, workflowName, action), bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.Error("error closing http body")
+		}
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("failed to %s workflow %s. return code : %d, %s", action, workflowName, res.StatusCode, string(body))
+	}
+	return nil
+}
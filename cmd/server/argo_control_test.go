@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWorkflowController records the workflows it was asked to control.
+type fakeWorkflowController struct {
+	terminated []string
+	retried    []string
+	err        error
+}
+
+func (c *fakeWorkflowController) TerminateWorkflow(namespace string, workflowName string) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.terminated = append(c.terminated, workflowName)
+	return nil
+}
+
+func (c *fakeWorkflowController) RetryWorkflow(namespace string, workflowName string) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.retried = append(c.retried, workflowName)
+	return nil
+}
+
+func newTestArgoWorkflowController(t *testing.T, handler http.HandlerFunc) workflowController {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	return newArgoWorkflowController(u.Hostname(), port)
+}
+
+func TestArgoWorkflowControllerTerminate(t *testing.T) {
+	c := newTestArgoWorkflowController(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		if r.URL.Path != "/api/v1/workflows/argo/create-tks-usercluster-abcde/terminate" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	})
+
+	require.NoError(t, c.TerminateWorkflow("argo", "create-tks-usercluster-abcde"))
+	require.Error(t, c.TerminateWorkflow("argo", "not-existed"))
+}
+
+func TestArgoWorkflowControllerRetry(t *testing.T) {
+	c := newTestArgoWorkflowController(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/api/v1/workflows/argo/create-tks-usercluster-abcde/retry", r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message":"workflow must be Failed/Error to retry"}`))
+	})
+
+	err := c.RetryWorkflow("argo", "create-tks-usercluster-abcde")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "must be Failed/Error")
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/openinfradev/tks-common/pkg/log"
+)
+
+// sizePerAzHeader is an optional request header of CreateCluster and ScaleCluster.
+// It carries the node group sizes per AZ as JSON instead of the number of replicas.
+// eg) {"minSizePerAz": 1, "desiredSizePerAz": 2, "maxSizePerAz": 10}
+const sizePerAzHeader = "x-size-per-az"
+
+// autoscalingPolicy limits the node group sizes per AZ.
+type autoscalingPolicy struct {
+	// MaxSizeMultiplier derives the max size from the min size when the max size is not given.
+	MaxSizeMultiplier int `json:"maxSizeMultiplier,omitempty"`
+	// MaxSizePerAz is the hard ceiling of all sizes per AZ.
+	MaxSizePerAz int `json:"maxSizePerAz,omitempty"`
+	// DefaultMinSizePerAz is the min size when neither replicas nor sizes are given.
+	DefaultMinSizePerAz int `json:"defaultMinSizePerAz,omitempty"`
+}
+
+// autoscalingPolicyCatalog is a default autoscaling policy and the overrides per contract, loaded from a JSON file.
+type autoscalingPolicyCatalog struct {
+	mu            sync.RWMutex
+	path          string
+	modTime       time.Time
+	defaultPolicy autoscalingPolicy
+	contracts     map[string]autoscalingPolicy
+}
+
+type autoscalingPolicyFile struct {
+	Default autoscalingPolicy `json:"default"`
+	// Contracts are keyed on the contract ID. Fields which are not set are taken from the default policy.
+	Contracts map[string]autoscalingPolicy `json:"contracts,omitempty"`
+}
+
+// sizePerAz is the node group sizes per AZ.
+type sizePerAz struct {
+	MinSizePerAz     int `json:"minSizePerAz"`
+	DesiredSizePerAz int `json:"desiredSizePerAz,omitempty"`
+	MaxSizePerAz     int `json:"maxSizePerAz,omitempty"`
+}
+
+func newAutoscalingPolicyCatalog(path string) (*autoscalingPolicyCatalog, error) {
+	c := &autoscalingPolicyCatalog{path: path}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *autoscalingPolicyCatalog) load() error {
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	raw := autoscalingPolicyFile{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse autoscaling policy %s : %s", c.path, err)
+	}
+	if err := raw.Default.validate(); err != nil {
+		return fmt.Errorf("invalid default autoscaling policy : %s", err)
+	}
+	contracts := map[string]autoscalingPolicy{}
+	for contractId, override := range raw.Contracts {
+		policy := raw.Default.merge(override)
+		if err := policy.validate(); err != nil {
+			return fmt.Errorf("invalid autoscaling policy for contract %s : %s", contractId, err)
+		}
+		contracts[contractId] = policy
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaultPolicy = raw.Default
+	c.contracts = contracts
+	c.modTime = fi.ModTime()
+	return nil
+}
+
+// watch reloads the catalog whenever the file is modified.
+// The previous catalog is kept if the modified file is invalid.
+func (c *autoscalingPolicyCatalog) watch(ctx context.Context, interval time.Duration) {
+	watchFile(ctx, c.path, interval, c.lastModified, c.load)
+}
+
+func (c *autoscalingPolicyCatalog) lastModified() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.modTime
+}
+
+// policy returns the policy of the contract, or the default policy.
+func (c *autoscalingPolicyCatalog) policy(contractId string) autoscalingPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if policy, ok := c.contracts[contractId]; ok {
+		return policy
+	}
+	return c.defaultPolicy
+}
+
+func (p autoscalingPolicy) merge(override autoscalingPolicy) autoscalingPolicy {
+	if override.MaxSizeMultiplier != 0 {
+		p.MaxSizeMultiplier = override.MaxSizeMultiplier
+	}
+	if override.MaxSizePerAz != 0 {
+		p.MaxSizePerAz = override.MaxSizePerAz
+	}
+	if override.DefaultMinSizePerAz != 0 {
+		p.DefaultMinSizePerAz = override.DefaultMinSizePerAz
+	}
+	return p
+}
+
+func (p autoscalingPolicy) validate() error {
+	if p.MaxSizeMultiplier < 1 {
+		return fmt.Errorf("maxSizeMultiplier must be greater than 0")
+	}
+	if p.MaxSizePerAz < 1 {
+		return fmt.Errorf("maxSizePerAz must be greater than 0")
+	}
+	if p.DefaultMinSizePerAz < 1 || p.DefaultMinSizePerAz > p.MaxSizePerAz {
+		return fmt.Errorf("defaultMinSizePerAz must be between 1 and %d", p.MaxSizePerAz)
+	}
+	return nil
+}
+
+// sizePerAz resolves the node group sizes per AZ with either explicit sizes or the total number of replicas.
+// The desired size defaults to the min size, and the max size to the min size times the multiplier
+// within the ceiling of the policy.
+func (p autoscalingPolicy) sizePerAz(explicit *sizePerAz, replicas int, numOfAz int) (*sizePerAz, error) {
+	size := &sizePerAz{}
+	switch {
+	case explicit != nil && replicas > 0:
+		return nil, fmt.Errorf("replicas and sizes per AZ can not be given together")
+	case explicit != nil:
+		*size = *explicit
+	case replicas > 0:
+		if remainder := replicas % numOfAz; remainder != 0 {
+			log.Error("Invalid machineReplicas: it should be multiple of numOfAz ", numOfAz)
+			return nil, fmt.Errorf("Invalid machineReplicas: it should be multiple of numOfAz %d", numOfAz)
+		}
+		size.MinSizePerAz = replicas / numOfAz
+	default:
+		log.Info("No replicas or sizes per AZ. Using default min size per AZ ", p.DefaultMinSizePerAz)
+		size.MinSizePerAz = p.DefaultMinSizePerAz
+	}
+
+	if size.MinSizePerAz < 1 {
+		return nil, fmt.Errorf("minSizePerAz must be greater than 0")
+	}
+	if size.MinSizePerAz > p.MaxSizePerAz {
+		log.Error("Invalid size: exceeded maximum size per AZ ", p.MaxSizePerAz)
+		return nil, fmt.Errorf("Invalid size: replicas per AZ cannot exceed %d", p.MaxSizePerAz)
+	}
+	if size.MaxSizePerAz == 0 {
+		size.MaxSizePerAz = size.MinSizePerAz * p.MaxSizeMultiplier
+		if size.MaxSizePerAz > p.MaxSizePerAz {
+			log.Info(fmt.Sprintf("maxSizePerAz exceeded maximum value %d, so adjusted to %d", p.MaxSizePerAz, p.MaxSizePerAz))
+			size.MaxSizePerAz = p.MaxSizePerAz
+		}
+	}
+	if size.MaxSizePerAz > p.MaxSizePerAz {
+		return nil, fmt.Errorf("maxSizePerAz cannot exceed %d", p.MaxSizePerAz)
+	}
+	if size.DesiredSizePerAz == 0 {
+		size.DesiredSizePerAz = size.MinSizePerAz
+	}
+	if size.DesiredSizePerAz < size.MinSizePerAz || size.DesiredSizePerAz > size.MaxSizePerAz {
+		return nil, fmt.Errorf("sizes per AZ must satisfy min(%d) <= desired(%d) <= max(%d)",
+			size.MinSizePerAz, size.DesiredSizePerAz, size.MaxSizePerAz)
+	}
+
+	log.Debug(fmt.Sprintf("Derived sizes per AZ : min %d, desired %d, max %d", size.MinSizePerAz, size.DesiredSizePerAz, size.MaxSizePerAz))
+	return size, nil
+}
+
+// getSizePerAz returns the sizes per AZ of the incoming request header, if any.
+func getSizePerAz(ctx context.Context) (*sizePerAz, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	values := md.Get(sizePerAzHeader)
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	size := &sizePerAz{}
+	if err := json.Unmarshal([]byte(values[0]), size); err != nil {
+		return nil, fmt.Errorf("invalid %s header : %s", sizePerAzHeader, err)
+	}
+	return size, nil
+}
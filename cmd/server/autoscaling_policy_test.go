@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/openinfradev/tks-common/pkg/argowf"
+	mockargo "github.com/openinfradev/tks-common/pkg/argowf/mock"
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+	mocktks "github.com/openinfradev/tks-proto/tks_pb/mock"
+)
+
+func TestAutoscalingPolicyCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "autoscaling-policies.json")
+	writeTestFile(t, path, `{
+  "default": {"maxSizeMultiplier": 5, "maxSizePerAz": 99, "defaultMinSizePerAz": 1},
+  "contracts": {"p12345678": {"maxSizeMultiplier": 2, "maxSizePerAz": 10}}
+}`)
+
+	c, err := newAutoscalingPolicyCatalog(path)
+	require.NoError(t, err)
+
+	require.Equal(t, autoscalingPolicy{MaxSizeMultiplier: 5, MaxSizePerAz: 99, DefaultMinSizePerAz: 1}, c.policy("p00000000"))
+	require.Equal(t, autoscalingPolicy{MaxSizeMultiplier: 2, MaxSizePerAz: 10, DefaultMinSizePerAz: 1}, c.policy("p12345678"))
+}
+
+func TestAutoscalingPolicyCatalogInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "autoscaling-policies.json")
+
+	writeTestFile(t, path, `{"default": {"maxSizeMultiplier": 0, "maxSizePerAz": 99, "defaultMinSizePerAz": 1}}`)
+	_, err := newAutoscalingPolicyCatalog(path)
+	require.Error(t, err)
+
+	writeTestFile(t, path, `{
+  "default": {"maxSizeMultiplier": 5, "maxSizePerAz": 99, "defaultMinSizePerAz": 1},
+  "contracts": {"p12345678": {"maxSizePerAz": 10, "defaultMinSizePerAz": 20}}
+}`)
+	_, err = newAutoscalingPolicyCatalog(path)
+	require.Error(t, err)
+}
+
+func TestAutoscalingPolicySizePerAz(t *testing.T) {
+	policy := autoscalingPolicy{MaxSizeMultiplier: 5, MaxSizePerAz: 20, DefaultMinSizePerAz: 2}
+
+	testCases := []struct {
+		name     string
+		explicit *sizePerAz
+		replicas int
+		expected *sizePerAz
+	}{
+		{
+			name:     "DEFAULT",
+			expected: &sizePerAz{MinSizePerAz: 2, DesiredSizePerAz: 2, MaxSizePerAz: 10},
+		},
+		{
+			name:     "REPLICAS",
+			replicas: 9,
+			expected: &sizePerAz{MinSizePerAz: 3, DesiredSizePerAz: 3, MaxSizePerAz: 15},
+		},
+		{
+			name:     "REPLICAS_CLAMPED_TO_CEILING",
+			replicas: 18,
+			expected: &sizePerAz{MinSizePerAz: 6, DesiredSizePerAz: 6, MaxSizePerAz: 20},
+		},
+		{
+			name:     "EXPLICIT",
+			explicit: &sizePerAz{MinSizePerAz: 1, DesiredSizePerAz: 3, MaxSizePerAz: 4},
+			expected: &sizePerAz{MinSizePerAz: 1, DesiredSizePerAz: 3, MaxSizePerAz: 4},
+		},
+		{
+			name:     "EXPLICIT_WITHOUT_MAX",
+			explicit: &sizePerAz{MinSizePerAz: 1},
+			expected: &sizePerAz{MinSizePerAz: 1, DesiredSizePerAz: 1, MaxSizePerAz: 5},
+		},
+		{
+			name:     "REPLICAS_NOT_MULTIPLE_OF_AZ",
+			replicas: 4,
+		},
+		{
+			name:     "REPLICAS_AND_EXPLICIT",
+			explicit: &sizePerAz{MinSizePerAz: 1},
+			replicas: 3,
+		},
+		{
+			name:     "MAX_EXCEEDS_CEILING",
+			explicit: &sizePerAz{MinSizePerAz: 1, MaxSizePerAz: 21},
+		},
+		{
+			name:     "DESIRED_EXCEEDS_MAX",
+			explicit: &sizePerAz{MinSizePerAz: 1, DesiredSizePerAz: 5, MaxSizePerAz: 4},
+		},
+		{
+			name:     "MIN_EXCEEDS_MAX",
+			explicit: &sizePerAz{MinSizePerAz: 5, MaxSizePerAz: 4},
+		},
+		{
+			name:     "ZERO_MIN",
+			explicit: &sizePerAz{MaxSizePerAz: 4},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			size, err := policy.sizePerAz(tc.explicit, tc.replicas, 3)
+			if tc.expected != nil {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, size)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestScaleClusterSizePerAz(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockArgoClient := mockargo.NewMockClient(ctrl)
+	argowfClient = mockArgoClient
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+	mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+	cspInfoClient = mockCspInfoClient
+
+	clusterId := helper.GenerateClusterId()
+
+	mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.GetClusterResponse{
+			Code: pb.Code_OK_UNSPECIFIED,
+			Cluster: &pb.Cluster{
+				Id:     clusterId,
+				Status: pb.ClusterStatus_RUNNING,
+				Conf: &pb.ClusterConf{
+					Region:       "ap-northeast-2",
+					NumOfAz:      3,
+					MachineType:  "t3.large",
+					MinSizePerAz: 1,
+					MaxSizePerAz: 5,
+				},
+			},
+		}, nil)
+	mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
+	mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-scale-usercluster", "argo", gomock.Any()).Times(1).
+		DoAndReturn(func(workflow string, namespace string, opts argowf.SubmitOptions) (string, error) {
+			require.Contains(t, opts.Parameters, "min_size_per_az=2")
+			require.Contains(t, opts.Parameters, "desired_size_per_az=4")
+			require.Contains(t, opts.Parameters, "max_size_per_az=8")
+			return randomString("workflowName"), nil
+		})
+	mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(sizePerAzHeader, `{"minSizePerAz": 2, "desiredSizePerAz": 4, "maxSizePerAz": 8}`))
+	s := server{}
+
+	res, err := s.ScaleCluster(ctx, &pb.ScaleClusterRequest{ClusterId: clusterId})
+	require.NoError(t, err)
+	require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/openinfradev/tks-common/pkg/log"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// cascadeDeleteHeader is an optional request header of DeleteCluster.
+// With "true", the app groups remaining in the cluster are removed before the cluster.
+const cascadeDeleteHeader = "x-cascade-delete"
+
+// cascadeTimeout limits the wait for the remove workflows of the app groups.
+var cascadeTimeout = time.Hour
+
+func getCascadeDelete(ctx context.Context) (bool, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false, nil
+	}
+	values := md.Get(cascadeDeleteHeader)
+	if len(values) == 0 {
+		return false, nil
+	}
+	cascade, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return false, fmt.Errorf("invalid %s header : %s", cascadeDeleteHeader, values[0])
+	}
+	return cascade, nil
+}
+
+// cascadeDeleteCluster removes the remaining app groups and then the cluster as one DELETE_CLUSTER operation.
+// The remove workflows of the app groups are submitted here, and the reconciler submits the cluster removal
+// once they have succeeded. The operation gets the workflow of the cluster removal then.
+//
+// Each remove workflow is recorded as an UNINSTALL_APP_GROUP operation as soon as it is submitted.
+// If a submission fails, the response tells which app groups are being removed already,
+// and a retried request waits for them instead of submitting them again.
+func (s *server) cascadeDeleteCluster(ctx context.Context, clusterId string, provider cspProvider, appGroups []*pb.AppGroup) (*pb.SimpleResponse, error) {
+	appGroupIds := []string{}
+	workflowIds := []string{}
+	submitted := []string{}
+	for _, appGroup := range appGroups {
+		appGroupIds = append(appGroupIds, appGroup.GetAppGroupId())
+
+		// An app group being removed already is only waited for.
+		if workflowId := removingWorkflowId(appGroup); workflowId != "" {
+			workflowIds = append(workflowIds, workflowId)
+			continue
+		}
+		workflowId, err := s.submitUninstallAppGroup(ctx, appGroup)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to remove app group %s : %s", appGroup.GetAppGroupId(), err)
+			if len(submitted) > 0 {
+				msg += fmt.Sprintf(". the removal of app groups %s has started, and a retry waits for it", strings.Join(submitted, ","))
+			}
+			return &pb.SimpleResponse{
+				Code: pb.Code_INTERNAL,
+				Error: &pb.Error{
+					Msg: msg,
+				},
+			}, err
+		}
+		workflowIds = append(workflowIds, workflowId)
+		submitted = append(submitted, appGroup.GetAppGroupId())
+	}
+
+	parameters := append(provider.DeleteParameters(clusterId),
+		"cascade=true",
+		"app_group_ids="+strings.Join(appGroupIds, ","))
+	recordPendingOperation(ctx, operationDeleteCluster, clusterId, parameters, &pendingWorkflow{
+		Template:   provider.DeleteWorkflow(),
+		Parameters: provider.DeleteParameters(clusterId),
+		Workflows:  workflowIds,
+		Deadline:   time.Now().Add(cascadeTimeout),
+	})
+
+	if err := s.updateClusterStatusWithWorkflowId(ctx, clusterId, pb.ClusterStatus_DELETING, ""); err != nil {
+		log.Error("Failed to update cluster status to 'DELETING'")
+	}
+
+	log.Info(fmt.Sprintf("cluster deletion started with app groups %s. clusterId : %s", strings.Join(appGroupIds, ","), clusterId))
+	return &pb.SimpleResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+	}, nil
+}
+
+// removingWorkflowId returns the remove workflow of the app group which is in progress, if any.
+// The running UNINSTALL_APP_GROUP operation is looked up as well as the status,
+// since the status is updated after the submission and the update may fail.
+func removingWorkflowId(appGroup *pb.AppGroup) string {
+	if appGroup.GetStatus() == pb.AppGroupStatus_APP_GROUP_DELETING && appGroup.GetWorkflowId() != "" {
+		return appGroup.GetWorkflowId()
+	}
+	ops := operations.listTarget(appGroup.GetAppGroupId(), func(op *Operation) bool {
+		return op.Type == operationUninstallAppGroup && op.Phase == operationPhaseRunning && op.WorkflowId != ""
+	})
+	if len(ops) == 0 {
+		return ""
+	}
+	return ops[len(ops)-1].WorkflowId
+}
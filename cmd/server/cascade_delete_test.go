@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+
+	mockargo "github.com/openinfradev/tks-common/pkg/argowf/mock"
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+	mocktks "github.com/openinfradev/tks-proto/tks_pb/mock"
+)
+
+func TestDeleteClusterCascade(t *testing.T) {
+	testCases := []struct {
+		name          string
+		lmaPhase      string
+		buildStubs    func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient)
+		expectedPhase string
+	}{
+		{
+			name:     "OK",
+			lmaPhase: workflowPhaseSucceeded,
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-remove-usercluster", "argo", gomock.Any()).Times(1).
+					Return("remove-cluster-workflow", nil)
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_DELETING, in.GetStatus())
+						require.Equal(t, "remove-cluster-workflow", in.GetWorkflowId())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+			expectedPhase: operationPhaseRunning,
+		},
+		{
+			name:     "FAILED_TO_REMOVE_APP_GROUP",
+			lmaPhase: workflowPhaseFailed,
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_ERROR, in.GetStatus())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+			expectedPhase: operationPhaseFailed,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
+			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+			appInfoClient = mockAppInfoClient
+			operations = newTestOperationStore(t)
+
+			clusterId := helper.GenerateClusterId()
+			lma := &pb.AppGroup{
+				AppGroupId: helper.GenerateApplicaionGroupId(),
+				ClusterId:  clusterId,
+				Type:       pb.AppGroupType_LMA,
+				Status:     pb.AppGroupStatus_APP_GROUP_RUNNING,
+			}
+			serviceMesh := &pb.AppGroup{
+				AppGroupId: helper.GenerateApplicaionGroupId(),
+				ClusterId:  clusterId,
+				Type:       pb.AppGroupType_SERVICE_MESH,
+				Status:     pb.AppGroupStatus_APP_GROUP_DELETING,
+				WorkflowId: "remove-servicemesh-workflow",
+			}
+
+			mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+				Return(&pb.GetClusterResponse{
+					Code:    pb.Code_OK_UNSPECIFIED,
+					Cluster: &pb.Cluster{Id: clusterId, Status: pb.ClusterStatus_RUNNING},
+				}, nil)
+			mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+				Return(&pb.GetAppGroupsResponse{
+					Code:      pb.Code_OK_UNSPECIFIED,
+					AppGroups: []*pb.AppGroup{lma, serviceMesh},
+				}, nil)
+			mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+				Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
+
+			// Only the app group which is not being removed yet gets a remove workflow.
+			mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-remove-lma-federation", "argo", gomock.Any()).Times(1).
+				Return("remove-lma-workflow", nil)
+			mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+				Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+				DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+					require.Equal(t, pb.ClusterStatus_DELETING, in.GetStatus())
+					require.Empty(t, in.GetWorkflowId())
+					return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+				})
+
+			// The uninstall operation of the LMA ends on the same reconciliation.
+			mockArgoClient.EXPECT().GetWorkflow("argo", "remove-lma-workflow").Times(2).
+				Return(workflowWithPhase(tc.lmaPhase), nil)
+			mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+				Return(&pb.GetAppGroupResponse{
+					Code:     pb.Code_OK_UNSPECIFIED,
+					AppGroup: &pb.AppGroup{AppGroupId: lma.AppGroupId, Status: pb.AppGroupStatus_APP_GROUP_DELETED},
+				}, nil)
+			mockArgoClient.EXPECT().GetWorkflow("argo", "remove-servicemesh-workflow").AnyTimes().
+				Return(workflowWithPhase(workflowPhaseSucceeded), nil)
+			tc.buildStubs(mockArgoClient, mockClusterInfoClient)
+
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(cascadeDeleteHeader, "true"))
+			s := server{}
+			res, err := s.DeleteCluster(ctx, &pb.IDRequest{Id: clusterId})
+			require.NoError(t, err)
+			require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+
+			op, err := operations.last(clusterId, operationDeleteCluster)
+			require.NoError(t, err)
+			require.NotNil(t, op.Pending)
+			require.Equal(t, []string{"remove-lma-workflow", "remove-servicemesh-workflow"}, op.Pending.Workflows)
+
+			// The reconciler submits the cluster removal as the same operation, even after a restart.
+			operations, err = newOperationStore(operations.store, 0)
+			require.NoError(t, err)
+			newReconciler(time.Second, time.Hour, time.Hour).reconcileOperations(context.Background())
+
+			op, err = operations.last(clusterId, operationDeleteCluster)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedPhase, op.Phase)
+			require.Nil(t, op.Pending)
+			require.Equal(t, "true", op.parameter("cascade"))
+		})
+	}
+}
+
+func TestDeleteClusterCascadeRetry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockArgoClient := mockargo.NewMockClient(ctrl)
+	argowfClient = mockArgoClient
+	mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+	cspInfoClient = mockCspInfoClient
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+	mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+	appInfoClient = mockAppInfoClient
+	operations = newTestOperationStore(t)
+
+	clusterId := helper.GenerateClusterId()
+	lma := &pb.AppGroup{
+		AppGroupId: helper.GenerateApplicaionGroupId(),
+		ClusterId:  clusterId,
+		Type:       pb.AppGroupType_LMA,
+		Status:     pb.AppGroupStatus_APP_GROUP_RUNNING,
+	}
+	serviceMesh := &pb.AppGroup{
+		AppGroupId: helper.GenerateApplicaionGroupId(),
+		ClusterId:  clusterId,
+		Type:       pb.AppGroupType_SERVICE_MESH,
+		Status:     pb.AppGroupStatus_APP_GROUP_RUNNING,
+	}
+
+	// The status of the LMA stays RUNNING, as if its update had failed.
+	mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(2).
+		Return(&pb.GetClusterResponse{
+			Code:    pb.Code_OK_UNSPECIFIED,
+			Cluster: &pb.Cluster{Id: clusterId, Status: pb.ClusterStatus_RUNNING},
+		}, nil)
+	mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(2).
+		Return(&pb.GetAppGroupsResponse{
+			Code:      pb.Code_OK_UNSPECIFIED,
+			AppGroups: []*pb.AppGroup{lma, serviceMesh},
+		}, nil)
+	mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(2).
+		Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
+	mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(2).
+		Return(nil, errors.New("UNAVAILABLE"))
+
+	// The first request fails on the second app group.
+	gomock.InOrder(
+		mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-remove-lma-federation", "argo", gomock.Any()).Times(1).
+			Return("remove-lma-workflow", nil),
+		mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-remove-servicemesh", "argo", gomock.Any()).Times(1).
+			Return("", errors.New("FAILED_TO_CALL_WORKFLOW")),
+		mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-remove-servicemesh", "argo", gomock.Any()).Times(1).
+			Return("remove-servicemesh-workflow", nil),
+	)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(cascadeDeleteHeader, "true"))
+	s := server{}
+	res, err := s.DeleteCluster(ctx, &pb.IDRequest{Id: clusterId})
+	require.Error(t, err)
+	require.Equal(t, pb.Code_INTERNAL, res.Code)
+	require.Contains(t, res.Error.Msg, lma.AppGroupId)
+
+	op, err := operations.last(clusterId, operationDeleteCluster)
+	require.NoError(t, err)
+	require.Nil(t, op)
+	op, err = operations.last(lma.AppGroupId, operationUninstallAppGroup)
+	require.NoError(t, err)
+	require.Equal(t, operationPhaseRunning, op.Phase)
+
+	// The retry waits for the LMA instead of removing it again.
+	mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+	res, err = s.DeleteCluster(ctx, &pb.IDRequest{Id: clusterId})
+	require.NoError(t, err)
+	require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+
+	op, err = operations.last(clusterId, operationDeleteCluster)
+	require.NoError(t, err)
+	require.Equal(t, []string{"remove-lma-workflow", "remove-servicemesh-workflow"}, op.Pending.Workflows)
+}
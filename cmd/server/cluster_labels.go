@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// clusterLabelsHeader is an optional request header of CreateCluster and ImportCluster.
+// It carries the labels of the cluster as a JSON object. eg) {"environment": "production"}
+const clusterLabelsHeader = "x-cluster-labels"
+
+// confirmClusterNameHeader is a request header of DeleteCluster.
+// It must echo the name of a production cluster to delete it.
+const confirmClusterNameHeader = "x-confirm-cluster-name"
+
+// A cluster labelled environment=production must be confirmed by name to be deleted.
+const (
+	environmentLabel      = "environment"
+	productionEnvironment = "production"
+)
+
+// getClusterLabels returns the validated labels of the incoming request header, if any.
+func getClusterLabels(ctx context.Context) (map[string]string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	values := md.Get(clusterLabelsHeader)
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	labels := map[string]string{}
+	if err := json.Unmarshal([]byte(values[0]), &labels); err != nil {
+		return nil, fmt.Errorf("invalid %s header : %s", clusterLabelsHeader, err)
+	}
+	if err := validateLabels(labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// validateLabels checks the labels with the syntax of Kubernetes labels.
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if len(key) > 317 || !labelKeyRegex.MatchString(key) {
+			return fmt.Errorf("invalid label key %s", key)
+		}
+		if len(value) > 63 || !labelValueRegex.MatchString(value) {
+			return fmt.Errorf("invalid label value %s", value)
+		}
+	}
+	return nil
+}
+
+// isProduction returns whether the labels are the ones of a production cluster.
+func isProduction(labels map[string]string) bool {
+	return labels[environmentLabel] == productionEnvironment
+}
+
+// getConfirmedClusterName returns the cluster name of the confirmation header, if any.
+func getConfirmedClusterName(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(confirmClusterNameHeader)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
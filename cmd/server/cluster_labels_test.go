@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestGetClusterLabels(t *testing.T) {
+	labels, err := getClusterLabels(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, labels)
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(clusterLabelsHeader, `{"environment": "production", "tks.io/team": "platform"}`))
+	labels, err = getClusterLabels(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"environment": "production", "tks.io/team": "platform"}, labels)
+	require.True(t, isProduction(labels))
+
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(clusterLabelsHeader, `{"environment": "prod uction"}`))
+	_, err = getClusterLabels(ctx)
+	require.Error(t, err)
+}
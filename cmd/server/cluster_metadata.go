@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+)
+
+const clusterMetadataBucket = "clusters"
+
+// clusterMetadata keeps the properties of a cluster which tks-info does not store.
+type clusterMetadata struct {
+	ClusterId         string `json:"clusterId"`
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	// DesiredSizePerAz is the desired size of the default node group, which pb.ClusterConf does not have.
+	DesiredSizePerAz int `json:"desiredSizePerAz,omitempty"`
+	// NodePools are the node pools in addition to the default pool of the cluster conf.
+	NodePools []*NodePool       `json:"nodePools,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type clusterMetadataStore struct {
+	mu    sync.Mutex
+	store *fileStore
+}
+
+func newClusterMetadataStore(store *fileStore) *clusterMetadataStore {
+	return &clusterMetadataStore{store: store}
+}
+
+// get returns the metadata of the cluster. A cluster without metadata gets an empty one.
+func (s *clusterMetadataStore) get(clusterId string) (*clusterMetadata, error) {
+	md := &clusterMetadata{}
+	found, err := s.store.get(clusterMetadataBucket, clusterId, md)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		md.ClusterId = clusterId
+	}
+	return md, nil
+}
+
+// update modifies the metadata of the cluster with fn and stores it.
+func (s *clusterMetadataStore) update(clusterId string, fn func(md *clusterMetadata) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	md, err := s.get(clusterId)
+	if err != nil {
+		return err
+	}
+	if err := fn(md); err != nil {
+		return err
+	}
+	return s.store.put(clusterMetadataBucket, clusterId, md)
+}
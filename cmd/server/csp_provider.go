@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/openinfradev/tks-common/pkg/log"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// cspProvider supplies the CSP specific parts of the cluster lifecycle.
+type cspProvider interface {
+	// Name returns a short name of the CSP. eg) aws
+	Name() string
+	// ValidateConf validates the constructed cluster conf.
+	// Regions and machine types are validated against the catalogs before.
+	ValidateConf(conf *pb.ClusterConf) error
+	// CreateWorkflow returns the workflow template to create a cluster.
+	CreateWorkflow() string
+	// DeleteWorkflow returns the workflow template to delete a cluster.
+	DeleteWorkflow() string
+	// DeleteParameters returns the workflow parameters to delete a cluster.
+	DeleteParameters(clusterId string) []string
+	// ScaleWorkflow returns the workflow template to scale the default node group of a cluster.
+	ScaleWorkflow() string
+	// UpgradeWorkflow returns the workflow template to upgrade the kubernetes version of a cluster.
+	UpgradeWorkflow() string
+	// NodePoolWorkflow returns the workflow template to add, update and remove a node pool of a cluster.
+	NodePoolWorkflow() string
+	// CredentialsWorkflow returns the workflow template to replace the kubeconfig of an imported cluster.
+	CredentialsWorkflow() string
+}
+
+// cspProviders are keyed on the name of the CSP type rather than its number,
+// so that a provider of a CSP type which tks-proto does not define yet can not collide with a type added later.
+var cspProviders = map[string]cspProvider{
+	pb.CspType_AWS.String():   &awsProvider{},
+	pb.CspType_AZURE.String(): &azureProvider{},
+	pb.CspType_GCP.String():   &gcpProvider{},
+	// tks-proto does not define the CSP type of OpenStack yet.
+	// The provider is picked up once tks-info returns a CSP of the type named OPENSTACK.
+	"OPENSTACK": &openStackProvider{},
+}
+
+// cspIdHeader is an optional request header of ImportCluster, whose request has no CSP ID.
+// Without it, the contract must have only one CSP.
+const cspIdHeader = "x-csp-id"
+
+// getCspId returns the CSP ID of the incoming request header, if any.
+func getCspId(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", nil
+	}
+	values := md.Get(cspIdHeader)
+	if len(values) == 0 {
+		return "", nil
+	}
+	if _, err := uuid.Parse(values[0]); err != nil {
+		return "", fmt.Errorf("invalid %s header : %s", cspIdHeader, values[0])
+	}
+	return values[0], nil
+}
+
+// getCspProvider returns the provider for the name of the CSP type.
+// CSPs registered before the type was introduced have no type, and they are all AWS.
+func getCspProvider(cspType string) (cspProvider, error) {
+	if cspType == "" || cspType == pb.CspType_CSPTYPE_UNSPECIFIED.String() {
+		cspType = pb.CspType_AWS.String()
+	}
+	provider, ok := cspProviders[cspType]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported CSP type %s", cspType)
+	}
+	return provider, nil
+}
+
+// clusterCspProvider returns the provider for the CSP of the cluster.
+// The code and the message are the ones of the response when it fails.
+func clusterCspProvider(ctx context.Context, cluster *pb.Cluster) (cspProvider, pb.Code, string, error) {
+	cspInfo, err := cspInfoClient.GetCSPInfo(ctx, &pb.IDRequest{Id: cluster.GetCspId()})
+	if err != nil {
+		log.Error("Failed to get csp info err : ", err)
+		return nil, pb.Code_NOT_FOUND, fmt.Sprintf("Invalid CSP Id %s", cluster.GetCspId()), err
+	}
+	provider, err := getCspProvider(cspInfo.GetCspType().String())
+	if err != nil {
+		log.Error("Failed to get csp provider. err : ", err)
+		return nil, pb.Code_INTERNAL, fmt.Sprint(err), err
+	}
+	return provider, pb.Code_OK_UNSPECIFIED, "", nil
+}
+
+// tksWorkflows are the workflow templates which are shared by the CSPs.
+// A provider embeds it, and overrides the templates which differ for its CSP.
+type tksWorkflows struct{}
+
+func (tksWorkflows) DeleteWorkflow() string { return "tks-remove-usercluster" }
+
+func (tksWorkflows) ScaleWorkflow() string { return "tks-scale-usercluster" }
+
+func (tksWorkflows) UpgradeWorkflow() string { return "tks-upgrade-usercluster" }
+
+func (tksWorkflows) NodePoolWorkflow() string { return "tks-apply-nodepool-usercluster" }
+
+func (tksWorkflows) CredentialsWorkflow() string { return "tks-refresh-credentials-usercluster" }
+
+func defaultDeleteParameters(appGroup string, clusterId string) []string {
+	return []string{
+		"app_group=" + appGroup,
+		"tks_info_host=tks-info.tks.svc",
+		"cluster_id=" + clusterId,
+	}
+}
+
+// AWS
+type awsProvider struct {
+	tksWorkflows
+}
+
+func (p *awsProvider) Name() string { return "aws" }
+
+func (p *awsProvider) ValidateConf(conf *pb.ClusterConf) error {
+	if conf.GetSshKeyName() == "" {
+		return fmt.Errorf("SshKeyName must have value for aws")
+	}
+	return nil
+}
+
+func (p *awsProvider) CreateWorkflow() string { return "create-tks-usercluster" }
+
+func (p *awsProvider) DeleteParameters(clusterId string) []string {
+	return defaultDeleteParameters("tks-cluster-aws", clusterId)
+}
+
+// Azure
+type azureProvider struct {
+	tksWorkflows
+}
+
+func (p *azureProvider) Name() string { return "azure" }
+
+func (p *azureProvider) ValidateConf(conf *pb.ClusterConf) error {
+	return nil
+}
+
+func (p *azureProvider) CreateWorkflow() string { return "create-tks-azure-usercluster" }
+
+func (p *azureProvider) DeleteParameters(clusterId string) []string {
+	return defaultDeleteParameters("tks-cluster-azure", clusterId)
+}
+
+// GCP
+type gcpProvider struct {
+	tksWorkflows
+}
+
+func (p *gcpProvider) Name() string { return "gcp" }
+
+func (p *gcpProvider) ValidateConf(conf *pb.ClusterConf) error {
+	return nil
+}
+
+func (p *gcpProvider) CreateWorkflow() string { return "create-tks-gcp-usercluster" }
+
+func (p *gcpProvider) DeleteParameters(clusterId string) []string {
+	return defaultDeleteParameters("tks-cluster-gcp", clusterId)
+}
+
+// OpenStack
+type openStackProvider struct {
+	tksWorkflows
+}
+
+func (p *openStackProvider) Name() string { return "openstack" }
+
+func (p *openStackProvider) ValidateConf(conf *pb.ClusterConf) error {
+	if conf.GetSshKeyName() == "" {
+		return fmt.Errorf("SshKeyName must have value for openstack")
+	}
+	return nil
+}
+
+func (p *openStackProvider) CreateWorkflow() string { return "create-tks-openstack-usercluster" }
+
+func (p *openStackProvider) DeleteParameters(clusterId string) []string {
+	return defaultDeleteParameters("tks-cluster-openstack", clusterId)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+func TestGetCspProvider(t *testing.T) {
+	testCases := []struct {
+		cspType  string
+		expected string
+	}{
+		{cspType: pb.CspType_AWS.String(), expected: "aws"},
+		{cspType: pb.CspType_GCP.String(), expected: "gcp"},
+		{cspType: pb.CspType_AZURE.String(), expected: "azure"},
+		{cspType: "OPENSTACK", expected: "openstack"},
+		{cspType: pb.CspType_CSPTYPE_UNSPECIFIED.String(), expected: "aws"},
+		{cspType: "", expected: "aws"},
+		// A number which tks-proto does not define has no provider.
+		{cspType: pb.CspType(4).String()},
+	}
+
+	for _, tc := range testCases {
+		provider, err := getCspProvider(tc.cspType)
+		if tc.expected == "" {
+			require.Error(t, err, tc.cspType)
+			continue
+		}
+		require.NoError(t, err, tc.cspType)
+		require.Equal(t, tc.expected, provider.Name())
+	}
+}
+
+func TestOpenStackClusterConf(t *testing.T) {
+	provider, err := getCspProvider("OPENSTACK")
+	require.NoError(t, err)
+	require.Equal(t, "create-tks-openstack-usercluster", provider.CreateWorkflow())
+	require.Equal(t, "tks-scale-usercluster", provider.ScaleWorkflow())
+
+	rawConf := &pb.ClusterRawConf{
+		SshKeyName:      "tks-openstack",
+		Region:          "RegionOne",
+		NumOfAz:         1,
+		MachineType:     "m1.large",
+		MachineReplicas: 3,
+	}
+	conf, _, err := constructClusterConf(provider, rawConf, autoscalingPolicies.policy(""), nil)
+	require.NoError(t, err)
+	require.Equal(t, "RegionOne", conf.GetRegion())
+	require.Equal(t, "m1.large", conf.GetMachineType())
+
+	rawConf.MachineType = "t3.large"
+	_, _, err = constructClusterConf(provider, rawConf, autoscalingPolicies.policy(""), nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// The deletion protection of a cluster is kept in the secret store rather than the cluster metadata,
+// so that every replica sees it and it survives a restart with the kubernetes backend.
+// It has the flag of SetDeletionProtection and the production marker from the labels of the cluster.
+// The secret exists only while any of them is set.
+
+// Keys of the flags in the secret.
+const (
+	deletionProtectionSecretKey = "enabled"
+	productionSecretKey         = "production"
+)
+
+type deletionProtection struct {
+	// Enabled rejects the deletion of the cluster.
+	Enabled bool
+	// Production requires the deletion to be confirmed by the name of the cluster.
+	Production bool
+}
+
+// deletionProtectionSecretName returns the name of the secret which protects the deletion of the cluster.
+func deletionProtectionSecretName(clusterId string) string {
+	return clusterId + "-deletion-protection"
+}
+
+// getDeletionProtection returns how the deletion of the cluster is protected.
+// An error other than a missing secret is returned, so that the caller does not delete a protected cluster.
+func getDeletionProtection(ctx context.Context, clusterId string) (deletionProtection, error) {
+	data, err := secrets.get(ctx, deletionProtectionSecretName(clusterId))
+	if err != nil {
+		if errors.Is(err, errSecretNotFound) {
+			return deletionProtection{}, nil
+		}
+		return deletionProtection{}, err
+	}
+	return deletionProtection{
+		Enabled:    string(data[deletionProtectionSecretKey]) == "true",
+		Production: string(data[productionSecretKey]) == "true",
+	}, nil
+}
+
+func setDeletionProtection(ctx context.Context, clusterId string, enabled bool) error {
+	return updateDeletionProtection(ctx, clusterId, func(p *deletionProtection) {
+		p.Enabled = enabled
+	})
+}
+
+// setProductionCluster marks the cluster as a production one by its labels, or clears the marker.
+func setProductionCluster(ctx context.Context, clusterId string, labels map[string]string) error {
+	return updateDeletionProtection(ctx, clusterId, func(p *deletionProtection) {
+		p.Production = isProduction(labels)
+	})
+}
+
+func updateDeletionProtection(ctx context.Context, clusterId string, fn func(p *deletionProtection)) error {
+	p, err := getDeletionProtection(ctx, clusterId)
+	if err != nil {
+		return err
+	}
+	fn(&p)
+
+	if !p.Enabled && !p.Production {
+		return secrets.delete(ctx, deletionProtectionSecretName(clusterId))
+	}
+	data := map[string][]byte{}
+	if p.Enabled {
+		data[deletionProtectionSecretKey] = []byte("true")
+	}
+	if p.Production {
+		data[productionSecretKey] = []byte("true")
+	}
+	return secrets.put(ctx, deletionProtectionSecretName(clusterId), data)
+}
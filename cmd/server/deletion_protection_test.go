@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openinfradev/tks-common/pkg/helper"
+)
+
+func TestDeletionProtection(t *testing.T) {
+	ctx := context.Background()
+	clusterId := helper.GenerateClusterId()
+	production := map[string]string{environmentLabel: productionEnvironment}
+
+	protection, err := getDeletionProtection(ctx, clusterId)
+	require.NoError(t, err)
+	require.Equal(t, deletionProtection{}, protection)
+
+	// The flag and the marker are kept apart in the same secret.
+	require.NoError(t, setProductionCluster(ctx, clusterId, production))
+	require.NoError(t, setDeletionProtection(ctx, clusterId, true))
+	require.NoError(t, setDeletionProtection(ctx, clusterId, false))
+	protection, err = getDeletionProtection(ctx, clusterId)
+	require.NoError(t, err)
+	require.Equal(t, deletionProtection{Production: true}, protection)
+
+	// The secret is removed once nothing is set.
+	require.NoError(t, setProductionCluster(ctx, clusterId, nil))
+	_, err = secrets.get(ctx, deletionProtectionSecretName(clusterId))
+	require.ErrorIs(t, err, errSecretNotFound)
+
+	// A secret stored before the production marker has the flag only.
+	require.NoError(t, secrets.put(ctx, deletionProtectionSecretName(clusterId),
+		map[string][]byte{deletionProtectionSecretKey: []byte("true")}))
+	protection, err = getDeletionProtection(ctx, clusterId)
+	require.NoError(t, err)
+	require.Equal(t, deletionProtection{Enabled: true}, protection)
+}
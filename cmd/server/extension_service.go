@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// The LCM APIs which are not defined in tks-proto yet are served by ClusterLcmExtensionService.
+// Its messages are the structs of messages.go, so they are encoded in JSON instead of protobuf.
+// A client calls them with the "json" content subtype. eg)
+//
+//	conn.Invoke(ctx, "/tks.ClusterLcmExtensionService/GetOperation", &pb.IDRequest{Id: id}, res,
+//		grpc.CallContentSubtype("json"))
+const extensionServiceName = "tks.ClusterLcmExtensionService"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes gRPC messages in JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return "json" }
+
+// clusterLcmExtensionServer is the server API for ClusterLcmExtensionService.
+type clusterLcmExtensionServer interface {
+	PlanCluster(context.Context, *pb.CreateClusterRequest) (*PlanClusterResponse, error)
+	PlanImportCluster(context.Context, *pb.ImportClusterRequest) (*PlanClusterResponse, error)
+	SetDeletionProtection(context.Context, *SetDeletionProtectionRequest) (*pb.SimpleResponse, error)
+	UpdateCluster(context.Context, *UpdateClusterRequest) (*pb.SimpleResponse, error)
+	UpgradeAppGroup(context.Context, *UpgradeAppGroupRequest) (*pb.SimpleResponse, error)
+	UpgradeCluster(context.Context, *UpgradeClusterRequest) (*pb.SimpleResponse, error)
+	UpdateClusterCredentials(context.Context, *UpdateClusterCredentialsRequest) (*pb.SimpleResponse, error)
+	AddNodePool(context.Context, *NodePoolRequest) (*pb.SimpleResponse, error)
+	UpdateNodePool(context.Context, *NodePoolRequest) (*pb.SimpleResponse, error)
+	RemoveNodePool(context.Context, *RemoveNodePoolRequest) (*pb.SimpleResponse, error)
+	RetryCluster(context.Context, *RetryClusterRequest) (*pb.SimpleResponse, error)
+	CancelOperation(context.Context, *pb.IDRequest) (*pb.SimpleResponse, error)
+	GetOperation(context.Context, *pb.IDRequest) (*GetOperationResponse, error)
+	ListOperations(context.Context, *ListOperationsRequest) (*ListOperationsResponse, error)
+}
+
+func registerClusterLcmExtensionServer(s *grpc.Server, srv clusterLcmExtensionServer) {
+	s.RegisterService(&clusterLcmExtensionServiceDesc, srv)
+}
+
+var clusterLcmExtensionServiceDesc = grpc.ServiceDesc{
+	ServiceName: extensionServiceName,
+	HandlerType: (*clusterLcmExtensionServer)(nil),
+	Methods: []grpc.MethodDesc{
+		extensionMethod("PlanCluster", func() interface{} { return &pb.CreateClusterRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.PlanCluster(ctx, in.(*pb.CreateClusterRequest))
+			}),
+		extensionMethod("PlanImportCluster", func() interface{} { return &pb.ImportClusterRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.PlanImportCluster(ctx, in.(*pb.ImportClusterRequest))
+			}),
+		extensionMethod("SetDeletionProtection", func() interface{} { return &SetDeletionProtectionRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.SetDeletionProtection(ctx, in.(*SetDeletionProtectionRequest))
+			}),
+		extensionMethod("UpdateCluster", func() interface{} { return &UpdateClusterRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.UpdateCluster(ctx, in.(*UpdateClusterRequest))
+			}),
+		extensionMethod("UpgradeAppGroup", func() interface{} { return &UpgradeAppGroupRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.UpgradeAppGroup(ctx, in.(*UpgradeAppGroupRequest))
+			}),
+		extensionMethod("UpgradeCluster", func() interface{} { return &UpgradeClusterRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.UpgradeCluster(ctx, in.(*UpgradeClusterRequest))
+			}),
+		extensionMethod("UpdateClusterCredentials", func() interface{} { return &UpdateClusterCredentialsRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.UpdateClusterCredentials(ctx, in.(*UpdateClusterCredentialsRequest))
+			}),
+		extensionMethod("AddNodePool", func() interface{} { return &NodePoolRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.AddNodePool(ctx, in.(*NodePoolRequest))
+			}),
+		extensionMethod("UpdateNodePool", func() interface{} { return &NodePoolRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.UpdateNodePool(ctx, in.(*NodePoolRequest))
+			}),
+		extensionMethod("RemoveNodePool", func() interface{} { return &RemoveNodePoolRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.RemoveNodePool(ctx, in.(*RemoveNodePoolRequest))
+			}),
+		extensionMethod("RetryCluster", func() interface{} { return &RetryClusterRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.RetryCluster(ctx, in.(*RetryClusterRequest))
+			}),
+		extensionMethod("CancelOperation", func() interface{} { return &pb.IDRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.CancelOperation(ctx, in.(*pb.IDRequest))
+			}),
+		extensionMethod("GetOperation", func() interface{} { return &pb.IDRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.GetOperation(ctx, in.(*pb.IDRequest))
+			}),
+		extensionMethod("ListOperations", func() interface{} { return &ListOperationsRequest{} },
+			func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error) {
+				return srv.ListOperations(ctx, in.(*ListOperationsRequest))
+			}),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "messages.go",
+}
+
+// extensionMethod builds the unary handler of a method in the way generated code does.
+func extensionMethod(name string, newRequest func() interface{},
+	call func(srv clusterLcmExtensionServer, ctx context.Context, in interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := newRequest()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(clusterLcmExtensionServer), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + extensionServiceName + "/" + name,
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(clusterLcmExtensionServer), ctx, req)
+			}
+			return interceptor(ctx, in, info, handler)
+		},
+	}
+}
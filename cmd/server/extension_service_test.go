@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+func newTestExtensionConn(t *testing.T) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterClusterLcmServiceServer(s, &server{})
+	registerClusterLcmExtensionServer(s, &server{})
+	go func() { _ = s.Serve(listener) }()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype("json")))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestExtensionService(t *testing.T) {
+	operations = newTestOperationStore(t)
+	conn := newTestExtensionConn(t)
+	ctx := context.Background()
+
+	clusterId := helper.GenerateClusterId()
+	op := recordOperation(ctx, operationUpgradeCluster, clusterId, "upgrade-workflow", []string{"kubernetes_version=v1.24.10"}, nil)
+
+	getRes := &GetOperationResponse{}
+	err := conn.Invoke(ctx, "/"+extensionServiceName+"/GetOperation", &pb.IDRequest{Id: op.OperationId}, getRes)
+	require.NoError(t, err)
+	require.Equal(t, pb.Code_OK_UNSPECIFIED, getRes.Code)
+	require.Equal(t, op.OperationId, getRes.Operation.OperationId)
+	require.Equal(t, "v1.24.10", getRes.Operation.parameter("kubernetes_version"))
+
+	listRes := &ListOperationsResponse{}
+	err = conn.Invoke(ctx, "/"+extensionServiceName+"/ListOperations", &ListOperationsRequest{TargetId: clusterId}, listRes)
+	require.NoError(t, err)
+	require.Equal(t, pb.Code_OK_UNSPECIFIED, listRes.Code)
+	require.Len(t, listRes.Operations, 1)
+
+	// A handler error is returned as the status of the call.
+	err = conn.Invoke(ctx, "/"+extensionServiceName+"/GetOperation", &pb.IDRequest{Id: "NOT_EXIST_OPERATION"}, getRes)
+	require.Error(t, err)
+
+	err = conn.Invoke(ctx, "/"+extensionServiceName+"/NotExistMethod", &pb.IDRequest{}, getRes)
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
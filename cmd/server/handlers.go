@@ -1,14 +1,15 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/google/uuid"
@@ -19,12 +20,6 @@ import (
 	pb "github.com/openinfradev/tks-proto/tks_pb"
 )
 
-var (
-	filePathAzRegion = "./az-per-region.txt"
-)
-
-const MAX_SIZE_PER_AZ = 99
-
 func validateCreateClusterRequest(in *pb.CreateClusterRequest) (err error) {
 	if in.GetContractId() != "" {
 		if !helper.ValidateContractId(in.GetContractId()) {
@@ -33,6 +28,10 @@ func validateCreateClusterRequest(in *pb.CreateClusterRequest) (err error) {
 		if _, err := uuid.Parse(in.GetCspId()); err != nil {
 			return fmt.Errorf("invalid CSP ID %s", in.GetCspId())
 		}
+	} else if in.GetCspId() != "" {
+		if _, err := uuid.Parse(in.GetCspId()); err != nil {
+			return fmt.Errorf("invalid CSP ID %s", in.GetCspId())
+		}
 	}
 
 	if in.GetName() == "" {
@@ -53,7 +52,47 @@ func validateImportClusterRequest(in *pb.ImportClusterRequest) (err error) {
 	if string(in.GetKubeconfig()) == "" {
 		return errors.New("Kubeconfig must have value ")
 	}
+	if _, err := parseKubeconfig(in.GetKubeconfig()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// maxClusterNameLength is the max number of characters of a cluster name.
+const maxClusterNameLength = 50
+
+// validateClusterName checks the name policy of UpdateCluster.
+// A name has printable characters only, without leading or trailing spaces.
+// CreateCluster and ImportCluster keep accepting any name which is not empty.
+func validateClusterName(name string) error {
+	if name == "" {
+		return errors.New("Name must have value ")
+	}
+	if utf8.RuneCountInString(name) > maxClusterNameLength {
+		return fmt.Errorf("Name must not exceed %d characters ", maxClusterNameLength)
+	}
+	if strings.TrimSpace(name) != name {
+		return errors.New("Name must not have leading or trailing spaces ")
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return errors.New("Name must have printable characters only ")
+		}
+	}
+	return nil
+}
 
+func validateScaleClusterRequest(in *pb.ScaleClusterRequest, explicitSize *sizePerAz) (err error) {
+	if !helper.ValidateClusterId(in.GetClusterId()) {
+		return fmt.Errorf("invalid cluster ID %s", in.GetClusterId())
+	}
+	if explicitSize == nil && in.GetWorkerReplicas() <= 0 {
+		return errors.New("WorkerReplicas must be greater than 0 ")
+	}
+	if in.GetMasterReplicas() < 0 || (in.GetMasterReplicas() > 0 && in.GetMasterReplicas()%2 == 0) {
+		return errors.New("MasterReplicas must be an odd number ")
+	}
 	return nil
 }
 
@@ -75,6 +114,13 @@ func validateInstallAppGroupsRequest(in *pb.InstallAppGroupsRequest) (err error)
 		if appGroup.GetExternalLabel() == "" {
 			return errors.New("ExternalLabel must have value ")
 		}
+		t, err := appGroupTypes.get(appGroup.GetType())
+		if err != nil {
+			return err
+		}
+		if err := t.validate(appGroup); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -88,11 +134,44 @@ func validateUninstallAppGroupsRequest(in *pb.UninstallAppGroupsRequest) (err er
 	return nil
 }
 
-func constructClusterConf(rawConf *pb.ClusterRawConf) (clusterConf *pb.ClusterConf, err error) {
-	region := "ap-northeast-2"
+// maxRevisionLength is the max length of a manifest revision.
+const maxRevisionLength = 255
+
+// validateRevision checks that the revision is a plain git branch or tag name.
+func validateRevision(revision string) error {
+	if revision == "" {
+		return errors.New("Revision must have value ")
+	}
+	if len(revision) > maxRevisionLength {
+		return fmt.Errorf("Revision must not exceed %d characters ", maxRevisionLength)
+	}
+	if strings.HasPrefix(revision, "-") || strings.HasPrefix(revision, "/") || strings.HasSuffix(revision, "/") ||
+		strings.HasSuffix(revision, ".") || strings.Contains(revision, "..") || strings.Contains(revision, "//") {
+		return fmt.Errorf("invalid revision %s", revision)
+	}
+	for _, r := range revision {
+		if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') && !strings.ContainsRune("-_./", r) {
+			return fmt.Errorf("invalid revision %s", revision)
+		}
+	}
+	return nil
+}
+
+func constructClusterConf(provider cspProvider, rawConf *pb.ClusterRawConf, policy autoscalingPolicy, explicitSize *sizePerAz) (clusterConf *pb.ClusterConf, size *sizePerAz, err error) {
+	region, machineType, err := cspRegions.defaults(provider.Name())
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+
 	if rawConf != nil && rawConf.Region != "" {
 		region = rawConf.Region
 	}
+	regionInfo, err := cspRegions.region(provider.Name(), region)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
 
 	numOfAz := 1
 	if rawConf != nil && rawConf.NumOfAz != 0 {
@@ -101,82 +180,38 @@ func constructClusterConf(rawConf *pb.ClusterRawConf) (clusterConf *pb.ClusterCo
 		if numOfAz > 3 {
 			log.Error("Error: numOfAz cannot exceed 3.")
 			temp_err := fmt.Errorf("Error: numOfAz cannot exceed 3.")
-			return nil, temp_err
+			return nil, nil, temp_err
 		}
 	}
 
-	sshKeyName := "tks-seoul"
+	// Check if numOfAz is correct based on the region catalog
+	if numOfAz > len(regionInfo.Azs) {
+		log.Error("Invalid numOfAz: exceeded the number of Az in region ", region)
+		temp_err := fmt.Errorf("Invalid numOfAz: exceeded the number of Az in region %s", region)
+		return nil, nil, temp_err
+	}
+
+	sshKeyName := regionInfo.DefaultSshKeyName
 	if rawConf != nil && rawConf.SshKeyName != "" {
 		sshKeyName = rawConf.SshKeyName
 	}
 
-	machineType := "t3.large"
 	if rawConf != nil && rawConf.MachineType != "" {
 		machineType = rawConf.MachineType
 	}
-
-	minSizePerAz := 1
-	maxSizePerAz := 5
-
-	// Check if numOfAz is correct based on pre-defined mapping table
-	maxAzForSelectedRegion := 0
-
-	file, err := os.Open(filePathAzRegion)
-	if err != nil {
-		log.Error(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	var found bool = false
-	for scanner.Scan() {
-		if strings.Contains(scanner.Text(), region) {
-			log.Debug("Found region line: ", scanner.Text())
-			azNum := strings.Split(scanner.Text(), ":")[1]
-			maxAzForSelectedRegion, err = strconv.Atoi(strings.TrimSpace(azNum))
-			if err != nil {
-				log.Error("Error while converting azNum to int var: ", err)
-			}
-			log.Debug("Trimmed azNum var: ", maxAzForSelectedRegion)
-			found = true
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		log.Error("Error while processing file: ", err)
-	}
-	if !found {
-		log.Error("Couldn't find entry for region ", region)
+	if err := validateMachineType(provider, regionInfo, machineType); err != nil {
+		return nil, nil, err
 	}
 
-	if numOfAz > maxAzForSelectedRegion {
-		log.Error("Invalid numOfAz: exceeded the number of Az in region ", region)
-		temp_err := fmt.Errorf("Invalid numOfAz: exceeded the number of Az in region %s", region)
-		return nil, temp_err
+	// Replicas beyond the ceiling of the policy have always been clamped by CreateCluster instead of rejected.
+	replicas := int(rawConf.GetMachineReplicas())
+	if replicas > 0 && replicas%numOfAz == 0 && replicas/numOfAz > policy.MaxSizePerAz {
+		log.Info(fmt.Sprintf("minSizePerAz exceeded maximum value %d, so adjusted to %d", policy.MaxSizePerAz, policy.MaxSizePerAz))
+		replicas = policy.MaxSizePerAz * numOfAz
 	}
-
-	// Validate if machineReplicas is multiple of number of AZ
-	replicas := int(rawConf.MachineReplicas)
-	if replicas == 0 {
-		log.Debug("No machineReplicas param. Using default values..")
-	} else {
-		if remainder := replicas % numOfAz; remainder != 0 {
-			log.Error("Invalid machineReplicas: it should be multiple of numOfAz ", numOfAz)
-			temp_err := fmt.Errorf("Invalid machineReplicas: it should be multiple of numOfAz %d", numOfAz)
-			return nil, temp_err
-		} else {
-			log.Debug("Valid replicas and numOfAz. Caculating minSize & maxSize..")
-			minSizePerAz = int(replicas / numOfAz)
-			maxSizePerAz = minSizePerAz * 5
-
-			// Validate if maxSizePerAx is within allowed range
-			if maxSizePerAz > MAX_SIZE_PER_AZ {
-				fmt.Printf("maxSizePerAz exceeded maximum value %d, so adjusted to %d", MAX_SIZE_PER_AZ, MAX_SIZE_PER_AZ)
-				maxSizePerAz = MAX_SIZE_PER_AZ
-			}
-			log.Debug("Derived minSizePerAz: ", minSizePerAz)
-			log.Debug("Derived maxSizePerAz: ", maxSizePerAz)
-		}
+	size, err = policy.sizePerAz(explicitSize, replicas, numOfAz)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Construct cluster conf
@@ -185,103 +220,49 @@ func constructClusterConf(rawConf *pb.ClusterRawConf) (clusterConf *pb.ClusterCo
 		Region:       region,
 		NumOfAz:      int32(numOfAz),
 		MachineType:  machineType,
-		MinSizePerAz: int32(minSizePerAz),
-		MaxSizePerAz: int32(maxSizePerAz),
+		MinSizePerAz: int32(size.MinSizePerAz),
+		MaxSizePerAz: int32(size.MaxSizePerAz),
 	}
 
-	fmt.Printf("Newly constructed cluster conf: %+v\n", &tempConf)
-	return &tempConf, nil
-}
-
-func (s *server) CreateCluster(ctx context.Context, in *pb.CreateClusterRequest) (*pb.IDResponse, error) {
-	log.Info("Request 'CreateCluster' for contractId : ", in.GetContractId())
-
-	if err := validateCreateClusterRequest(in); err != nil {
-		return &pb.IDResponse{
-			Code: pb.Code_INVALID_ARGUMENT,
-			Error: &pb.Error{
-				Msg: fmt.Sprint(err),
-			},
-		}, err
+	if err := provider.ValidateConf(&tempConf); err != nil {
+		log.Error("Invalid cluster conf. err : ", err)
+		return nil, nil, err
 	}
 
-	contractId := in.GetContractId()
-	cspId := in.GetCspId()
-	templateName := in.GetTemplateName()
-
-	// get default contract if contractId is empty
-	if contractId == "" {
-		contract, err := s.getDefaultContract(ctx)
-		if err != nil {
-			log.Error("Failed to get default contract. err : ", err)
-			return &pb.IDResponse{
-				Code: pb.Code_NOT_FOUND,
-				Error: &pb.Error{
-					Msg: "Failed to get default contract",
-				},
-			}, err
-		}
-		contractId = contract.GetContractId()
-
-		res, err := cspInfoClient.GetCSPIDsByContractID(ctx, &pb.IDRequest{Id: contractId})
-		if err != nil || len(res.Ids) == 0 {
-			log.Error("Failed to get csp ids by contractId err : ", err)
-			return &pb.IDResponse{
-				Code: pb.Code_NOT_FOUND,
-				Error: &pb.Error{
-					Msg: fmt.Sprintf("Invalid CSP Id %s", cspId),
-				},
-			}, err
-		}
-
-		// [TODO] Support AWS only!!!
-		cspId = res.Ids[0]
-	} else {
-		// check contract
-		if _, err := contractClient.GetContract(ctx, &pb.GetContractRequest{ContractId: contractId}); err != nil {
-			log.Error("Failed to get contract info err : ", err)
-			return &pb.IDResponse{
-				Code: pb.Code_NOT_FOUND,
-				Error: &pb.Error{
-					Msg: fmt.Sprintf("Invalid contract Id %s", contractId),
-				},
-			}, err
-		}
+	log.Debug(fmt.Sprintf("Newly constructed cluster conf: %+v", &tempConf))
+	return &tempConf, size, nil
+}
 
-		// check csp
-		cspInfo, err := cspInfoClient.GetCSPInfo(ctx, &pb.IDRequest{Id: cspId})
-		if err != nil {
-			log.Error("Failed to get csp info err : ", err)
-			return &pb.IDResponse{
-				Code: pb.Code_NOT_FOUND,
-				Error: &pb.Error{
-					Msg: fmt.Sprintf("Invalid CSP Id %s", cspId),
-				},
-			}, err
-		}
-		if cspInfo.GetContractId() != contractId {
-			log.Error("Invalid contractId by cspId : ", cspInfo.GetContractId())
-			return &pb.IDResponse{
-				Code: pb.Code_NOT_FOUND,
-				Error: &pb.Error{
-					Msg: fmt.Sprintf("ContractId and CSP Id do not match. expected contractId : %s", cspInfo.GetContractId()),
-				},
-			}, err
-		}
+// validateMachineType checks the machine type against the instance catalog and the region catalog.
+func validateMachineType(provider cspProvider, regionInfo *regionInfo, machineType string) error {
+	instance, err := instanceTypes.validate(provider.Name(), regionInfo.Name, machineType)
+	if err != nil {
+		log.Error(err)
+		return err
 	}
+	log.Debug(fmt.Sprintf("machineType %s : vcpu %d, memory %gGiB, %s", instance.Name, instance.Vcpu, instance.MemoryGiB, instance.Architecture))
+	if !regionInfo.allowsMachineType(machineType) {
+		log.Error("Invalid machineType: not allowed in region ", regionInfo.Name)
+		return fmt.Errorf("Invalid machineType %s: not allowed in region %s", machineType, regionInfo.Name)
+	}
+	return nil
+}
 
-	/***************************
-	 * Pre-process cluster conf *
-	 ***************************/
-	rawConf := in.GetConf()
-	fmt.Printf("ClusterRawConf: %+v\n", rawConf)
+func (s *server) CreateCluster(ctx context.Context, in *pb.CreateClusterRequest) (*pb.IDResponse, error) {
+	return withIdempotencyKey(ctx, "CreateCluster", in, func() (*pb.IDResponse, error) {
+		return s.createCluster(ctx, in)
+	})
+}
 
-	clConf, err := constructClusterConf(rawConf)
-	if err != nil {
+func (s *server) createCluster(ctx context.Context, in *pb.CreateClusterRequest) (*pb.IDResponse, error) {
+	log.Info("Request 'CreateCluster' for contractId : ", in.GetContractId())
+
+	plan, code, msg, err := s.planCreateCluster(ctx, in)
+	if code != pb.Code_OK_UNSPECIFIED {
 		return &pb.IDResponse{
-			Code: pb.Code_INTERNAL,
+			Code: code,
 			Error: &pb.Error{
-				Msg: fmt.Sprint(err),
+				Msg: msg,
 			},
 		}, err
 	}
@@ -289,10 +270,10 @@ func (s *server) CreateCluster(ctx context.Context, in *pb.CreateClusterRequest)
 	// create cluster info
 	clusterId := ""
 	resAddClusterInfo, err := clusterInfoClient.AddClusterInfo(ctx, &pb.AddClusterInfoRequest{
-		ContractId:  contractId,
-		CspId:       cspId,
+		ContractId:  plan.ContractId,
+		CspId:       plan.CspId,
 		Name:        in.GetName(),
-		Conf:        clConf,
+		Conf:        plan.Conf,
 		Creator:     in.GetCreator(),
 		Description: in.GetDescription(),
 	})
@@ -308,27 +289,34 @@ func (s *server) CreateCluster(ctx context.Context, in *pb.CreateClusterRequest)
 	clusterId = resAddClusterInfo.Id
 	log.Info("Added cluster in tks-info. clusterId : ", clusterId)
 
+	// The production marker is stored before the workflow, so that the cluster is never deleted without the confirmation.
+	if isProduction(plan.Labels) {
+		if err := setProductionCluster(ctx, clusterId, plan.Labels); err != nil {
+			log.Error("Failed to store the production marker. err : ", err)
+			s.compensateClusterInfo(ctx, clusterId, fmt.Sprintf("Failed to store the production marker : %s", err))
+			return &pb.IDResponse{
+				Code: pb.Code_INTERNAL,
+				Error: &pb.Error{
+					Msg: fmt.Sprintf("Failed to store the production marker : %s", err),
+				},
+			}, err
+		}
+	}
+
 	// create usercluster
 	nameSpace := "argo"
-	workflow := "create-tks-usercluster"
-	manifestRepoUrl := gitBaseUrl + "/" + gitAccount + "/" + clusterId + "-manifests"
+	workflow := plan.Workflow
 
 	opts := argowf.SubmitOptions{}
-	opts.Parameters = []string{
-		"contract_id=" + contractId,
-		"cluster_id=" + clusterId,
-		"site_name=" + clusterId,
-		"template_name=" + templateName,
-		"git_account=" + gitAccount,
-		"manifest_repo_url=" + manifestRepoUrl,
-		"revision=" + revision,
-	}
+	opts.Parameters = plan.parameters(clusterId)
 
 	log.Info("Submitting workflow: ", workflow)
 
 	workflowId, err := argowfClient.SumbitWorkflowFromWftpl(workflow, nameSpace, opts)
+	recordOperation(ctx, operationCreateCluster, clusterId, workflowId, opts.Parameters, err)
 	if err != nil {
 		log.Error("failed to submit argo workflow template. err : ", err)
+		s.compensateClusterInfo(ctx, clusterId, fmt.Sprintf("Failed to submit workflow %s : %s", workflow, err))
 		return &pb.IDResponse{
 			Code: pb.Code_INTERNAL,
 			Error: &pb.Error{
@@ -343,6 +331,17 @@ func (s *server) CreateCluster(ctx context.Context, in *pb.CreateClusterRequest)
 		log.Error("Failed to update cluster status to 'INSTALLING'")
 	}
 
+	// The create workflow installs the default version of the version matrix.
+	if err := clusterMetadatas.update(clusterId, func(md *clusterMetadata) error {
+		md.KubernetesVersion = plan.KubernetesVersion
+		md.DesiredSizePerAz = plan.DesiredSizePerAz
+		md.NodePools = plan.NodePools
+		md.Labels = plan.Labels
+		return nil
+	}); err != nil {
+		log.Error("Failed to store cluster metadata. err : ", err)
+	}
+
 	log.Info("Successfully initiated user-cluster creation. clusterId: ", clusterId)
 	return &pb.IDResponse{
 		Code:  pb.Code_OK_UNSPECIFIED,
@@ -351,59 +350,189 @@ func (s *server) CreateCluster(ctx context.Context, in *pb.CreateClusterRequest)
 	}, nil
 }
 
-func (s *server) ImportCluster(ctx context.Context, in *pb.ImportClusterRequest) (*pb.IDResponse, error) {
-	log.Debug("Request 'ImportCluster' for cluster Name:", in.GetName())
+// PlanCluster runs all validation and resolution of CreateCluster and returns what it would do.
+// It adds no cluster info and submits no workflow.
+func (s *server) PlanCluster(ctx context.Context, in *pb.CreateClusterRequest) (*PlanClusterResponse, error) {
+	log.Info("Request 'PlanCluster' for contractId : ", in.GetContractId())
 
-	if err := validateImportClusterRequest(in); err != nil {
-		return &pb.IDResponse{
-			Code: pb.Code_INVALID_ARGUMENT,
+	plan, code, msg, err := s.planCreateCluster(ctx, in)
+	if code != pb.Code_OK_UNSPECIFIED {
+		return &PlanClusterResponse{
+			Code: code,
 			Error: &pb.Error{
-				Msg: fmt.Sprint(err),
+				Msg: msg,
 			},
 		}, err
 	}
+	return &PlanClusterResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+		Plan:  plan,
+	}, nil
+}
+
+// clusterIdPlaceholder stands for the cluster ID in the parameters of a plan.
+const clusterIdPlaceholder = "<cluster_id>"
+
+// parameters returns the workflow parameters of the plan for the added cluster.
+func (p *ClusterPlan) parameters(clusterId string) []string {
+	res := make([]string, 0, len(p.Parameters))
+	for _, param := range p.Parameters {
+		res = append(res, strings.ReplaceAll(param, clusterIdPlaceholder, clusterId))
+	}
+	return res
+}
+
+// planCreateCluster resolves the contract, the CSP and the cluster conf of the request.
+// It only reads from other services, so that it is shared by CreateCluster and PlanCluster.
+// A failure is reported by a code other than OK with the message for the response.
+func (s *server) planCreateCluster(ctx context.Context, in *pb.CreateClusterRequest) (plan *ClusterPlan, code pb.Code, msg string, err error) {
+	if err := validateCreateClusterRequest(in); err != nil {
+		return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
+	}
 
 	contractId := in.GetContractId()
-	cspId := ""
+	cspId := in.GetCspId()
+	templateName := in.GetTemplateName()
 
 	// get default contract if contractId is empty
 	if contractId == "" {
 		contract, err := s.getDefaultContract(ctx)
 		if err != nil {
 			log.Error("Failed to get default contract. err : ", err)
-			return &pb.IDResponse{
-				Code: pb.Code_NOT_FOUND,
-				Error: &pb.Error{
-					Msg: "Failed to get default contract",
-				},
-			}, err
+			return nil, pb.Code_NOT_FOUND, "Failed to get default contract", err
 		}
 		contractId = contract.GetContractId()
 
+		res, err := cspInfoClient.GetCSPIDsByContractID(ctx, &pb.IDRequest{Id: contractId})
+		if err != nil || len(res.Ids) == 0 {
+			log.Error("Failed to get csp ids by contractId err : ", err)
+			return nil, pb.Code_NOT_FOUND, fmt.Sprintf("Invalid CSP Id %s", cspId), err
+		}
+
+		// A contract can have several CSPs, so the CSP must be given unless there is only one.
+		if cspId == "" {
+			if len(res.Ids) > 1 {
+				log.Error("CSP Id must be specified. the number of CSPs : ", len(res.Ids))
+				return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprintf("CSP Id must be specified. contract %s has %d CSPs", contractId, len(res.Ids)),
+					fmt.Errorf("CSP Id must be specified. contract %s has %d CSPs", contractId, len(res.Ids))
+			}
+			cspId = res.Ids[0]
+		}
+	} else {
+		// check contract
+		if _, err := contractClient.GetContract(ctx, &pb.GetContractRequest{ContractId: contractId}); err != nil {
+			log.Error("Failed to get contract info err : ", err)
+			return nil, pb.Code_NOT_FOUND, fmt.Sprintf("Invalid contract Id %s", contractId), err
+		}
+	}
+
+	// check csp
+	cspInfo, err := cspInfoClient.GetCSPInfo(ctx, &pb.IDRequest{Id: cspId})
+	if err != nil {
+		log.Error("Failed to get csp info err : ", err)
+		return nil, pb.Code_NOT_FOUND, fmt.Sprintf("Invalid CSP Id %s", cspId), err
+	}
+	if cspInfo.GetContractId() != contractId {
+		log.Error("Invalid contractId by cspId : ", cspInfo.GetContractId())
+		return nil, pb.Code_NOT_FOUND, fmt.Sprintf("ContractId and CSP Id do not match. expected contractId : %s", cspInfo.GetContractId()),
+			fmt.Errorf("ContractId and CSP Id do not match. expected contractId : %s", cspInfo.GetContractId())
+	}
+
+	provider, err := getCspProvider(cspInfo.GetCspType().String())
+	if err != nil {
+		log.Error("Failed to get csp provider. err : ", err)
+		return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
+	}
+
+	/***************************
+	 * Pre-process cluster conf *
+	 ***************************/
+	rawConf := in.GetConf()
+	log.Debug(fmt.Sprintf("ClusterRawConf: %+v", rawConf))
+
+	policy := autoscalingPolicies.policy(contractId)
+	explicitSize, err := getSizePerAz(ctx)
+	if err != nil {
+		return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
+	}
+	clConf, size, err := constructClusterConf(provider, rawConf, policy, explicitSize)
+	if err != nil {
+		return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
+	}
+
+	labels, err := getClusterLabels(ctx)
+	if err != nil {
+		return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
 	}
 
-	res, err := cspInfoClient.GetCSPIDsByContractID(ctx, &pb.IDRequest{Id: contractId})
-	if err != nil || len(res.Ids) == 0 {
-		log.Error("Failed to get csp ids by contractId err : ", err)
+	// additional node pools
+	nodePools, err := getNodePools(ctx)
+	if err == nil {
+		err = validateNodePools(provider, policy, clConf.GetRegion(), int(clConf.GetNumOfAz()), nodePools)
+	}
+	if err != nil {
+		log.Error("Invalid node pools. err : ", err)
+		return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
+	}
+
+	// The cluster ID is issued by tks-info, so the parameters have a placeholder for it.
+	parameters := []string{
+		"contract_id=" + contractId,
+		"cluster_id=" + clusterIdPlaceholder,
+		"site_name=" + clusterIdPlaceholder,
+		"template_name=" + templateName,
+		"git_account=" + gitAccount,
+		"manifest_repo_url=" + gitBaseUrl + "/" + gitAccount + "/" + clusterIdPlaceholder + "-manifests",
+		"revision=" + revision,
+		"desired_size_per_az=" + strconv.Itoa(size.DesiredSizePerAz),
+	}
+	if len(nodePools) > 0 {
+		nodePoolsJson, _ := json.Marshal(nodePools)
+		parameters = append(parameters, "node_pools="+string(nodePoolsJson))
+	}
+
+	return &ClusterPlan{
+		ContractId:        contractId,
+		CspId:             cspId,
+		CspType:           provider.Name(),
+		Conf:              clConf,
+		DesiredSizePerAz:  size.DesiredSizePerAz,
+		NodePools:         nodePools,
+		Labels:            labels,
+		KubernetesVersion: k8sVersions.defaults(),
+		Workflow:          provider.CreateWorkflow(),
+		Parameters:        parameters,
+	}, pb.Code_OK_UNSPECIFIED, "", nil
+}
+
+func (s *server) ImportCluster(ctx context.Context, in *pb.ImportClusterRequest) (*pb.IDResponse, error) {
+	return withIdempotencyKey(ctx, "ImportCluster", in, func() (*pb.IDResponse, error) {
+		return s.importCluster(ctx, in)
+	})
+}
+
+func (s *server) importCluster(ctx context.Context, in *pb.ImportClusterRequest) (*pb.IDResponse, error) {
+	log.Debug("Request 'ImportCluster' for cluster Name:", in.GetName())
+
+	plan, code, msg, err := s.planImportCluster(ctx, in)
+	if code != pb.Code_OK_UNSPECIFIED {
 		return &pb.IDResponse{
-			Code: pb.Code_NOT_FOUND,
+			Code: code,
 			Error: &pb.Error{
-				Msg: fmt.Sprintf("Invalid CSP Id %s", cspId),
+				Msg: msg,
 			},
 		}, err
 	}
-	cspId = res.Ids[0]
-
-	templateName := in.GetTemplateName()
 
 	// create cluster info
 	clusterId := ""
 	resAddClusterInfo, err := clusterInfoClient.AddClusterInfo(ctx, &pb.AddClusterInfoRequest{
-		ContractId:  contractId,
-		CspId:       cspId,
+		ContractId:  plan.ContractId,
+		CspId:       plan.CspId,
 		Name:        in.GetName(),
 		Description: in.GetDescription(),
-		Conf:        &pb.ClusterConf{},
+		Conf:        plan.Conf,
 		Creator:     in.GetCreator(),
 	})
 	if err != nil {
@@ -420,36 +549,45 @@ func (s *server) ImportCluster(ctx context.Context, in *pb.ImportClusterRequest)
 
 	// import usercluster
 	nameSpace := "argo"
-	workflow := "import-tks-usercluster"
-	manifestRepoUrl := gitBaseUrl + "/" + gitAccount + "/" + clusterId + "-manifests"
-	kubeconfigBase64 := base64.StdEncoding.EncodeToString([]byte(in.GetKubeconfig()))
-	gitBaseUrlTrimed := gitBaseUrl
+	workflow := plan.Workflow
 
-	if strings.Contains(gitBaseUrl, "http://") {
-		gitBaseUrlTrimed = strings.Replace(gitBaseUrl, "http://", "", 1)
-	} else if strings.Contains(gitBaseUrl, "https://") {
-		gitBaseUrlTrimed =
-			strings.Replace(gitBaseUrl, "https://", "", 1)
-	}
-
-	opts := argowf.SubmitOptions{}
-	opts.Parameters = []string{
-		"contract_id=" + contractId,
-		"cluster_id=" + clusterId,
-		"kubeconfig=" + kubeconfigBase64,
-		"site_name=" + clusterId,
-		"template_name=" + templateName,
-		"git_account=" + gitAccount,
-		"git_base_url=" + gitBaseUrlTrimed,
-		"manifest_repo_url=" + manifestRepoUrl,
-		"revision=" + revision,
+	// The production marker is stored before the workflow, so that the cluster is never deleted without the confirmation.
+	if isProduction(plan.Labels) {
+		if err := setProductionCluster(ctx, clusterId, plan.Labels); err != nil {
+			log.Error("Failed to store the production marker. err : ", err)
+			s.compensateClusterInfo(ctx, clusterId, fmt.Sprintf("Failed to store the production marker : %s", err))
+			return &pb.IDResponse{
+				Code: pb.Code_INTERNAL,
+				Error: &pb.Error{
+					Msg: fmt.Sprintf("Failed to store the production marker : %s", err),
+				},
+			}, err
+		}
+	}
+
+	// The workflow is given the name of the secret only, so that the kubeconfig never shows up in argo.
+	secretName := kubeconfigSecretName(clusterId)
+	if err := secrets.put(ctx, secretName, map[string][]byte{kubeconfigSecretKey: in.GetKubeconfig()}); err != nil {
+		log.Error("Failed to store the kubeconfig. err : ", err)
+		s.compensateClusterInfo(ctx, clusterId, fmt.Sprintf("Failed to store the kubeconfig : %s", err))
+		return &pb.IDResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to store the kubeconfig : %s", err),
+			},
+		}, err
 	}
 
+	opts := argowf.SubmitOptions{}
+	opts.Parameters = append(plan.parameters(clusterId), "kubeconfig_secret_name="+secretName)
+
 	log.Info("Submitting workflow: ", workflow)
 
 	workflowId, err := argowfClient.SumbitWorkflowFromWftpl(workflow, nameSpace, opts)
+	recordOperation(ctx, operationImportCluster, clusterId, workflowId, opts.Parameters, err)
 	if err != nil {
 		log.Error("failed to submit argo workflow template. err : ", err)
+		s.compensateClusterInfo(ctx, clusterId, fmt.Sprintf("Failed to submit workflow %s : %s", workflow, err))
 		return &pb.IDResponse{
 			Code: pb.Code_INTERNAL,
 			Error: &pb.Error{
@@ -464,6 +602,15 @@ func (s *server) ImportCluster(ctx context.Context, in *pb.ImportClusterRequest)
 		log.Error("Failed to update cluster status to 'INSTALLING'")
 	}
 
+	if len(plan.Labels) > 0 {
+		if err := clusterMetadatas.update(clusterId, func(md *clusterMetadata) error {
+			md.Labels = plan.Labels
+			return nil
+		}); err != nil {
+			log.Error("Failed to store cluster metadata. err : ", err)
+		}
+	}
+
 	log.Info("Successfully initiated user-cluster registration. clusterId: ", clusterId)
 
 	return &pb.IDResponse{
@@ -473,12 +620,245 @@ func (s *server) ImportCluster(ctx context.Context, in *pb.ImportClusterRequest)
 	}, nil
 }
 
+// PlanImportCluster runs all validation and resolution of ImportCluster and returns what it would do.
+// It adds no cluster info and submits no workflow.
+func (s *server) PlanImportCluster(ctx context.Context, in *pb.ImportClusterRequest) (*PlanClusterResponse, error) {
+	log.Info("Request 'PlanImportCluster' for cluster Name : ", in.GetName())
+
+	plan, code, msg, err := s.planImportCluster(ctx, in)
+	if code != pb.Code_OK_UNSPECIFIED {
+		return &PlanClusterResponse{
+			Code: code,
+			Error: &pb.Error{
+				Msg: msg,
+			},
+		}, err
+	}
+	return &PlanClusterResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+		Plan:  plan,
+	}, nil
+}
+
+// planImportCluster resolves the contract and the CSP of the request.
+// The CSP decides the workflow parameters to delete the cluster later, so it is checked as CreateCluster does.
+// The kubeconfig secret is added to the parameters on import only, since it is stored then.
+func (s *server) planImportCluster(ctx context.Context, in *pb.ImportClusterRequest) (plan *ClusterPlan, code pb.Code, msg string, err error) {
+	if err := validateImportClusterRequest(in); err != nil {
+		return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
+	}
+	if kubeconfigProbeTimeout > 0 {
+		target, _ := parseKubeconfig(in.GetKubeconfig())
+		if err := probeKubeconfig(ctx, target, kubeconfigProbeTimeout); err != nil {
+			log.Error("Failed to probe the API server of the kubeconfig. err : ", err)
+			return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
+		}
+	}
+
+	contractId := in.GetContractId()
+
+	// get default contract if contractId is empty
+	if contractId == "" {
+		contract, err := s.getDefaultContract(ctx)
+		if err != nil {
+			log.Error("Failed to get default contract. err : ", err)
+			return nil, pb.Code_NOT_FOUND, "Failed to get default contract", err
+		}
+		contractId = contract.GetContractId()
+
+	}
+
+	// The request has no CSP ID, so it comes in the header unless the contract has only one CSP.
+	cspId, err := getCspId(ctx)
+	if err != nil {
+		return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
+	}
+	if cspId == "" {
+		res, err := cspInfoClient.GetCSPIDsByContractID(ctx, &pb.IDRequest{Id: contractId})
+		if err != nil || len(res.Ids) == 0 {
+			log.Error("Failed to get csp ids by contractId err : ", err)
+			return nil, pb.Code_NOT_FOUND, fmt.Sprintf("Could not find CSP of contract %s", contractId), err
+		}
+		if len(res.Ids) > 1 {
+			log.Error("CSP Id must be specified. the number of CSPs : ", len(res.Ids))
+			return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprintf("CSP Id must be specified with the %s header. contract %s has %d CSPs", cspIdHeader, contractId, len(res.Ids)),
+				fmt.Errorf("CSP Id must be specified with the %s header. contract %s has %d CSPs", cspIdHeader, contractId, len(res.Ids))
+		}
+		cspId = res.Ids[0]
+	}
+
+	// check csp
+	cspInfo, err := cspInfoClient.GetCSPInfo(ctx, &pb.IDRequest{Id: cspId})
+	if err != nil {
+		log.Error("Failed to get csp info err : ", err)
+		return nil, pb.Code_NOT_FOUND, fmt.Sprintf("Invalid CSP Id %s", cspId), err
+	}
+	if cspInfo.GetContractId() != contractId {
+		log.Error("Invalid contractId by cspId : ", cspInfo.GetContractId())
+		return nil, pb.Code_NOT_FOUND, fmt.Sprintf("ContractId and CSP Id do not match. expected contractId : %s", cspInfo.GetContractId()),
+			fmt.Errorf("ContractId and CSP Id do not match. expected contractId : %s", cspInfo.GetContractId())
+	}
+	provider, err := getCspProvider(cspInfo.GetCspType().String())
+	if err != nil {
+		log.Error("Failed to get csp provider. err : ", err)
+		return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
+	}
+
+	labels, err := getClusterLabels(ctx)
+	if err != nil {
+		return nil, pb.Code_INVALID_ARGUMENT, fmt.Sprint(err), err
+	}
+
+	templateName := in.GetTemplateName()
+
+	gitBaseUrlTrimed := gitBaseUrl
+	if strings.Contains(gitBaseUrl, "http://") {
+		gitBaseUrlTrimed = strings.Replace(gitBaseUrl, "http://", "", 1)
+	} else if strings.Contains(gitBaseUrl, "https://") {
+		gitBaseUrlTrimed =
+			strings.Replace(gitBaseUrl, "https://", "", 1)
+	}
+
+	// The cluster ID is issued by tks-info, so the parameters have a placeholder for it.
+	parameters := []string{
+		"contract_id=" + contractId,
+		"cluster_id=" + clusterIdPlaceholder,
+		"site_name=" + clusterIdPlaceholder,
+		"template_name=" + templateName,
+		"git_account=" + gitAccount,
+		"git_base_url=" + gitBaseUrlTrimed,
+		"manifest_repo_url=" + gitBaseUrl + "/" + gitAccount + "/" + clusterIdPlaceholder + "-manifests",
+		"revision=" + revision,
+	}
+
+	return &ClusterPlan{
+		ContractId: contractId,
+		CspId:      cspId,
+		CspType:    provider.Name(),
+		Conf:       &pb.ClusterConf{},
+		Labels:     labels,
+		Workflow:   "import-tks-usercluster",
+		Parameters: parameters,
+	}, pb.Code_OK_UNSPECIFIED, "", nil
+}
+
 // ScaleCluster scales the Kubernetes cluster
 func (s *server) ScaleCluster(ctx context.Context, in *pb.ScaleClusterRequest) (*pb.SimpleResponse, error) {
-	log.Debug("Request 'ScaleCluster' for cluster ID:", in.GetClusterId())
-	log.Warn("Not Implemented gRPC API: 'ScaleCluster'")
+	log.Info("Request 'ScaleCluster' for clusterId : ", in.GetClusterId())
+
+	explicitSize, err := getSizePerAz(ctx)
+	if err == nil {
+		err = validateScaleClusterRequest(in, explicitSize)
+	}
+	if err != nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+	clusterId := in.GetClusterId()
+
+	// Validation : check cluster status
+	// The cluster status must be RUNNING.
+	res, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: clusterId})
+	if err != nil {
+		log.Error("Failed to get cluster info err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_NOT_FOUND,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Could not find Cluster with ID %s", clusterId),
+			},
+		}, err
+	}
+	if res.GetCluster().GetStatus() != pb.ClusterStatus_RUNNING {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The cluster can not be scaled. cluster status : %s", res.GetCluster().GetStatus()),
+			},
+		}, fmt.Errorf("The cluster can not be scaled. cluster status : %s", res.GetCluster().GetStatus())
+	}
+
+	// Validation : check replicas against the stored cluster conf
+	conf := res.GetCluster().GetConf()
+	if conf == nil || conf.GetNumOfAz() <= 0 {
+		return &pb.SimpleResponse{
+			Code: pb.Code_FAILED_PRECONDITION,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The cluster has no scalable node group. clusterId : %s", clusterId),
+			},
+		}, fmt.Errorf("The cluster has no scalable node group. clusterId : %s", clusterId)
+	}
+
+	policy := autoscalingPolicies.policy(res.GetCluster().GetContractId())
+	size, err := policy.sizePerAz(explicitSize, int(in.GetWorkerReplicas()), int(conf.GetNumOfAz()))
+	if err != nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+
+	provider, code, msg, err := clusterCspProvider(ctx, res.GetCluster())
+	if code != pb.Code_OK_UNSPECIFIED {
+		return &pb.SimpleResponse{
+			Code: code,
+			Error: &pb.Error{
+				Msg: msg,
+			},
+		}, err
+	}
+
+	nameSpace := "argo"
+	workflow := provider.ScaleWorkflow()
+	manifestRepoUrl := gitBaseUrl + "/" + gitAccount + "/" + clusterId + "-manifests"
+
+	opts := argowf.SubmitOptions{}
+	opts.Parameters = []string{
+		"cluster_id=" + clusterId,
+		"site_name=" + clusterId,
+		"git_account=" + gitAccount,
+		"manifest_repo_url=" + manifestRepoUrl,
+		"revision=" + revision,
+		"tks_info_host=tks-info.tks.svc",
+		"min_size_per_az=" + strconv.Itoa(size.MinSizePerAz),
+		"desired_size_per_az=" + strconv.Itoa(size.DesiredSizePerAz),
+		"max_size_per_az=" + strconv.Itoa(size.MaxSizePerAz),
+	}
+	if in.GetMasterReplicas() > 0 {
+		opts.Parameters = append(opts.Parameters, "master_replicas="+strconv.Itoa(int(in.GetMasterReplicas())))
+	}
+
+	log.Info("Submitting workflow: ", workflow)
+	workflowId, err := argowfClient.SumbitWorkflowFromWftpl(workflow, nameSpace, opts)
+	recordOperation(ctx, operationScaleCluster, clusterId, workflowId, opts.Parameters, err)
+	if err != nil {
+		log.Error("failed to submit argo workflow template. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to call argo workflow : %s", err),
+			},
+		}, err
+	}
+	log.Debug("submited workflow name : ", workflowId)
+
+	// The cluster conf and metadata are updated with the new sizes by the reconciler once the workflow succeeds.
+
+	// update status : INSTALLING
+	// ClusterStatus has no dedicated scaling state, so INSTALLING marks the cluster busy until the workflow ends.
+	if err := s.updateClusterStatusWithWorkflowId(ctx, clusterId, pb.ClusterStatus_INSTALLING, workflowId); err != nil {
+		log.Error("Failed to update cluster status to 'INSTALLING'")
+	}
+
+	log.Info("Successfully initiated user-cluster scaling. clusterId: ", clusterId)
 	return &pb.SimpleResponse{
-		Code:  pb.Code_UNIMPLEMENTED,
+		Code:  pb.Code_OK_UNSPECIFIED,
 		Error: nil,
 	}, nil
 }
@@ -519,13 +899,57 @@ func (s *server) DeleteCluster(ctx context.Context, in *pb.IDRequest) (*pb.Simpl
 		}, fmt.Errorf("The cluster can not be deleted. cluster status : %s", res.GetCluster().GetStatus())
 	}
 
+	// Validation : check deletion protection
+	// A production cluster must also be confirmed by its name.
+	protection, err := getDeletionProtection(ctx, clusterId)
+	if err != nil {
+		log.Error("Failed to get deletion protection. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to get deletion protection. err : %s", err),
+			},
+		}, err
+	}
+	if protection.Enabled {
+		return &pb.SimpleResponse{
+			Code: pb.Code_FAILED_PRECONDITION,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Deletion protection of the cluster %s is enabled. clear it first", clusterId),
+			},
+		}, fmt.Errorf("Deletion protection of the cluster %s is enabled. clear it first", clusterId)
+	}
+	if confirmed := getConfirmedClusterName(ctx); protection.Production && (confirmed == "" || confirmed != res.GetCluster().GetName()) {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The production cluster %s must be confirmed by its name with the %s header", clusterId, confirmClusterNameHeader),
+			},
+		}, fmt.Errorf("The production cluster %s must be confirmed by its name with the %s header", clusterId, confirmClusterNameHeader)
+	}
+
 	// Validation : check appgroup status
+	// With cascade, the remaining app groups are removed before the cluster.
+	cascade, err := getCascadeDelete(ctx)
+	if err != nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+	remainingAppGroups := []*pb.AppGroup{}
 	resAppGroups, err := appInfoClient.GetAppGroupsByClusterID(ctx, &pb.IDRequest{
 		Id: clusterId,
 	})
 	if err == nil && resAppGroups.Code == pb.Code_OK_UNSPECIFIED {
 		for _, resAppGroup := range resAppGroups.GetAppGroups() {
 			if resAppGroup.GetStatus() != pb.AppGroupStatus_APP_GROUP_DELETED {
+				if cascade {
+					remainingAppGroups = append(remainingAppGroups, resAppGroup)
+					continue
+				}
 				return &pb.SimpleResponse{
 					Code: pb.Code_INVALID_ARGUMENT,
 					Error: &pb.Error{
@@ -536,18 +960,29 @@ func (s *server) DeleteCluster(ctx context.Context, in *pb.IDRequest) (*pb.Simpl
 		}
 	}
 
+	provider, code, msg, err := clusterCspProvider(ctx, res.GetCluster())
+	if code != pb.Code_OK_UNSPECIFIED {
+		return &pb.SimpleResponse{
+			Code: code,
+			Error: &pb.Error{
+				Msg: msg,
+			},
+		}, err
+	}
+
+	if len(remainingAppGroups) > 0 {
+		return s.cascadeDeleteCluster(ctx, clusterId, provider, remainingAppGroups)
+	}
+
 	nameSpace := "argo"
-	workflow := "tks-remove-usercluster"
+	workflow := provider.DeleteWorkflow()
 
 	opts := argowf.SubmitOptions{}
-	opts.Parameters = []string{
-		"app_group=tks-cluster-aws",
-		"tks_info_host=tks-info.tks.svc",
-		"cluster_id=" + clusterId,
-	}
+	opts.Parameters = provider.DeleteParameters(clusterId)
 
 	log.Info("Submitting workflow: ", workflow)
 	workflowId, err := argowfClient.SumbitWorkflowFromWftpl(workflow, nameSpace, opts)
+	recordOperation(ctx, operationDeleteCluster, clusterId, workflowId, opts.Parameters, err)
 	if err != nil {
 		log.Error("failed to submit argo workflow template. err : ", err)
 		return &pb.SimpleResponse{
@@ -570,55 +1005,239 @@ func (s *server) DeleteCluster(ctx context.Context, in *pb.IDRequest) (*pb.Simpl
 	}, nil
 }
 
-// InstallAppGroups install apps, return a array of application id
-func (s *server) InstallAppGroups(ctx context.Context, in *pb.InstallAppGroupsRequest) (*pb.IDsResponse, error) {
-	log.Debug("Request 'InstallAppGroups' ")
+// SetDeletionProtection enables or clears the deletion protection of the cluster.
+// The protection is kept in the secret store, which all replicas share with the kubernetes backend.
+func (s *server) SetDeletionProtection(ctx context.Context, in *SetDeletionProtectionRequest) (*pb.SimpleResponse, error) {
+	log.Info(fmt.Sprintf("Request 'SetDeletionProtection' for clusterId : %s, deletionProtection : %t", in.ClusterId, in.DeletionProtection))
 
-	if err := validateInstallAppGroupsRequest(in); err != nil {
-		return &pb.IDsResponse{
+	clusterId := in.ClusterId
+	if !helper.ValidateClusterId(clusterId) {
+		return &pb.SimpleResponse{
 			Code: pb.Code_INVALID_ARGUMENT,
 			Error: &pb.Error{
-				Msg: fmt.Sprint(err),
+				Msg: fmt.Sprintf("invalid cluster ID %s", clusterId),
+			},
+		}, fmt.Errorf("invalid cluster ID %s", clusterId)
+	}
+	if _, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: clusterId}); err != nil {
+		log.Error("Failed to get cluster info err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_NOT_FOUND,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Could not find Cluster with ID %s", clusterId),
 			},
 		}, err
 	}
 
-	appGroupIds := []string{}
-	for _, appGroup := range in.GetAppGroups() {
-		log.Debug("appGroup : ", appGroup)
+	if err := setDeletionProtection(ctx, clusterId, in.DeletionProtection); err != nil {
+		log.Error("Failed to update deletion protection. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to update deletion protection. err : %s", err),
+			},
+		}, err
+	}
 
-		clusterId := appGroup.GetClusterId()
-		contractId := ""
+	return &pb.SimpleResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+	}, nil
+}
 
-		// Check Cluster
-		cluster, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: clusterId})
-		if err != nil {
-			log.Error("Failed to get cluster info err : ", err)
-			continue
-		}
-		if cluster == nil {
-			log.Error("Failed to get cluster info : ", appGroup.GetClusterId())
-			continue
-		}
-		log.Debug("cluster : ", cluster)
-		contractId = cluster.GetCluster().GetContractId()
-		log.Debug("contractId ", contractId)
+// UpdateCluster updates the mutable metadata of the cluster.
+// tks-info has no API to update the name and the description, so a request which changes them is rejected
+// as UNIMPLEMENTED, and only the labels are updated.
+func (s *server) UpdateCluster(ctx context.Context, in *UpdateClusterRequest) (*pb.SimpleResponse, error) {
+	log.Info("Request 'UpdateCluster' for clusterId : ", in.ClusterId)
 
-		appGroupId := ""
-		res, err := appInfoClient.GetAppGroupsByClusterID(ctx, &pb.IDRequest{
-			Id: clusterId,
-		})
-		if err == nil && res.Code == pb.Code_OK_UNSPECIFIED {
-			for _, resAppGroup := range res.GetAppGroups() {
-				if resAppGroup.GetAppGroupName() == appGroup.GetAppGroupName() &&
-					resAppGroup.GetType() == appGroup.GetType() &&
-					resAppGroup.GetExternalLabel() == appGroup.GetExternalLabel() {
-					appGroupId = resAppGroup.GetAppGroupId()
-					break
-				}
+	clusterId := in.ClusterId
+	if !helper.ValidateClusterId(clusterId) {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("invalid cluster ID %s", clusterId),
+			},
+		}, fmt.Errorf("invalid cluster ID %s", clusterId)
+	}
+	if in.Name != "" {
+		if err := validateClusterName(in.Name); err != nil {
+			return &pb.SimpleResponse{
+				Code: pb.Code_INVALID_ARGUMENT,
+				Error: &pb.Error{
+					Msg: fmt.Sprint(err),
+				},
+			}, err
+		}
+	}
+	if err := validateLabels(in.Labels); err != nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+
+	res, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: clusterId})
+	if err != nil {
+		log.Error("Failed to get cluster info err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_NOT_FOUND,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Could not find Cluster with ID %s", clusterId),
+			},
+		}, err
+	}
+	if (in.Name != "" && in.Name != res.GetCluster().GetName()) ||
+		(in.Description != "" && in.Description != res.GetCluster().GetDescription()) {
+		return &pb.SimpleResponse{
+			Code: pb.Code_UNIMPLEMENTED,
+			Error: &pb.Error{
+				Msg: "The name and the description of a cluster can not be updated, since tks-info does not support it",
+			},
+		}, errors.New("The name and the description of a cluster can not be updated, since tks-info does not support it")
+	}
+
+	if in.Labels != nil {
+		if err := setProductionCluster(ctx, clusterId, in.Labels); err != nil {
+			log.Error("Failed to update the production marker. err : ", err)
+			return &pb.SimpleResponse{
+				Code: pb.Code_INTERNAL,
+				Error: &pb.Error{
+					Msg: fmt.Sprintf("Failed to update the production marker : %s", err),
+				},
+			}, err
+		}
+		if err := clusterMetadatas.update(clusterId, func(md *clusterMetadata) error {
+			md.Labels = in.Labels
+			return nil
+		}); err != nil {
+			log.Error("Failed to update cluster metadata. err : ", err)
+			return &pb.SimpleResponse{
+				Code: pb.Code_INTERNAL,
+				Error: &pb.Error{
+					Msg: fmt.Sprintf("Failed to update cluster metadata. err : %s", err),
+				},
+			}, err
+		}
+	}
+
+	log.Info("Successfully updated cluster. clusterId: ", clusterId)
+	return &pb.SimpleResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+	}, nil
+}
+
+// InstallAppGroups install apps, return a array of application id
+//
+// The response has the IDs of the app groups which succeeded, and its code tells a full success (OK_UNSPECIFIED),
+// a partial success (ABORTED) and a total failure (the code of the failures) apart.
+// The result of every app group, in the order of the request, is sent as a JSON array in the x-app-group-results
+// header and trailer, since pb.IDsResponse has no field for it.
+func (s *server) InstallAppGroups(ctx context.Context, in *pb.InstallAppGroupsRequest) (*pb.IDsResponse, error) {
+	log.Debug("Request 'InstallAppGroups' ")
+
+	if err := validateInstallAppGroupsRequest(in); err != nil {
+		return &pb.IDsResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+
+	// The custom values of the app groups are validated with the schemas of the app group types.
+	valueParameters := map[*pb.AppGroup]string{}
+	appGroupValues, err := getAppGroupValues(ctx)
+	if err == nil {
+		valueParameters, err = validateAppGroupValues(in.GetAppGroups(), appGroupValues)
+	}
+	if err != nil {
+		return &pb.IDsResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+
+	// App groups are installed after the app groups which they depend on.
+	appGroups, err := sortAppGroups(in.GetAppGroups())
+	if err != nil {
+		return &pb.IDsResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+
+	indexes := map[*pb.AppGroup]int{}
+	for i, appGroup := range in.GetAppGroups() {
+		indexes[appGroup] = i
+	}
+	results := newAppGroupResults(len(in.GetAppGroups()))
+
+	// clusterAppGroups has the app groups of each cluster, including the ones of this request.
+	clusterAppGroups := map[string][]*pb.AppGroup{}
+	for _, appGroup := range appGroups {
+		log.Debug("appGroup : ", appGroup)
+		index := indexes[appGroup]
+
+		clusterId := appGroup.GetClusterId()
+		contractId := ""
+
+		// Check Cluster
+		cluster, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: clusterId})
+		if err != nil {
+			log.Error("Failed to get cluster info err : ", err)
+			results.fail(index, "", pb.Code_NOT_FOUND, fmt.Errorf("Could not find Cluster with ID %s", clusterId))
+			continue
+		}
+		if cluster == nil {
+			log.Error("Failed to get cluster info : ", appGroup.GetClusterId())
+			results.fail(index, "", pb.Code_NOT_FOUND, fmt.Errorf("Could not find Cluster with ID %s", clusterId))
+			continue
+		}
+		log.Debug("cluster : ", cluster)
+		contractId = cluster.GetCluster().GetContractId()
+		log.Debug("contractId ", contractId)
+
+		if _, ok := clusterAppGroups[clusterId]; !ok {
+			clusterAppGroups[clusterId] = []*pb.AppGroup{}
+			res, err := appInfoClient.GetAppGroupsByClusterID(ctx, &pb.IDRequest{
+				Id: clusterId,
+			})
+			if err == nil && res.Code == pb.Code_OK_UNSPECIFIED {
+				clusterAppGroups[clusterId] = res.GetAppGroups()
+			}
+		}
+
+		appGroupId := ""
+		for _, resAppGroup := range clusterAppGroups[clusterId] {
+			if resAppGroup.GetAppGroupName() == appGroup.GetAppGroupName() &&
+				resAppGroup.GetType() == appGroup.GetType() &&
+				resAppGroup.GetExternalLabel() == appGroup.GetExternalLabel() {
+				appGroupId = resAppGroup.GetAppGroupId()
+				break
 			}
 		}
 
+		t, err := appGroupTypes.get(appGroup.GetType())
+		if err != nil {
+			log.Error(err)
+			results.fail(index, appGroupId, pb.Code_INVALID_ARGUMENT, err)
+			continue
+		}
+		waits, err := appGroupDependencies(t, clusterAppGroups[clusterId])
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to install app group %s. err : ", appGroup.GetAppGroupName()), err)
+			results.fail(index, appGroupId, pb.Code_FAILED_PRECONDITION, err)
+			continue
+		}
+
 		if appGroupId == "" {
 			res, err := appInfoClient.CreateAppGroup(ctx, &pb.CreateAppGroupRequest{
 				ClusterId: appGroup.GetClusterId(),
@@ -626,6 +1245,7 @@ func (s *server) InstallAppGroups(ctx context.Context, in *pb.InstallAppGroupsRe
 			})
 			if err != nil {
 				log.Error("Failed to create app group info err : ", err)
+				results.fail(index, "", pb.Code_INTERNAL, fmt.Errorf("Failed to create app group : %s", err))
 				continue
 			}
 			appGroupId = res.GetId()
@@ -633,7 +1253,6 @@ func (s *server) InstallAppGroups(ctx context.Context, in *pb.InstallAppGroupsRe
 		log.Debug("appGroupId ", appGroupId)
 
 		// Call argo workflow template
-		workflowTemplate := ""
 		manifestRepoUrl := gitBaseUrl + "/" + gitAccount + "/" + clusterId + "-manifests"
 		opts := argowf.SubmitOptions{}
 		opts.Parameters = []string{
@@ -646,27 +1265,45 @@ func (s *server) InstallAppGroups(ctx context.Context, in *pb.InstallAppGroupsRe
 			"tks_info_host=tks-info.tks.svc",
 		}
 
-		switch appGroup.GetType() {
-		case pb.AppGroupType_LMA:
-			workflowTemplate = "tks-lma-federation"
-			opts.Parameters = append(opts.Parameters, "logging_component=loki")
+		workflowTemplate := t.InstallTemplate
+		opts.Parameters = append(opts.Parameters, t.installParameters()...)
+		if parameter, ok := valueParameters[appGroup]; ok {
+			opts.Parameters = append(opts.Parameters, parameter)
+		}
 
-		case pb.AppGroupType_LMA_EFK:
-			workflowTemplate = "tks-lma-federation"
-			opts.Parameters = append(opts.Parameters, "logging_component=efk")
+		// The app groups of this request which depend on this one wait for it.
+		clusterAppGroups[clusterId] = append(clusterAppGroups[clusterId], &pb.AppGroup{
+			AppGroupId: appGroupId,
+			ClusterId:  clusterId,
+			Type:       appGroup.GetType(),
+			Status:     pb.AppGroupStatus_APP_GROUP_INSTALLING,
+		})
 
-		case pb.AppGroupType_SERVICE_MESH:
-			workflowTemplate = "tks-service-mesh"
+		if len(waits) > 0 {
+			// The reconciler submits the workflow once the dependencies are running.
+			recordPendingOperation(ctx, operationInstallAppGroup, appGroupId, opts.Parameters, &pendingWorkflow{
+				Template:   workflowTemplate,
+				Parameters: opts.Parameters,
+				AppGroups:  waits,
+				Deadline:   time.Now().Add(appGroupDependencyTimeout),
+			})
+			if err := s.updateAppGroupStatusWithWorkflowId(ctx, appGroupId, pb.AppGroupStatus_APP_GROUP_INSTALLING, ""); err != nil {
+				log.Error("Failed to update appgroup status to 'APP_GROUP_INSTALLING'")
+			}
 
-		default:
-			log.Error("invalid appGroup type ", appGroup.GetType())
+			log.Info("App group waits for the dependencies. appGroupId : ", appGroupId)
+			results.succeed(index, appGroupId, "")
 			continue
 		}
 
 		log.Info("Submitting workflow: ", workflowTemplate)
 		workflowId, err := argowfClient.SumbitWorkflowFromWftpl(workflowTemplate, "argo", opts)
+		recordOperation(ctx, operationInstallAppGroup, appGroupId, workflowId, opts.Parameters, err)
 		if err != nil {
 			log.Error("failed to submit argo workflow template. err : ", err)
+			// The app groups which depend on this one must not wait for it.
+			clusterAppGroups[clusterId][len(clusterAppGroups[clusterId])-1].Status = pb.AppGroupStatus_APP_GROUP_ERROR
+			results.fail(index, appGroupId, pb.Code_INTERNAL, fmt.Errorf("Failed to call argo workflow : %s", err))
 			continue
 		}
 		log.Debug("submited workflow name :", workflowId)
@@ -675,19 +1312,20 @@ func (s *server) InstallAppGroups(ctx context.Context, in *pb.InstallAppGroupsRe
 			log.Error("Failed to update appgroup status to 'APP_GROUP_INSTALLING'")
 		}
 
-		appGroupIds = append(appGroupIds, appGroupId)
+		results.succeed(index, appGroupId, workflowId)
 	}
 
-	log.Info("Successfully submitted installation workflow. appGroupIds: ", appGroupIds)
-
-	return &pb.IDsResponse{
-		Code:  pb.Code_OK_UNSPECIFIED,
-		Error: nil,
-		Ids:   appGroupIds,
-	}, nil
+	res, err := results.response(ctx)
+	log.Info("Submitted installation workflows. appGroupIds: ", res.Ids)
+	return res, err
 }
 
 // UninstallAppGroups uninstall apps
+//
+// The response has the IDs of the app groups which succeeded, and its code tells a full success (OK_UNSPECIFIED),
+// a partial success (ABORTED) and a total failure (the code of the failures) apart.
+// The result of every app group, in the order of the request, is sent as a JSON array in the x-app-group-results
+// header and trailer, since pb.IDsResponse has no field for it.
 func (s *server) UninstallAppGroups(ctx context.Context, in *pb.UninstallAppGroupsRequest) (*pb.IDsResponse, error) {
 	log.Debug("Request 'UninstallAppGroups'")
 
@@ -700,80 +1338,1029 @@ func (s *server) UninstallAppGroups(ctx context.Context, in *pb.UninstallAppGrou
 		}, err
 	}
 
-	resAppGroupIds := []string{}
-	for _, appGroupId := range in.GetAppGroupIds() {
-		log.Debug("deleting appGroupId : ", appGroupId)
-
+	results := newAppGroupResults(len(in.GetAppGroupIds()))
+	appGroups := []*pb.AppGroup{}
+	indexes := map[*pb.AppGroup]int{}
+	removing := map[string]bool{}
+	for i, appGroupId := range in.GetAppGroupIds() {
 		res, err := appInfoClient.GetAppGroup(ctx, &pb.GetAppGroupRequest{
 			AppGroupId: appGroupId,
 		})
 		if err != nil {
 			log.Error("Failed to get app group info err : ", err)
+			results.fail(i, appGroupId, pb.Code_NOT_FOUND, fmt.Errorf("Could not find app group with ID %s", appGroupId))
 			continue
 		}
+		appGroups = append(appGroups, res.GetAppGroup())
+		indexes[res.GetAppGroup()] = i
+		removing[res.GetAppGroup().GetAppGroupId()] = true
+	}
 
-		appGroup := res.GetAppGroup()
-		clusterId := appGroup.GetClusterId()
-
-		// Call argo workflow template
-		workflowTemplate := ""
-		appGroupName := ""
-
-		switch appGroup.GetType() {
-		case pb.AppGroupType_LMA, pb.AppGroupType_LMA_EFK:
-			workflowTemplate = "tks-remove-lma-federation"
-			appGroupName = "lma"
-
-		case pb.AppGroupType_SERVICE_MESH:
-			workflowTemplate = "tks-remove-servicemesh"
-			appGroupName = "service-mesh"
-
-		default:
-			log.Error("invalid appGroup type ", appGroup.GetType())
-			continue
+	// The app groups are removed after the app groups which depend on them.
+	sorted, err := sortAppGroups(appGroups)
+	if err != nil {
+		log.Error(err)
+		for _, appGroup := range appGroups {
+			results.fail(indexes[appGroup], in.GetAppGroupIds()[indexes[appGroup]], pb.Code_INVALID_ARGUMENT, err)
 		}
+		return results.response(ctx)
+	}
 
-		opts := argowf.SubmitOptions{}
-		opts.Parameters = []string{
-			"app_group=" + appGroupName,
-			"github_account=" + gitAccount,
-			"tks_info_host=tks-info.tks.svc",
-			"cluster_id=" + clusterId,
-			"app_group_id=" + appGroupId,
+	clusterAppGroups := map[string][]*pb.AppGroup{}
+	for i := len(sorted) - 1; i >= 0; i-- {
+		appGroup := sorted[i]
+		index := indexes[appGroup]
+		appGroupId := in.GetAppGroupIds()[index]
+		clusterId := appGroup.GetClusterId()
+		log.Debug("deleting appGroupId : ", appGroupId)
+
+		// Validation : no other app group in the cluster depends on the app group.
+		if appGroupTypes.hasDependents(appGroup.GetType()) {
+			if _, ok := clusterAppGroups[clusterId]; !ok {
+				res, err := appInfoClient.GetAppGroupsByClusterID(ctx, &pb.IDRequest{
+					Id: clusterId,
+				})
+				if err != nil || res.Code != pb.Code_OK_UNSPECIFIED {
+					log.Error(fmt.Sprintf("Failed to get app groups of cluster %s. err : ", clusterId), err)
+					results.fail(index, appGroupId, pb.Code_INTERNAL, fmt.Errorf("Failed to get app groups of cluster %s", clusterId))
+					delete(removing, appGroup.GetAppGroupId())
+					continue
+				}
+				clusterAppGroups[clusterId] = res.GetAppGroups()
+			}
+			if dependents := appGroupDependents(appGroup, clusterAppGroups[clusterId], removing); len(dependents) > 0 {
+				err := fmt.Errorf("app groups %s depend on the app group %s", strings.Join(dependents, ","), appGroupId)
+				log.Error(err)
+				results.fail(index, appGroupId, pb.Code_FAILED_PRECONDITION, err)
+				delete(removing, appGroup.GetAppGroupId())
+				continue
+			}
 		}
 
-		workflowId, err := argowfClient.SumbitWorkflowFromWftpl(workflowTemplate, "argo", opts)
+		workflowId, err := s.submitUninstallAppGroup(ctx, appGroup)
 		if err != nil {
-			log.Error("failed to submit argo workflow template. err : ", err)
+			results.fail(index, appGroupId, pb.Code_INTERNAL, fmt.Errorf("Failed to call argo workflow : %s", err))
+			// The app groups which it depends on are still needed.
+			delete(removing, appGroup.GetAppGroupId())
 			continue
 		}
-		log.Debug("submited workflow name :", workflowId)
+		results.succeed(index, appGroupId, workflowId)
+	}
+
+	return results.response(ctx)
+}
+
+// submitUninstallAppGroup submits the remove workflow of the app group and marks the app group DELETING.
+func (s *server) submitUninstallAppGroup(ctx context.Context, appGroup *pb.AppGroup) (string, error) {
+	appGroupId := appGroup.GetAppGroupId()
+	clusterId := appGroup.GetClusterId()
+
+	// Call argo workflow template
+	t, err := appGroupTypes.get(appGroup.GetType())
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+	workflowTemplate := t.UninstallTemplate
+
+	opts := argowf.SubmitOptions{}
+	opts.Parameters = []string{
+		"app_group=" + t.Name,
+		"github_account=" + gitAccount,
+		"tks_info_host=tks-info.tks.svc",
+		"cluster_id=" + clusterId,
+		"app_group_id=" + appGroupId,
+	}
+
+	workflowId, err := argowfClient.SumbitWorkflowFromWftpl(workflowTemplate, "argo", opts)
+	recordOperation(ctx, operationUninstallAppGroup, appGroupId, workflowId, opts.Parameters, err)
+	if err != nil {
+		log.Error("failed to submit argo workflow template. err : ", err)
+		return "", err
+	}
+	log.Debug("submited workflow name :", workflowId)
+
+	if err := s.updateAppGroupStatusWithWorkflowId(ctx, appGroupId, pb.AppGroupStatus_APP_GROUP_DELETING, workflowId); err != nil {
+		log.Error("Failed to update appgroup status to 'APP_GROUP_DELETING'")
+	}
+	return workflowId, nil
+}
+
+// UpgradeAppGroup moves an installed app group to another revision of the manifests.
+// The custom values of the app group are kept.
+func (s *server) UpgradeAppGroup(ctx context.Context, in *UpgradeAppGroupRequest) (*pb.SimpleResponse, error) {
+	log.Info("Request 'UpgradeAppGroup' for appGroupId : ", in.AppGroupId)
+
+	appGroupId := in.AppGroupId
+	if !helper.ValidateApplicationGroupId(appGroupId) {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("invalid app group ID %s", appGroupId),
+			},
+		}, fmt.Errorf("invalid app group ID %s", appGroupId)
+	}
+	if err := validateRevision(in.Revision); err != nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+
+	// Validation : check app group status
+	// The app group status must be RUNNING.
+	res, err := appInfoClient.GetAppGroup(ctx, &pb.GetAppGroupRequest{AppGroupId: appGroupId})
+	if err != nil {
+		log.Error("Failed to get app group info err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_NOT_FOUND,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Could not find app group with ID %s", appGroupId),
+			},
+		}, err
+	}
+	appGroup := res.GetAppGroup()
+	if appGroup.GetStatus() != pb.AppGroupStatus_APP_GROUP_RUNNING {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The app group can not be upgraded. app group status : %s", appGroup.GetStatus()),
+			},
+		}, fmt.Errorf("The app group can not be upgraded. app group status : %s", appGroup.GetStatus())
+	}
+
+	t, err := appGroupTypes.get(appGroup.GetType())
+	if err != nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+	if t.UpgradeTemplate == "" {
+		return &pb.SimpleResponse{
+			Code: pb.Code_UNIMPLEMENTED,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The app group type %s can not be upgraded", appGroup.GetType()),
+			},
+		}, fmt.Errorf("The app group type %s can not be upgraded", appGroup.GetType())
+	}
 
-		resAppGroupIds = append(resAppGroupIds, appGroupId)
-		if err := s.updateAppGroupStatusWithWorkflowId(ctx, appGroupId, pb.AppGroupStatus_APP_GROUP_DELETING, workflowId); err != nil {
-			log.Error("Failed to update appgroup status to 'APP_GROUP_DELETING'")
+	// Validation : check revisions
+	// The current revision and the custom values are the ones of the last install or upgrade.
+	currentRevision, valuesParameter := "", ""
+	last, err := operations.lastSucceeded(appGroupId, operationInstallAppGroup, operationUpgradeAppGroup)
+	if err != nil {
+		log.Error("Failed to get the last operation of the app group. err : ", err)
+	}
+	if last != nil {
+		currentRevision = last.parameter("revision")
+		if values := last.parameter(appGroupValuesParameter); values != "" {
+			valuesParameter = appGroupValuesParameter + "=" + values
 		}
 	}
+	if currentRevision == in.Revision {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The app group is already at revision %s", in.Revision),
+			},
+		}, fmt.Errorf("The app group is already at revision %s", in.Revision)
+	}
+
+	clusterId := appGroup.GetClusterId()
+	manifestRepoUrl := gitBaseUrl + "/" + gitAccount + "/" + clusterId + "-manifests"
+	opts := argowf.SubmitOptions{}
+	opts.Parameters = []string{
+		"site_name=" + clusterId,
+		"cluster_id=" + clusterId,
+		"github_account=" + gitAccount,
+		"manifest_repo_url=" + manifestRepoUrl,
+		"revision=" + in.Revision,
+		"current_revision=" + currentRevision,
+		"app_group_id=" + appGroupId,
+		"tks_info_host=tks-info.tks.svc",
+	}
+	opts.Parameters = append(opts.Parameters, t.installParameters()...)
+	if valuesParameter != "" {
+		opts.Parameters = append(opts.Parameters, valuesParameter)
+	}
+
+	log.Info("Submitting workflow: ", t.UpgradeTemplate)
+	workflowId, err := argowfClient.SumbitWorkflowFromWftpl(t.UpgradeTemplate, "argo", opts)
+	recordOperation(ctx, operationUpgradeAppGroup, appGroupId, workflowId, opts.Parameters, err)
+	if err != nil {
+		log.Error("failed to submit argo workflow template. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to call argo workflow : %s", err),
+			},
+		}, err
+	}
+	log.Debug("submited workflow name : ", workflowId)
+
+	// update status : APP_GROUP_INSTALLING
+	// AppGroupStatus has no dedicated upgrading state, so INSTALLING marks the app group busy until the workflow ends.
+	if err := s.updateAppGroupStatusWithWorkflowId(ctx, appGroupId, pb.AppGroupStatus_APP_GROUP_INSTALLING, workflowId); err != nil {
+		log.Error("Failed to update appgroup status to 'APP_GROUP_INSTALLING'")
+	}
 
-	return &pb.IDsResponse{
+	log.Info(fmt.Sprintf("Successfully initiated app group upgrade. appGroupId: %s, revision: %s -> %s", appGroupId, currentRevision, in.Revision))
+	return &pb.SimpleResponse{
 		Code:  pb.Code_OK_UNSPECIFIED,
 		Error: nil,
-		Ids:   resAppGroupIds,
 	}, nil
 }
 
-func (s *server) updateClusterStatusWithWorkflowId(ctx context.Context, clusterId string, status pb.ClusterStatus, workflowId string) error {
-	_, err := clusterInfoClient.UpdateClusterStatus(ctx, &pb.UpdateClusterStatusRequest{
-		ClusterId:  clusterId,
-		Status:     status,
-		WorkflowId: workflowId,
-	})
-	if err != nil {
-		log.Error("Failed to update cluster status err : ", err)
-		return err
-	}
+// UpgradeCluster upgrades the Kubernetes version of the cluster by one minor version at most
+func (s *server) UpgradeCluster(ctx context.Context, in *UpgradeClusterRequest) (*pb.SimpleResponse, error) {
+	log.Info("Request 'UpgradeCluster' for clusterId : ", in.ClusterId)
 
-	return nil
+	clusterId := in.ClusterId
+	if !helper.ValidateClusterId(clusterId) {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("invalid cluster ID %s", clusterId),
+			},
+		}, fmt.Errorf("invalid cluster ID %s", clusterId)
+	}
+	if _, err := parseKubernetesVersion(in.Version); err != nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+
+	// Validation : check cluster status
+	// The cluster status must be RUNNING.
+	res, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: clusterId})
+	if err != nil {
+		log.Error("Failed to get cluster info err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_NOT_FOUND,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Could not find Cluster with ID %s", clusterId),
+			},
+		}, err
+	}
+	if res.GetCluster().GetStatus() != pb.ClusterStatus_RUNNING {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The cluster can not be upgraded. cluster status : %s", res.GetCluster().GetStatus()),
+			},
+		}, fmt.Errorf("The cluster can not be upgraded. cluster status : %s", res.GetCluster().GetStatus())
+	}
+
+	// Validation : check versions
+	md, err := clusterMetadatas.get(clusterId)
+	if err != nil {
+		log.Error("Failed to get cluster metadata. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to get cluster metadata. err : %s", err),
+			},
+		}, err
+	}
+	if md.KubernetesVersion == "" {
+		return &pb.SimpleResponse{
+			Code: pb.Code_FAILED_PRECONDITION,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The kubernetes version of the cluster %s is unknown", clusterId),
+			},
+		}, fmt.Errorf("The kubernetes version of the cluster %s is unknown", clusterId)
+	}
+	if err := k8sVersions.validateUpgrade(md.KubernetesVersion, in.Version); err != nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+
+	provider, code, msg, err := clusterCspProvider(ctx, res.GetCluster())
+	if code != pb.Code_OK_UNSPECIFIED {
+		return &pb.SimpleResponse{
+			Code: code,
+			Error: &pb.Error{
+				Msg: msg,
+			},
+		}, err
+	}
+
+	nameSpace := "argo"
+	workflow := provider.UpgradeWorkflow()
+	manifestRepoUrl := gitBaseUrl + "/" + gitAccount + "/" + clusterId + "-manifests"
+
+	opts := argowf.SubmitOptions{}
+	opts.Parameters = []string{
+		"cluster_id=" + clusterId,
+		"site_name=" + clusterId,
+		"git_account=" + gitAccount,
+		"manifest_repo_url=" + manifestRepoUrl,
+		"revision=" + revision,
+		"tks_info_host=tks-info.tks.svc",
+		"current_kubernetes_version=" + md.KubernetesVersion,
+		"kubernetes_version=" + in.Version,
+	}
+
+	log.Info("Submitting workflow: ", workflow)
+	workflowId, err := argowfClient.SumbitWorkflowFromWftpl(workflow, nameSpace, opts)
+	recordOperation(ctx, operationUpgradeCluster, clusterId, workflowId, opts.Parameters, err)
+	if err != nil {
+		log.Error("failed to submit argo workflow template. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to call argo workflow : %s", err),
+			},
+		}, err
+	}
+	log.Debug("submited workflow name : ", workflowId)
+
+	// update status : INSTALLING
+	// ClusterStatus has no dedicated upgrading state, so INSTALLING marks the cluster busy until the workflow ends.
+	// The kubernetes version is updated by the reconciler when the workflow succeeds.
+	if err := s.updateClusterStatusWithWorkflowId(ctx, clusterId, pb.ClusterStatus_INSTALLING, workflowId); err != nil {
+		log.Error("Failed to update cluster status to 'INSTALLING'")
+	}
+
+	log.Info(fmt.Sprintf("Successfully initiated user-cluster upgrade. clusterId: %s, version: %s -> %s", clusterId, md.KubernetesVersion, in.Version))
+	return &pb.SimpleResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+	}, nil
+}
+
+// UpdateClusterCredentials replaces the kubeconfig of an imported cluster, eg. when certificates rotate.
+// The new kubeconfig is kept as a pending secret, and replaces the current one when the refresh workflow succeeds.
+func (s *server) UpdateClusterCredentials(ctx context.Context, in *UpdateClusterCredentialsRequest) (*pb.SimpleResponse, error) {
+	log.Info("Request 'UpdateClusterCredentials' for clusterId : ", in.ClusterId)
+
+	clusterId := in.ClusterId
+	if !helper.ValidateClusterId(clusterId) {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("invalid cluster ID %s", clusterId),
+			},
+		}, fmt.Errorf("invalid cluster ID %s", clusterId)
+	}
+	target, err := parseKubeconfig(in.Kubeconfig)
+	if err != nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+
+	// Validation : check cluster status
+	// The cluster status must be RUNNING.
+	res, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: clusterId})
+	if err != nil {
+		log.Error("Failed to get cluster info err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_NOT_FOUND,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Could not find Cluster with ID %s", clusterId),
+			},
+		}, err
+	}
+	if res.GetCluster().GetStatus() != pb.ClusterStatus_RUNNING {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The credentials of the cluster can not be updated. cluster status : %s", res.GetCluster().GetStatus()),
+			},
+		}, fmt.Errorf("The credentials of the cluster can not be updated. cluster status : %s", res.GetCluster().GetStatus())
+	}
+
+	// Validation : the new kubeconfig must point to the cluster of the current one
+	data, err := secrets.get(ctx, kubeconfigSecretName(clusterId))
+	if err != nil {
+		log.Error("Failed to get the kubeconfig secret. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_FAILED_PRECONDITION,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The cluster %s has no stored kubeconfig. only imported clusters have credentials", clusterId),
+			},
+		}, err
+	}
+	current, err := parseKubeconfig(data[kubeconfigSecretKey])
+	if err != nil {
+		log.Error("Failed to parse the current kubeconfig. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to parse the current kubeconfig : %s", err),
+			},
+		}, err
+	}
+	if err := target.sameCluster(current); err != nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+	if kubeconfigProbeTimeout > 0 {
+		if err := probeKubeconfig(ctx, target, kubeconfigProbeTimeout); err != nil {
+			log.Error("Failed to probe the API server of the kubeconfig. err : ", err)
+			return &pb.SimpleResponse{
+				Code: pb.Code_INVALID_ARGUMENT,
+				Error: &pb.Error{
+					Msg: fmt.Sprint(err),
+				},
+			}, err
+		}
+	}
+
+	provider, code, msg, err := clusterCspProvider(ctx, res.GetCluster())
+	if code != pb.Code_OK_UNSPECIFIED {
+		return &pb.SimpleResponse{
+			Code: code,
+			Error: &pb.Error{
+				Msg: msg,
+			},
+		}, err
+	}
+
+	secretName := pendingKubeconfigSecretName(clusterId)
+	if err := secrets.put(ctx, secretName, map[string][]byte{kubeconfigSecretKey: in.Kubeconfig}); err != nil {
+		log.Error("Failed to store the kubeconfig. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to store the kubeconfig : %s", err),
+			},
+		}, err
+	}
+
+	nameSpace := "argo"
+	workflow := provider.CredentialsWorkflow()
+
+	opts := argowf.SubmitOptions{}
+	opts.Parameters = []string{
+		"cluster_id=" + clusterId,
+		"kubeconfig_secret_name=" + secretName,
+		"revision=" + revision,
+	}
+
+	log.Info("Submitting workflow: ", workflow)
+	workflowId, err := argowfClient.SumbitWorkflowFromWftpl(workflow, nameSpace, opts)
+	recordOperation(ctx, operationUpdateCredentials, clusterId, workflowId, opts.Parameters, err)
+	if err != nil {
+		log.Error("failed to submit argo workflow template. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to call argo workflow : %s", err),
+			},
+		}, err
+	}
+	log.Debug("submited workflow name : ", workflowId)
+
+	// update status : INSTALLING
+	// The pending kubeconfig replaces the current one by the reconciler when the workflow succeeds.
+	if err := s.updateClusterStatusWithWorkflowId(ctx, clusterId, pb.ClusterStatus_INSTALLING, workflowId); err != nil {
+		log.Error("Failed to update cluster status to 'INSTALLING'")
+	}
+
+	log.Info("Successfully initiated credentials refresh. clusterId: ", clusterId)
+	return &pb.SimpleResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+	}, nil
+}
+
+// AddNodePool adds a named node pool to the cluster.
+func (s *server) AddNodePool(ctx context.Context, in *NodePoolRequest) (*pb.SimpleResponse, error) {
+	log.Info("Request 'AddNodePool' for clusterId : ", in.ClusterId)
+	if in.NodePool == nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: "node pool must not be empty",
+			},
+		}, fmt.Errorf("node pool must not be empty")
+	}
+	return s.applyNodePool(ctx, nodePoolActionAdd, in.ClusterId, in.NodePool.Name, in.NodePool)
+}
+
+// UpdateNodePool replaces the machine type, replicas, labels and taints of the named node pool.
+func (s *server) UpdateNodePool(ctx context.Context, in *NodePoolRequest) (*pb.SimpleResponse, error) {
+	log.Info("Request 'UpdateNodePool' for clusterId : ", in.ClusterId)
+	if in.NodePool == nil {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: "node pool must not be empty",
+			},
+		}, fmt.Errorf("node pool must not be empty")
+	}
+	return s.applyNodePool(ctx, nodePoolActionUpdate, in.ClusterId, in.NodePool.Name, in.NodePool)
+}
+
+// RemoveNodePool removes the named node pool from the cluster.
+func (s *server) RemoveNodePool(ctx context.Context, in *RemoveNodePoolRequest) (*pb.SimpleResponse, error) {
+	log.Info("Request 'RemoveNodePool' for clusterId : ", in.ClusterId)
+	return s.applyNodePool(ctx, nodePoolActionRemove, in.ClusterId, in.Name, nil)
+}
+
+// applyNodePool submits the workflow which applies the node pool change to the cluster.
+// The pool is nil for remove.
+func (s *server) applyNodePool(ctx context.Context, action string, clusterId string, name string, pool *NodePool) (*pb.SimpleResponse, error) {
+	if !helper.ValidateClusterId(clusterId) {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("invalid cluster ID %s", clusterId),
+			},
+		}, fmt.Errorf("invalid cluster ID %s", clusterId)
+	}
+
+	// Validation : check cluster status
+	// The cluster status must be RUNNING.
+	res, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: clusterId})
+	if err != nil {
+		log.Error("Failed to get cluster info err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_NOT_FOUND,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Could not find Cluster with ID %s", clusterId),
+			},
+		}, err
+	}
+	if res.GetCluster().GetStatus() != pb.ClusterStatus_RUNNING {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The node pools of the cluster can not be changed. cluster status : %s", res.GetCluster().GetStatus()),
+			},
+		}, fmt.Errorf("The node pools of the cluster can not be changed. cluster status : %s", res.GetCluster().GetStatus())
+	}
+
+	// Validation : check the pool against the existing pools
+	md, err := clusterMetadatas.get(clusterId)
+	if err != nil {
+		log.Error("Failed to get cluster metadata. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to get cluster metadata. err : %s", err),
+			},
+		}, err
+	}
+	exists := findNodePool(md.NodePools, name) >= 0
+	if action == nodePoolActionAdd && exists {
+		return &pb.SimpleResponse{
+			Code: pb.Code_ALREADY_EXISTS,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("node pool %s already exists", name),
+			},
+		}, fmt.Errorf("node pool %s already exists", name)
+	}
+	if action != nodePoolActionAdd && !exists {
+		return &pb.SimpleResponse{
+			Code: pb.Code_NOT_FOUND,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Could not find node pool %s", name),
+			},
+		}, fmt.Errorf("Could not find node pool %s", name)
+	}
+
+	provider, code, msg, err := clusterCspProvider(ctx, res.GetCluster())
+	if code != pb.Code_OK_UNSPECIFIED {
+		return &pb.SimpleResponse{
+			Code: code,
+			Error: &pb.Error{
+				Msg: msg,
+			},
+		}, err
+	}
+
+	if pool != nil {
+		conf := res.GetCluster().GetConf()
+		policy := autoscalingPolicies.policy(res.GetCluster().GetContractId())
+		if err := validateNodePool(provider, policy, conf.GetRegion(), int(conf.GetNumOfAz()), pool); err != nil {
+			return &pb.SimpleResponse{
+				Code: pb.Code_INVALID_ARGUMENT,
+				Error: &pb.Error{
+					Msg: fmt.Sprint(err),
+				},
+			}, err
+		}
+	}
+
+	nameSpace := "argo"
+	workflow := provider.NodePoolWorkflow()
+	manifestRepoUrl := gitBaseUrl + "/" + gitAccount + "/" + clusterId + "-manifests"
+
+	opts := argowf.SubmitOptions{}
+	opts.Parameters = []string{
+		"cluster_id=" + clusterId,
+		"site_name=" + clusterId,
+		"git_account=" + gitAccount,
+		"manifest_repo_url=" + manifestRepoUrl,
+		"revision=" + revision,
+		"tks_info_host=tks-info.tks.svc",
+		"action=" + action,
+		"node_pool_name=" + name,
+	}
+	if pool != nil {
+		poolJson, _ := json.Marshal(pool)
+		opts.Parameters = append(opts.Parameters, "node_pool="+string(poolJson))
+	}
+
+	log.Info("Submitting workflow: ", workflow)
+	workflowId, err := argowfClient.SumbitWorkflowFromWftpl(workflow, nameSpace, opts)
+	recordOperation(ctx, nodePoolOperations[action], clusterId, workflowId, opts.Parameters, err)
+	if err != nil {
+		log.Error("failed to submit argo workflow template. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to call argo workflow : %s", err),
+			},
+		}, err
+	}
+	log.Debug("submited workflow name : ", workflowId)
+
+	// update status : INSTALLING
+	// The node pools of the cluster metadata are updated by the reconciler when the workflow succeeds.
+	if err := s.updateClusterStatusWithWorkflowId(ctx, clusterId, pb.ClusterStatus_INSTALLING, workflowId); err != nil {
+		log.Error("Failed to update cluster status to 'INSTALLING'")
+	}
+
+	log.Info(fmt.Sprintf("Successfully initiated node pool change. clusterId: %s, action: %s, pool: %s", clusterId, action, name))
+	return &pb.SimpleResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+	}, nil
+}
+
+// RetryCluster resubmits the create or import workflow of the cluster in ERROR.
+// The cluster keeps its ID and manifests repository.
+func (s *server) RetryCluster(ctx context.Context, in *RetryClusterRequest) (*pb.SimpleResponse, error) {
+	log.Info("Request 'RetryCluster' for clusterId : ", in.ClusterId)
+
+	clusterId := in.ClusterId
+	if !helper.ValidateClusterId(clusterId) {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("invalid cluster ID %s", clusterId),
+			},
+		}, fmt.Errorf("invalid cluster ID %s", clusterId)
+	}
+
+	// Validation : check cluster status
+	// The cluster status must be ERROR.
+	res, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: clusterId})
+	if err != nil {
+		log.Error("Failed to get cluster info err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_NOT_FOUND,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Could not find Cluster with ID %s", clusterId),
+			},
+		}, err
+	}
+	if res.GetCluster().GetStatus() != pb.ClusterStatus_ERROR {
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The cluster can not be retried. cluster status : %s", res.GetCluster().GetStatus()),
+			},
+		}, fmt.Errorf("The cluster can not be retried. cluster status : %s", res.GetCluster().GetStatus())
+	}
+
+	// Validation : the latest operation of the cluster must be its failed creation
+	op, err := operations.last(clusterId, clusterOperationTypes...)
+	if err != nil {
+		log.Error("Failed to get operations. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to get operations. err : %s", err),
+			},
+		}, err
+	}
+	if op == nil || op.Phase != operationPhaseFailed ||
+		(op.Type != operationCreateCluster && op.Type != operationImportCluster) {
+		return &pb.SimpleResponse{
+			Code: pb.Code_FAILED_PRECONDITION,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("No failed creation is recorded for the cluster %s", clusterId),
+			},
+		}, fmt.Errorf("No failed creation is recorded for the cluster %s", clusterId)
+	}
+
+	workflowId := ""
+	var submitErr error
+	if in.FromFailedStep {
+		if op.WorkflowId == "" {
+			return &pb.SimpleResponse{
+				Code: pb.Code_FAILED_PRECONDITION,
+				Error: &pb.Error{
+					Msg: "The workflow was never started. retry without FromFailedStep",
+				},
+			}, errors.New("The workflow was never started. retry without FromFailedStep")
+		}
+
+		log.Info("Retrying workflow: ", op.WorkflowId)
+		workflowId = op.WorkflowId
+		submitErr = argowfController.RetryWorkflow("argo", workflowId)
+	} else {
+		workflow, code, msg, err := s.retryWorkflow(ctx, op, res.GetCluster())
+		if code != pb.Code_OK_UNSPECIFIED {
+			return &pb.SimpleResponse{
+				Code: code,
+				Error: &pb.Error{
+					Msg: msg,
+				},
+			}, err
+		}
+
+		opts := argowf.SubmitOptions{}
+		opts.Parameters = op.Parameters
+
+		log.Info("Submitting workflow: ", workflow)
+		workflowId, submitErr = argowfClient.SumbitWorkflowFromWftpl(workflow, "argo", opts)
+	}
+	recordOperation(ctx, op.Type, clusterId, workflowId, op.Parameters, submitErr)
+	if submitErr != nil {
+		log.Error("failed to retry argo workflow. err : ", submitErr)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to call argo workflow : %s", submitErr),
+			},
+		}, submitErr
+	}
+	log.Debug("submited workflow name : ", workflowId)
+
+	// update status : INSTALLING
+	if err := s.updateClusterStatusWithWorkflowId(ctx, clusterId, pb.ClusterStatus_INSTALLING, workflowId); err != nil {
+		log.Error("Failed to update cluster status to 'INSTALLING'")
+	}
+
+	log.Info("Successfully retried user-cluster creation. clusterId: ", clusterId)
+	return &pb.SimpleResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+	}, nil
+}
+
+// retryWorkflow returns the workflow which starts the failed creation of the cluster over.
+// An imported cluster can be retried as long as its kubeconfig is in the secret store.
+func (s *server) retryWorkflow(ctx context.Context, op *Operation, cluster *pb.Cluster) (workflow string, code pb.Code, msg string, err error) {
+	if op.Type == operationImportCluster {
+		if _, err := secrets.get(ctx, kubeconfigSecretName(cluster.GetId())); err != nil {
+			log.Error("Failed to get the kubeconfig secret. err : ", err)
+			return "", pb.Code_FAILED_PRECONDITION, "The kubeconfig of the imported cluster is not stored. retry from the failed step", err
+		}
+		return "import-tks-usercluster", pb.Code_OK_UNSPECIFIED, "", nil
+	}
+
+	provider, code, msg, err := clusterCspProvider(ctx, cluster)
+	if code != pb.Code_OK_UNSPECIFIED {
+		return "", code, msg, err
+	}
+	return provider.CreateWorkflow(), pb.Code_OK_UNSPECIFIED, "", nil
+}
+
+// CancelOperation terminates the workflow in progress for the cluster or the app group.
+// An operation whose workflow is not submitted yet is ended before the submission.
+// The cluster or the app group becomes ERROR, since there is no cancelled status.
+func (s *server) CancelOperation(ctx context.Context, in *pb.IDRequest) (*pb.SimpleResponse, error) {
+	log.Info("Request 'CancelOperation' for id : ", in.GetId())
+
+	id := in.GetId()
+	workflowId := ""
+	pending := pendingOperation(id)
+	switch {
+	case helper.ValidateClusterId(id):
+		res, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: id})
+		if err != nil {
+			log.Error("Failed to get cluster info err : ", err)
+			return &pb.SimpleResponse{
+				Code: pb.Code_NOT_FOUND,
+				Error: &pb.Error{
+					Msg: fmt.Sprintf("Could not find Cluster with ID %s", id),
+				},
+			}, err
+		}
+		status := res.GetCluster().GetStatus()
+		workflowId = res.GetCluster().GetWorkflowId()
+		if (status != pb.ClusterStatus_INSTALLING && status != pb.ClusterStatus_DELETING) || (workflowId == "" && pending == nil) {
+			return &pb.SimpleResponse{
+				Code: pb.Code_FAILED_PRECONDITION,
+				Error: &pb.Error{
+					Msg: fmt.Sprintf("The cluster has no operation in progress. cluster status : %s", status),
+				},
+			}, fmt.Errorf("The cluster has no operation in progress. cluster status : %s", status)
+		}
+	case helper.ValidateApplicationGroupId(id):
+		res, err := appInfoClient.GetAppGroup(ctx, &pb.GetAppGroupRequest{AppGroupId: id})
+		if err != nil {
+			log.Error("Failed to get app group info err : ", err)
+			return &pb.SimpleResponse{
+				Code: pb.Code_NOT_FOUND,
+				Error: &pb.Error{
+					Msg: fmt.Sprintf("Could not find AppGroup with ID %s", id),
+				},
+			}, err
+		}
+		status := res.GetAppGroup().GetStatus()
+		workflowId = res.GetAppGroup().GetWorkflowId()
+		if (status != pb.AppGroupStatus_APP_GROUP_INSTALLING && status != pb.AppGroupStatus_APP_GROUP_DELETING) || (workflowId == "" && pending == nil) {
+			return &pb.SimpleResponse{
+				Code: pb.Code_FAILED_PRECONDITION,
+				Error: &pb.Error{
+					Msg: fmt.Sprintf("The appgroup has no operation in progress. appgroup status : %s", status),
+				},
+			}, fmt.Errorf("The appgroup has no operation in progress. appgroup status : %s", status)
+		}
+	default:
+		return &pb.SimpleResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("invalid cluster or appgroup ID %s", id),
+			},
+		}, fmt.Errorf("invalid cluster or appgroup ID %s", id)
+	}
+
+	statusDesc := "Cancelled by request before the workflow was submitted"
+	if workflowId != "" {
+		if err := argowfController.TerminateWorkflow("argo", workflowId); err != nil {
+			log.Error("Failed to terminate workflow. err : ", err)
+			return &pb.SimpleResponse{
+				Code: pb.Code_INTERNAL,
+				Error: &pb.Error{
+					Msg: fmt.Sprintf("Failed to terminate workflow %s : %s", workflowId, err),
+				},
+			}, err
+		}
+		log.Info("Terminated workflow : ", workflowId)
+		statusDesc = fmt.Sprintf("Cancelled by request. workflow %s was terminated", workflowId)
+	} else {
+		// The reconciler does not submit the workflow of an ended operation.
+		pending.Pending = nil
+		if err := operations.finish(pending, operationPhaseCancelled, statusDesc); err != nil {
+			log.Error("Failed to update operation. err : ", err)
+			return &pb.SimpleResponse{
+				Code: pb.Code_INTERNAL,
+				Error: &pb.Error{
+					Msg: fmt.Sprintf("Failed to cancel operation %s : %s", pending.OperationId, err),
+				},
+			}, err
+		}
+		log.Info("Cancelled pending operation : ", pending.OperationId)
+	}
+
+	var err error
+	if helper.ValidateClusterId(id) {
+		_, err = clusterInfoClient.UpdateClusterStatus(ctx, &pb.UpdateClusterStatusRequest{
+			ClusterId:  id,
+			Status:     pb.ClusterStatus_ERROR,
+			StatusDesc: statusDesc,
+			WorkflowId: workflowId,
+		})
+	} else {
+		_, err = appInfoClient.UpdateAppGroupStatus(ctx, &pb.UpdateAppGroupStatusRequest{
+			AppGroupId: id,
+			Status:     pb.AppGroupStatus_APP_GROUP_ERROR,
+			StatusDesc: statusDesc,
+			WorkflowId: workflowId,
+		})
+	}
+	if err != nil {
+		log.Error("Failed to update status to ERROR. err : ", err)
+		return &pb.SimpleResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("The operation was cancelled, but failed to update status. err : %s", err),
+			},
+		}, err
+	}
+	if workflowId != "" {
+		operations.finishByWorkflowId(workflowId, operationPhaseCancelled, statusDesc)
+	}
+
+	log.Info("Successfully cancelled the operation. id : ", id)
+	return &pb.SimpleResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+	}, nil
+}
+
+// GetOperation returns the operation record
+func (s *server) GetOperation(ctx context.Context, in *pb.IDRequest) (*GetOperationResponse, error) {
+	log.Debug("Request 'GetOperation' for operationId : ", in.GetId())
+
+	if _, err := uuid.Parse(in.GetId()); err != nil {
+		return &GetOperationResponse{
+			Code: pb.Code_INVALID_ARGUMENT,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("invalid operation ID %s", in.GetId()),
+			},
+		}, fmt.Errorf("invalid operation ID %s", in.GetId())
+	}
+
+	op, err := operations.get(in.GetId())
+	if err != nil {
+		log.Error("Failed to get operation. err : ", err)
+		return &GetOperationResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to get operation. err : %s", err),
+			},
+		}, err
+	}
+	if op == nil {
+		return &GetOperationResponse{
+			Code: pb.Code_NOT_FOUND,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Could not find operation with ID %s", in.GetId()),
+			},
+		}, fmt.Errorf("Could not find operation with ID %s", in.GetId())
+	}
+
+	return &GetOperationResponse{
+		Code:      pb.Code_OK_UNSPECIFIED,
+		Error:     nil,
+		Operation: op,
+	}, nil
+}
+
+// ListOperations returns the operation records ordered by start time
+func (s *server) ListOperations(ctx context.Context, in *ListOperationsRequest) (*ListOperationsResponse, error) {
+	log.Debug("Request 'ListOperations' for targetId : ", in.TargetId)
+
+	filter := func(op *Operation) bool {
+		return in.Type == "" || op.Type == in.Type
+	}
+	var ops []*Operation
+	var err error
+	if in.TargetId != "" {
+		ops = operations.listTarget(in.TargetId, filter)
+	} else {
+		ops, err = operations.list(filter)
+	}
+	if err != nil {
+		log.Error("Failed to list operations. err : ", err)
+		return &ListOperationsResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprintf("Failed to list operations. err : %s", err),
+			},
+		}, err
+	}
+
+	return &ListOperationsResponse{
+		Code:       pb.Code_OK_UNSPECIFIED,
+		Error:      nil,
+		Operations: ops,
+	}, nil
+}
+
+func (s *server) updateClusterStatusWithWorkflowId(ctx context.Context, clusterId string, status pb.ClusterStatus, workflowId string) error {
+	_, err := clusterInfoClient.UpdateClusterStatus(ctx, &pb.UpdateClusterStatusRequest{
+		ClusterId:  clusterId,
+		Status:     status,
+		WorkflowId: workflowId,
+	})
+	if err != nil {
+		log.Error("Failed to update cluster status err : ", err)
+		return err
+	}
+
+	return nil
+}
+
+// compensateClusterInfo marks the cluster added in tks-info as ERROR when its workflow could not be started.
+// tks-info has no API to remove cluster info, so the record is kept with the reason.
+func (s *server) compensateClusterInfo(ctx context.Context, clusterId string, reason string) {
+	if _, err := clusterInfoClient.UpdateClusterStatus(ctx, &pb.UpdateClusterStatusRequest{
+		ClusterId:  clusterId,
+		Status:     pb.ClusterStatus_ERROR,
+		StatusDesc: reason,
+	}); err != nil {
+		log.Error("Failed to compensate cluster info. it will be swept by the reconciler. err : ", err)
+	}
 }
 
 func (s *server) updateAppGroupStatusWithWorkflowId(ctx context.Context, appGroupId string, status pb.AppGroupStatus, workflowId string) error {
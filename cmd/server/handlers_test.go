@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
 
+	"github.com/openinfradev/tks-common/pkg/argowf"
 	mockargo "github.com/openinfradev/tks-common/pkg/argowf/mock"
 	"github.com/openinfradev/tks-common/pkg/helper"
 	"github.com/openinfradev/tks-common/pkg/log"
@@ -27,21 +32,81 @@ var (
 	createdAppGroupId = helper.GenerateApplicaionGroupId()
 )
 
-func init() {
+func TestMain(m *testing.M) {
 	log.Disable()
 
 	// override for test
-	filePathAzRegion = "../../files/az-per-region.txt"
+	// The catalogs are fixtures of testdata, so that editing the shipped ones in files does not break the tests.
+	var err error
+	if cspRegions, err = newRegionCatalog("testdata/region-catalog.json"); err != nil {
+		panic(err)
+	}
+	if instanceTypes, err = newInstanceCatalog("testdata/instance-types.json"); err != nil {
+		panic(err)
+	}
+	if k8sVersions, err = newVersionMatrix("testdata/k8s-versions.json"); err != nil {
+		panic(err)
+	}
+	if autoscalingPolicies, err = newAutoscalingPolicyCatalog("testdata/autoscaling-policies.json"); err != nil {
+		panic(err)
+	}
+	if appGroupTypes, err = newAppGroupTypeRegistry("testdata/app-group-types.json"); err != nil {
+		panic(err)
+	}
+	storeDir, err := ioutil.TempDir("", "tks-cluster-lcm-test")
+	if err != nil {
+		panic(err)
+	}
+	store, err := newFileStore(storeDir)
+	if err != nil {
+		panic(err)
+	}
+	if operations, err = newOperationStore(store, 0); err != nil {
+		panic(err)
+	}
+	idempotencyKeys = newIdempotencyStore(store, time.Hour)
+	clusterMetadatas = newClusterMetadataStore(store)
+	secrets = newFileSecretStore(store)
 
 	// for CreateCluster API
 	installAppGroupsRequest = randomInstallAppGroupsRequest()
 	createClusterRequest = randomCreateClusterRequest()
+
+	code := m.Run()
+	os.RemoveAll(storeDir)
+	os.Exit(code)
+}
+
+// TestShippedFiles checks that the catalogs shipped in files are valid.
+func TestShippedFiles(t *testing.T) {
+	_, err := newRegionCatalog("../../files/region-catalog.json")
+	require.NoError(t, err)
+	_, err = newInstanceCatalog("../../files/instance-types.json")
+	require.NoError(t, err)
+	_, err = newVersionMatrix("../../files/k8s-versions.json")
+	require.NoError(t, err)
+	_, err = newAutoscalingPolicyCatalog("../../files/autoscaling-policies.json")
+	require.NoError(t, err)
+	_, err = newAppGroupTypeRegistry("../../files/app-group-types.json")
+	require.NoError(t, err)
 }
 
 func TestCreateCluster(t *testing.T) {
 	requestEmptyContractId := randomCreateClusterRequest()
 	requestEmptyContractId.ContractId = ""
 	requestEmptyContractId.CspId = ""
+	defaultContractId := helper.GenerateContractId()
+
+	requestAzure := randomCreateClusterRequest()
+	requestAzure.Conf.Region = "koreacentral"
+	requestAzure.Conf.MachineType = "Standard_D4s_v3"
+
+	requestUnavailableMachineType := randomCreateClusterRequest()
+	requestUnavailableMachineType.Conf.MachineType = "m6g.large"
+	requestUnavailableMachineType.Conf.Region = "ap-south-1"
+
+	requestUnknownMachineType := randomCreateClusterRequest()
+	requestUnknownMachineType.Conf.MachineType = "t3.lrage"
 
 	testCases := []struct {
 		name       string
@@ -96,6 +161,10 @@ func TestCreateCluster(t *testing.T) {
 			checkResponse: func(req *pb.CreateClusterRequest, res *pb.IDResponse, err error) {
 				require.NoError(t, err)
 				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
+
+				md, err := clusterMetadatas.get(res.Id)
+				require.NoError(t, err)
+				require.Equal(t, k8sVersions.defaults(), md.KubernetesVersion)
 			},
 		},
 		{
@@ -112,7 +181,7 @@ func TestCreateCluster(t *testing.T) {
 							Code:  pb.Code_OK_UNSPECIFIED,
 							Error: nil,
 							Contract: &pb.Contract{
-								ContractId: helper.GenerateContractId(),
+								ContractId: defaultContractId,
 								CspId:      uuid.New().String(),
 							},
 						}, nil)
@@ -125,6 +194,15 @@ func TestCreateCluster(t *testing.T) {
 							Ids:   []string{helper.GenerateApplicaionGroupId()},
 						}, nil)
 
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetCSPInfoResponse{
+							Code:       pb.Code_OK_UNSPECIFIED,
+							Error:      nil,
+							ContractId: defaultContractId,
+							CspType:    pb.CspType_AWS,
+						}, nil)
+
 				mockClusterInfoClient.EXPECT().AddClusterInfo(gomock.Any(), gomock.Any()).Times(1).
 					Return(
 						&pb.IDResponse{
@@ -148,6 +226,140 @@ func TestCreateCluster(t *testing.T) {
 				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
 			},
 		},
+		{
+			name: "OK_AZURE",
+			in:   requestAzure,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockContractClient *mocktks.MockContractServiceClient) {
+
+				mockContractClient.EXPECT().GetContract(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetContractResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetCSPInfoResponse{
+							Code:       pb.Code_OK_UNSPECIFIED,
+							Error:      nil,
+							ContractId: requestAzure.ContractId,
+							CspType:    pb.CspType_AZURE,
+						}, nil)
+
+				mockClusterInfoClient.EXPECT().AddClusterInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDResponse{Code: pb.Code_OK_UNSPECIFIED, Id: createdClusterId}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("create-tks-azure-usercluster", gomock.Any(), gomock.Any()).Times(1).
+					Return(randomString("workflowName"), nil)
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+			checkResponse: func(req *pb.CreateClusterRequest, res *pb.IDResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
+			},
+		},
+		{
+			name: "UNKNOWN_REGION_FOR_CSP",
+			in:   requestAzure,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockContractClient *mocktks.MockContractServiceClient) {
+
+				mockContractClient.EXPECT().GetContract(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetContractResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetCSPInfoResponse{
+							Code:       pb.Code_OK_UNSPECIFIED,
+							Error:      nil,
+							ContractId: requestAzure.ContractId,
+							CspType:    pb.CspType_GCP,
+						}, nil)
+			},
+			checkResponse: func(req *pb.CreateClusterRequest, res *pb.IDResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
+		{
+			name: "MACHINE_TYPE_NOT_AVAILABLE_IN_REGION",
+			in:   requestUnavailableMachineType,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockContractClient *mocktks.MockContractServiceClient) {
+
+				mockContractClient.EXPECT().GetContract(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetContractResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetCSPInfoResponse{
+							Code:       pb.Code_OK_UNSPECIFIED,
+							Error:      nil,
+							ContractId: requestUnavailableMachineType.ContractId,
+						}, nil)
+			},
+			checkResponse: func(req *pb.CreateClusterRequest, res *pb.IDResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
+		{
+			name: "UNKNOWN_MACHINE_TYPE",
+			in:   requestUnknownMachineType,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockContractClient *mocktks.MockContractServiceClient) {
+
+				mockContractClient.EXPECT().GetContract(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetContractResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetCSPInfoResponse{
+							Code:       pb.Code_OK_UNSPECIFIED,
+							Error:      nil,
+							ContractId: requestUnknownMachineType.ContractId,
+						}, nil)
+			},
+			checkResponse: func(req *pb.CreateClusterRequest, res *pb.IDResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
+		{
+			name: "CSP_ID_REQUIRED_FOR_MULTIPLE_CSPS",
+			in:   requestEmptyContractId,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockContractClient *mocktks.MockContractServiceClient) {
+
+				mockContractClient.EXPECT().GetDefaultContract(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetContractResponse{
+							Code:     pb.Code_OK_UNSPECIFIED,
+							Contract: &pb.Contract{ContractId: defaultContractId},
+						}, nil)
+
+				mockCspInfoClient.EXPECT().GetCSPIDsByContractID(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.IDsResponse{
+							Code: pb.Code_OK_UNSPECIFIED,
+							Ids:  []string{uuid.New().String(), uuid.New().String()},
+						}, nil)
+			},
+			checkResponse: func(req *pb.CreateClusterRequest, res *pb.IDResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
 		{
 			name: "NO_DEFAULT_CONTRACT",
 			in:   requestEmptyContractId,
@@ -262,7 +474,7 @@ func TestCreateCluster(t *testing.T) {
 						}, nil)
 			},
 			checkResponse: func(req *pb.CreateClusterRequest, res *pb.IDResponse, err error) {
-				require.NoError(t, err)
+				require.Error(t, err)
 				require.Equal(t, res.Code, pb.Code_NOT_FOUND)
 			},
 		},
@@ -332,6 +544,14 @@ func TestCreateCluster(t *testing.T) {
 
 				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
 					Return("", errors.New("FAILED_TO_CALL_WORKFLOW"))
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, createdClusterId, in.GetClusterId())
+						require.Equal(t, pb.ClusterStatus_ERROR, in.GetStatus())
+						require.Contains(t, in.GetStatusDesc(), "FAILED_TO_CALL_WORKFLOW")
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
 			},
 			checkResponse: func(req *pb.CreateClusterRequest, res *pb.IDResponse, err error) {
 				require.Error(t, err)
@@ -370,188 +590,312 @@ func TestCreateCluster(t *testing.T) {
 	}
 }
 
-func TestDeleteCluster(t *testing.T) {
+func TestPlanCluster(t *testing.T) {
+	req := randomCreateClusterRequest()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// No cluster info is added and no workflow is submitted.
+	mockArgoClient := mockargo.NewMockClient(ctrl)
+	argowfClient = mockArgoClient
+	mockContractClient := mocktks.NewMockContractServiceClient(ctrl)
+	contractClient = mockContractClient
+	mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+	cspInfoClient = mockCspInfoClient
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+
+	mockContractClient.EXPECT().GetContract(gomock.Any(), gomock.Any()).Times(4).
+		Return(&pb.GetContractResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+	mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(4).
+		Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, ContractId: req.ContractId, CspType: pb.CspType_AWS}, nil)
+
+	s := server{}
+	res, err := s.PlanCluster(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+
+	plan := res.Plan
+	require.Equal(t, req.ContractId, plan.ContractId)
+	require.Equal(t, req.CspId, plan.CspId)
+	require.Equal(t, "aws", plan.CspType)
+	require.Equal(t, "create-tks-usercluster", plan.Workflow)
+	require.Equal(t, int32(1), plan.Conf.GetMinSizePerAz())
+	require.Equal(t, int32(5), plan.Conf.GetMaxSizePerAz())
+	require.Equal(t, k8sVersions.defaults(), plan.KubernetesVersion)
+	require.Contains(t, plan.Parameters, "manifest_repo_url="+gitBaseUrl+"/"+gitAccount+"/<cluster_id>-manifests")
+	require.Contains(t, plan.parameters(createdClusterId), "cluster_id="+createdClusterId)
+
+	// Replicas beyond the ceiling are clamped, not rejected.
+	clamped := randomCreateClusterRequest()
+	clamped.ContractId = req.ContractId
+	clamped.Conf.NumOfAz = 1
+	clamped.Conf.MachineReplicas = int32(autoscalingPolicies.policy(req.ContractId).MaxSizePerAz + 1)
+	res, err = s.PlanCluster(context.Background(), clamped)
+	require.NoError(t, err)
+	require.Equal(t, clamped.Conf.MachineReplicas-1, res.Plan.Conf.GetMinSizePerAz())
+	require.Equal(t, clamped.Conf.MachineReplicas-1, res.Plan.Conf.GetMaxSizePerAz())
+
+	invalid := randomCreateClusterRequest()
+	invalid.ContractId = req.ContractId
+	invalid.Conf.MachineType = "t3.lrage"
+	res, err = s.PlanCluster(context.Background(), invalid)
+	require.Error(t, err)
+	require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+
+	// The CSP belongs to another contract.
+	mismatched := randomCreateClusterRequest()
+	res, err = s.PlanCluster(context.Background(), mismatched)
+	require.Error(t, err)
+	require.Equal(t, pb.Code_NOT_FOUND, res.Code)
+}
+
+func TestPlanImportCluster(t *testing.T) {
+	contractId := helper.GenerateContractId()
+	cspId := uuid.New().String()
+	otherCspId := uuid.New().String()
+
 	testCases := []struct {
-		name          string
-		in            *pb.IDRequest
-		buildStubs    func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient)
-		checkResponse func(req *pb.IDRequest, res *pb.SimpleResponse, err error)
+		name       string
+		header     string
+		buildStubs func(mockCspInfoClient *mocktks.MockCspInfoServiceClient)
+		expected   pb.Code
+		cspId      string
+		cspType    string
 	}{
 		{
 			name: "OK",
-			in: &pb.IDRequest{
-				Id: createdClusterId,
+			buildStubs: func(mockCspInfoClient *mocktks.MockCspInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDsByContractID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId}}, nil)
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), &pb.IDRequest{Id: cspId}).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, ContractId: contractId, CspType: pb.CspType_GCP}, nil)
 			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient,
-				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
-				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			expected: pb.Code_OK_UNSPECIFIED,
+			cspId:    cspId,
+			cspType:  "gcp",
+		},
+		{
+			name:   "OK_CSP_HEADER",
+			header: otherCspId,
+			buildStubs: func(mockCspInfoClient *mocktks.MockCspInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), &pb.IDRequest{Id: otherCspId}).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, ContractId: contractId, CspType: pb.CspType_AZURE}, nil)
+			},
+			expected: pb.Code_OK_UNSPECIFIED,
+			cspId:    otherCspId,
+			cspType:  "azure",
+		},
+		{
+			name: "CSP_NOT_SPECIFIED",
+			buildStubs: func(mockCspInfoClient *mocktks.MockCspInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDsByContractID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId, otherCspId}}, nil)
+			},
+			expected: pb.Code_INVALID_ARGUMENT,
+		},
+		{
+			name:   "CSP_OF_OTHER_CONTRACT",
+			header: otherCspId,
+			buildStubs: func(mockCspInfoClient *mocktks.MockCspInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, ContractId: helper.GenerateContractId()}, nil)
+			},
+			expected: pb.Code_NOT_FOUND,
+		},
+		{
+			name:       "INVALID_CSP_HEADER",
+			header:     "invalid",
+			buildStubs: func(mockCspInfoClient *mocktks.MockCspInfoServiceClient) {},
+			expected:   pb.Code_INVALID_ARGUMENT,
+		},
+	}
 
-				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.GetClusterResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-							Cluster: &pb.Cluster{
-								Status: pb.ClusterStatus_RUNNING,
-							},
-						}, nil)
+	for i := range testCases {
+		tc := testCases[i]
 
-				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.GetAppGroupsResponse{
-							Code:      pb.Code_OK_UNSPECIFIED,
-							Error:     nil,
-							AppGroups: []*pb.AppGroup{},
-						}, nil)
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
 
-				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.SimpleResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-						}, nil)
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
+			tc.buildStubs(mockCspInfoClient)
 
-				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+			ctx := context.Background()
+			if tc.header != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(cspIdHeader, tc.header))
+			}
+
+			s := server{}
+			res, err := s.PlanImportCluster(ctx, &pb.ImportClusterRequest{
+				ContractId: contractId,
+				Name:       randomString("NAME"),
+				Kubeconfig: testKubeconfig("https://10.0.0.1:6443", randomString("TOKEN")),
+			})
+			require.Equal(t, tc.expected, res.Code)
+			if tc.expected != pb.Code_OK_UNSPECIFIED {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.cspId, res.Plan.CspId)
+			require.Equal(t, tc.cspType, res.Plan.CspType)
+			require.Equal(t, "import-tks-usercluster", res.Plan.Workflow)
+			for _, param := range res.Plan.Parameters {
+				require.NotContains(t, param, "kubeconfig=")
+			}
+		})
+	}
+
+	s := server{}
+	res, err := s.PlanImportCluster(context.Background(), &pb.ImportClusterRequest{
+		ContractId: contractId,
+		Name:       randomString("NAME"),
+		Kubeconfig: []byte(randomString("KUBECONFIG")),
+	})
+	require.Error(t, err)
+	require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+}
+
+func TestScaleCluster(t *testing.T) {
+	runningCluster := &pb.GetClusterResponse{
+		Code:  pb.Code_OK_UNSPECIFIED,
+		Error: nil,
+		Cluster: &pb.Cluster{
+			Id:     createdClusterId,
+			Status: pb.ClusterStatus_RUNNING,
+			Conf: &pb.ClusterConf{
+				Region:       "ap-northeast-2",
+				NumOfAz:      3,
+				MachineType:  "t3.large",
+				MinSizePerAz: 1,
+				MaxSizePerAz: 5,
+			},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		in            *pb.ScaleClusterRequest
+		buildStubs    func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient)
+		checkResponse func(req *pb.ScaleClusterRequest, res *pb.SimpleResponse, err error)
+	}{
+		{
+			name: "OK",
+			in: &pb.ScaleClusterRequest{
+				ClusterId:      createdClusterId,
+				WorkerReplicas: 6,
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(runningCluster, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-scale-usercluster", gomock.Any(), gomock.Any()).Times(1).
 					Return(randomString("workflowName"), nil)
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
 			},
-			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+			checkResponse: func(req *pb.ScaleClusterRequest, res *pb.SimpleResponse, err error) {
 				require.NoError(t, err)
 				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
 			},
 		},
 		{
 			name: "INVALID_ARGUMENT_CLUSTER_ID",
-			in: &pb.IDRequest{
-				Id: "THIS_IS_NOT_UUID",
+			in: &pb.ScaleClusterRequest{
+				ClusterId:      "THIS_IS_NOT_UUID",
+				WorkerReplicas: 3,
 			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
 			},
-			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+			checkResponse: func(req *pb.ScaleClusterRequest, res *pb.SimpleResponse, err error) {
 				require.Error(t, err)
 				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
 			},
 		},
 		{
-			name: "NOT_EXIST_CLUSTER",
-			in: &pb.IDRequest{
-				Id: helper.GenerateClusterId(),
+			name: "INVALID_ARGUMENT_NO_REPLICAS",
+			in: &pb.ScaleClusterRequest{
+				ClusterId: createdClusterId,
 			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
-				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.GetClusterResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-						}, errors.New("NOT_EXISTED_CLUSTER"))
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
 			},
-			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+			checkResponse: func(req *pb.ScaleClusterRequest, res *pb.SimpleResponse, err error) {
 				require.Error(t, err)
-				require.Equal(t, res.Code, pb.Code_NOT_FOUND)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
 			},
 		},
 		{
-			name: "THE_CLUSTER_ALREADY_DELETED",
-			in: &pb.IDRequest{
-				Id: helper.GenerateClusterId(),
+			name: "CLUSTER_STATUS_IS_NOT_RUNNING",
+			in: &pb.ScaleClusterRequest{
+				ClusterId:      createdClusterId,
+				WorkerReplicas: 6,
 			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
 				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
 					Return(
 						&pb.GetClusterResponse{
 							Code:  pb.Code_OK_UNSPECIFIED,
 							Error: nil,
 							Cluster: &pb.Cluster{
-								Status: pb.ClusterStatus_DELETED,
+								Status: pb.ClusterStatus_INSTALLING,
 							},
 						}, nil)
 			},
-			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+			checkResponse: func(req *pb.ScaleClusterRequest, res *pb.SimpleResponse, err error) {
 				require.Error(t, err)
 				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
 			},
 		},
 		{
-			name: "FAILED_TO_CALL_WORKFLOW",
-			in: &pb.IDRequest{
-				Id: helper.GenerateClusterId(),
+			name: "REPLICAS_NOT_MULTIPLE_OF_AZ",
+			in: &pb.ScaleClusterRequest{
+				ClusterId:      createdClusterId,
+				WorkerReplicas: 4,
 			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
 				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.GetClusterResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-							Cluster: &pb.Cluster{
-								Status: pb.ClusterStatus_RUNNING,
-							},
-						}, nil)
-				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.GetAppGroupsResponse{
-							Code:      pb.Code_OK_UNSPECIFIED,
-							Error:     nil,
-							AppGroups: []*pb.AppGroup{},
-						}, nil)
-				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
-					Return(randomString("workflowName"), errors.New("FAILED_TO_CALL_WORKFLOW"))
+					Return(runningCluster, nil)
 			},
-			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+			checkResponse: func(req *pb.ScaleClusterRequest, res *pb.SimpleResponse, err error) {
 				require.Error(t, err)
-				require.Equal(t, res.Code, pb.Code_INTERNAL)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
 			},
 		},
 		{
-			name: "CLUSTER_STATUS_IS_NOT_RUNNING",
-			in: &pb.IDRequest{
-				Id: helper.GenerateClusterId(),
+			name: "EXCEEDED_MAX_SIZE_PER_AZ",
+			in: &pb.ScaleClusterRequest{
+				ClusterId:      createdClusterId,
+				WorkerReplicas: int32(autoscalingPolicies.policy("").MaxSizePerAz+1) * 3,
 			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
 				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.GetClusterResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-							Cluster: &pb.Cluster{
-								Status: pb.ClusterStatus_INSTALLING,
-							},
-						}, nil)
+					Return(runningCluster, nil)
 			},
-			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+			checkResponse: func(req *pb.ScaleClusterRequest, res *pb.SimpleResponse, err error) {
 				require.Error(t, err)
 				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
 			},
 		},
 		{
-			name: "APPGROUP_STATUS_IS_NOT_DELETED",
-			in: &pb.IDRequest{
-				Id: helper.GenerateClusterId(),
+			name: "FAILED_TO_CALL_WORKFLOW",
+			in: &pb.ScaleClusterRequest{
+				ClusterId:      createdClusterId,
+				WorkerReplicas: 6,
 			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
 				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.GetClusterResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-							Cluster: &pb.Cluster{
-								Status: pb.ClusterStatus_RUNNING,
-							},
-						}, nil)
+					Return(runningCluster, nil)
 
-				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.GetAppGroupsResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-							AppGroups: []*pb.AppGroup{
-								{
-									Status: pb.AppGroupStatus_APP_GROUP_DELETING,
-								},
-							},
-						}, nil)
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+					Return("", errors.New("FAILED_TO_CALL_WORKFLOW"))
 			},
-			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+			checkResponse: func(req *pb.ScaleClusterRequest, res *pb.SimpleResponse, err error) {
 				require.Error(t, err)
-				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+				require.Equal(t, res.Code, pb.Code_INTERNAL)
 			},
 		},
 	}
@@ -572,95 +916,227 @@ func TestDeleteCluster(t *testing.T) {
 
 			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
 			clusterInfoClient = mockClusterInfoClient
-			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
-			appInfoClient = mockAppInfoClient
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
+			mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).AnyTimes().
+				Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
 
-			tc.buildStubs(mockArgoClient, mockClusterInfoClient, mockAppInfoClient)
+			tc.buildStubs(mockArgoClient, mockClusterInfoClient)
 
 			s := server{}
-			res, err := s.DeleteCluster(ctx, tc.in)
+			res, err := s.ScaleCluster(ctx, tc.in)
 			tc.checkResponse(tc.in, res, err)
 		})
 	}
 }
 
-func TestInstallAppGroups(t *testing.T) {
+func TestDeleteCluster(t *testing.T) {
 	testCases := []struct {
-		name       string
-		in         *pb.InstallAppGroupsRequest
-		buildStubs func(mockArgoClient *mockargo.MockClient,
-			mockAppInfoClient *mocktks.MockAppInfoServiceClient,
-			mockClusterInfoClient *mocktks.MockClusterInfoServiceClient)
-		checkResponse func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error)
+		name          string
+		in            *pb.IDRequest
+		buildStubs    func(mockArgoClient *mockargo.MockClient, mockCspInfoClient *mocktks.MockCspInfoServiceClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient)
+		checkResponse func(req *pb.IDRequest, res *pb.SimpleResponse, err error)
 	}{
 		{
 			name: "OK",
-			in:   installAppGroupsRequest,
+			in: &pb.IDRequest{
+				Id: createdClusterId,
+			},
 			buildStubs: func(mockArgoClient *mockargo.MockClient,
-				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
-				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+
 				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
 					Return(
 						&pb.GetClusterResponse{
 							Code:  pb.Code_OK_UNSPECIFIED,
 							Error: nil,
+							Cluster: &pb.Cluster{
+								Status: pb.ClusterStatus_RUNNING,
+							},
 						}, nil)
 
 				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
 					Return(
 						&pb.GetAppGroupsResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-						}, errors.New("NOT_EXISTED_APPGROUPS"))
+							Code:      pb.Code_OK_UNSPECIFIED,
+							Error:     nil,
+							AppGroups: []*pb.AppGroup{},
+						}, nil)
 
-				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
 
-				mockAppInfoClient.EXPECT().CreateAppGroup(gomock.Any(), gomock.Any()).Times(1).
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
 					Return(
-						&pb.IDResponse{
+						&pb.SimpleResponse{
 							Code:  pb.Code_OK_UNSPECIFIED,
 							Error: nil,
-							Id:    createdAppGroupId,
 						}, nil)
 
-				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-lma-federation", gomock.Any(), gomock.Any()).Times(1).
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
 					Return(randomString("workflowName"), nil)
 			},
-			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
 				require.NoError(t, err)
 				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.Equal(t, len(installAppGroupsRequest.AppGroups), len(res.Ids))
-				require.Equal(t, createdAppGroupId, res.Ids[0])
-
 			},
 		},
 		{
-			name: "OK_NO_APPGROUPS",
-			in: &pb.InstallAppGroupsRequest{
-				AppGroups: []*pb.AppGroup{},
+			name: "OK_GCP",
+			in: &pb.IDRequest{
+				Id: createdClusterId,
 			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient,
-				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
-				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockCspInfoClient *mocktks.MockCspInfoServiceClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetClusterResponse{
+							Code: pb.Code_OK_UNSPECIFIED,
+							Cluster: &pb.Cluster{
+								Status: pb.ClusterStatus_ERROR,
+								CspId:  uuid.New().String(),
+							},
+						}, nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_GCP}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-remove-usercluster", gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(wftplName, targetNamespace string, opts argowf.SubmitOptions) (string, error) {
+						require.Contains(t, opts.Parameters, "app_group=tks-cluster-gcp")
+						return randomString("workflowName"), nil
+					})
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
 			},
-			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
 				require.NoError(t, err)
 				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.Equal(t, len(res.Ids), 0)
 			},
 		},
 		{
-			name: "OK_EXISTED_APPGROUP",
-			in:   installAppGroupsRequest,
-			buildStubs: func(mockArgoClient *mockargo.MockClient,
-				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
-				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+			name: "INVALID_ARGUMENT_CLUSTER_ID",
+			in: &pb.IDRequest{
+				Id: "THIS_IS_NOT_UUID",
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockCspInfoClient *mocktks.MockCspInfoServiceClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			},
+			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
+		{
+			name: "NOT_EXIST_CLUSTER",
+			in: &pb.IDRequest{
+				Id: helper.GenerateClusterId(),
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockCspInfoClient *mocktks.MockCspInfoServiceClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetClusterResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+						}, errors.New("NOT_EXISTED_CLUSTER"))
+			},
+			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_NOT_FOUND)
+			},
+		},
+		{
+			name: "THE_CLUSTER_ALREADY_DELETED",
+			in: &pb.IDRequest{
+				Id: helper.GenerateClusterId(),
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockCspInfoClient *mocktks.MockCspInfoServiceClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetClusterResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+							Cluster: &pb.Cluster{
+								Status: pb.ClusterStatus_DELETED,
+							},
+						}, nil)
+			},
+			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
+		{
+			name: "FAILED_TO_CALL_WORKFLOW",
+			in: &pb.IDRequest{
+				Id: helper.GenerateClusterId(),
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockCspInfoClient *mocktks.MockCspInfoServiceClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetClusterResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+							Cluster: &pb.Cluster{
+								Status: pb.ClusterStatus_RUNNING,
+							},
+						}, nil)
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetAppGroupsResponse{
+							Code:      pb.Code_OK_UNSPECIFIED,
+							Error:     nil,
+							AppGroups: []*pb.AppGroup{},
+						}, nil)
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+					Return(randomString("workflowName"), errors.New("FAILED_TO_CALL_WORKFLOW"))
+			},
+			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INTERNAL)
+			},
+		},
+		{
+			name: "CLUSTER_STATUS_IS_NOT_RUNNING",
+			in: &pb.IDRequest{
+				Id: helper.GenerateClusterId(),
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockCspInfoClient *mocktks.MockCspInfoServiceClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetClusterResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+							Cluster: &pb.Cluster{
+								Status: pb.ClusterStatus_INSTALLING,
+							},
+						}, nil)
+			},
+			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
+		{
+			name: "APPGROUP_STATUS_IS_NOT_DELETED",
+			in: &pb.IDRequest{
+				Id: helper.GenerateClusterId(),
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockCspInfoClient *mocktks.MockCspInfoServiceClient, mockClusterInfoClient *mocktks.MockClusterInfoServiceClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
 				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
 					Return(
 						&pb.GetClusterResponse{
 							Code:  pb.Code_OK_UNSPECIFIED,
 							Error: nil,
+							Cluster: &pb.Cluster{
+								Status: pb.ClusterStatus_RUNNING,
+							},
 						}, nil)
 
 				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
@@ -670,170 +1146,1827 @@ func TestInstallAppGroups(t *testing.T) {
 							Error: nil,
 							AppGroups: []*pb.AppGroup{
 								{
-									AppGroupId:    createdAppGroupId,
-									Type:          installAppGroupsRequest.GetAppGroups()[0].GetType(),
-									AppGroupName:  installAppGroupsRequest.GetAppGroups()[0].GetAppGroupName(),
-									ExternalLabel: installAppGroupsRequest.GetAppGroups()[0].GetExternalLabel(),
+									Status: pb.AppGroupStatus_APP_GROUP_DELETING,
 								},
 							},
 						}, nil)
-
-				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-lma-federation", gomock.Any(), gomock.Any()).Times(1).
-					Return(randomString("workflowName"), nil)
-
-				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
 			},
-			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
-				require.NoError(t, err)
-				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.Equal(t, res.Ids[0], createdAppGroupId)
+			checkResponse: func(req *pb.IDRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
 			},
 		},
-		{
-			name: "INVALID_ARGUMENT_CLUSTER_ID",
-			in: &pb.InstallAppGroupsRequest{
-				AppGroups: []*pb.AppGroup{
-					{
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// mocking and injection
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
+			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+			appInfoClient = mockAppInfoClient
+
+			tc.buildStubs(mockArgoClient, mockCspInfoClient, mockClusterInfoClient, mockAppInfoClient)
+
+			s := server{}
+			res, err := s.DeleteCluster(ctx, tc.in)
+			tc.checkResponse(tc.in, res, err)
+		})
+	}
+}
+
+func TestDeleteClusterProtection(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+	clusterName := randomString("NAME")
+	production := map[string]string{environmentLabel: productionEnvironment}
+
+	getCluster := func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+		mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+			Return(&pb.GetClusterResponse{
+				Code: pb.Code_OK_UNSPECIFIED,
+				Cluster: &pb.Cluster{
+					Id:     clusterId,
+					Name:   clusterName,
+					Status: pb.ClusterStatus_RUNNING,
+				},
+			}, nil)
+	}
+
+	testCases := []struct {
+		name               string
+		deletionProtection bool
+		labels             map[string]string
+		confirmation       string
+		buildStubs         func(mockArgoClient *mockargo.MockClient,
+			mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+			mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+			mockAppInfoClient *mocktks.MockAppInfoServiceClient)
+		checkResponse func(res *pb.SimpleResponse, err error)
+	}{
+		{
+			name:               "DELETION_PROTECTION_ENABLED",
+			deletionProtection: true,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				getCluster(mockClusterInfoClient)
+			},
+			checkResponse: func(res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_FAILED_PRECONDITION, res.Code)
+			},
+		},
+		{
+			name:   "PRODUCTION_WITHOUT_CONFIRMATION",
+			labels: production,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				getCluster(mockClusterInfoClient)
+			},
+			checkResponse: func(res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name:         "PRODUCTION_WITH_WRONG_CONFIRMATION",
+			labels:       production,
+			confirmation: "wrong-name",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				getCluster(mockClusterInfoClient)
+			},
+			checkResponse: func(res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name:         "PRODUCTION_CONFIRMED",
+			labels:       production,
+			confirmation: clusterName,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				getCluster(mockClusterInfoClient)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-remove-usercluster", gomock.Any(), gomock.Any()).Times(1).
+					Return(randomString("workflowName"), nil)
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+			checkResponse: func(res *pb.SimpleResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.confirmation != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(confirmClusterNameHeader, tc.confirmation))
+			}
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// mocking and injection
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
+			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+			appInfoClient = mockAppInfoClient
+
+			// The protection is in the shared secret store, so the replica needs no metadata of the cluster.
+			require.NoError(t, setDeletionProtection(ctx, clusterId, tc.deletionProtection))
+			require.NoError(t, setProductionCluster(ctx, clusterId, tc.labels))
+
+			tc.buildStubs(mockArgoClient, mockCspInfoClient, mockClusterInfoClient, mockAppInfoClient)
+
+			s := server{}
+			res, err := s.DeleteCluster(ctx, &pb.IDRequest{Id: clusterId})
+			tc.checkResponse(res, err)
+		})
+	}
+}
+
+func TestSetDeletionProtection(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+
+	clusterId := helper.GenerateClusterId()
+	mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(2).
+		Return(&pb.GetClusterResponse{Code: pb.Code_OK_UNSPECIFIED, Cluster: &pb.Cluster{Id: clusterId}}, nil)
+
+	s := server{}
+	for _, enabled := range []bool{true, false} {
+		res, err := s.SetDeletionProtection(context.Background(), &SetDeletionProtectionRequest{ClusterId: clusterId, DeletionProtection: enabled})
+		require.NoError(t, err)
+		require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+
+		protection, err := getDeletionProtection(context.Background(), clusterId)
+		require.NoError(t, err)
+		require.Equal(t, enabled, protection.Enabled)
+	}
+
+	res, err := s.SetDeletionProtection(context.Background(), &SetDeletionProtectionRequest{ClusterId: "invalid"})
+	require.Error(t, err)
+	require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+}
+
+func TestDeleteClusterDeletionProtectionUnavailable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+
+	// The secret store rejects the requests, so the protection can not be known.
+	ts := fakeKubeSecretServer(t, "argo")
+	defer ts.Close()
+	fileSecrets := secrets
+	secrets = &kubeSecretStore{server: ts.URL, namespace: "argo", token: "invalid", client: ts.Client()}
+	t.Cleanup(func() { secrets = fileSecrets })
+
+	clusterId := helper.GenerateClusterId()
+	mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.GetClusterResponse{
+			Code:    pb.Code_OK_UNSPECIFIED,
+			Cluster: &pb.Cluster{Id: clusterId, Status: pb.ClusterStatus_RUNNING},
+		}, nil)
+
+	s := server{}
+	res, err := s.DeleteCluster(context.Background(), &pb.IDRequest{Id: clusterId})
+	require.Error(t, err)
+	require.Equal(t, pb.Code_INTERNAL, res.Code)
+}
+
+func TestValidateClusterName(t *testing.T) {
+	require.NoError(t, validateClusterName("team-a cluster"))
+	require.NoError(t, validateClusterName(strings.Repeat("가", maxClusterNameLength)))
+
+	require.Error(t, validateClusterName(""))
+	require.Error(t, validateClusterName(strings.Repeat("a", maxClusterNameLength+1)))
+	require.Error(t, validateClusterName(" leading"))
+	require.Error(t, validateClusterName("trailing "))
+	require.Error(t, validateClusterName("new\nline"))
+}
+
+func TestUpdateCluster(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+	cluster := &pb.GetClusterResponse{
+		Code: pb.Code_OK_UNSPECIFIED,
+		Cluster: &pb.Cluster{
+			Id:          clusterId,
+			Name:        "cluster",
+			Description: "description",
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		in             *UpdateClusterRequest
+		buildStubs     func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient)
+		expectedCode   pb.Code
+		expectedLabels map[string]string
+	}{
+		{
+			name: "OK",
+			in:   &UpdateClusterRequest{ClusterId: clusterId, Name: "cluster", Labels: map[string]string{"team": "a", environmentLabel: "dev"}},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).Return(cluster, nil)
+			},
+			expectedCode:   pb.Code_OK_UNSPECIFIED,
+			expectedLabels: map[string]string{"team": "a", environmentLabel: "dev"},
+		},
+		{
+			name: "OK_PRODUCTION",
+			in:   &UpdateClusterRequest{ClusterId: clusterId, Labels: map[string]string{"team": "a", environmentLabel: productionEnvironment}},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).Return(cluster, nil)
+			},
+			expectedCode:   pb.Code_OK_UNSPECIFIED,
+			expectedLabels: map[string]string{"team": "a", environmentLabel: productionEnvironment},
+		},
+		{
+			name: "OK_NOT_PRODUCTION",
+			in:   &UpdateClusterRequest{ClusterId: clusterId, Labels: map[string]string{"team": "a", environmentLabel: "dev"}},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).Return(cluster, nil)
+			},
+			expectedCode:   pb.Code_OK_UNSPECIFIED,
+			expectedLabels: map[string]string{"team": "a", environmentLabel: "dev"},
+		},
+		{
+			name: "OK_KEEP_LABELS",
+			in:   &UpdateClusterRequest{ClusterId: clusterId, Description: "description"},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).Return(cluster, nil)
+			},
+			expectedCode:   pb.Code_OK_UNSPECIFIED,
+			expectedLabels: map[string]string{"team": "a", environmentLabel: "dev"},
+		},
+		{
+			name: "OK_REMOVE_LABELS",
+			in:   &UpdateClusterRequest{ClusterId: clusterId, Labels: map[string]string{}},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).Return(cluster, nil)
+			},
+			expectedCode: pb.Code_OK_UNSPECIFIED,
+		},
+		{
+			name:         "INVALID_CLUSTER_ID",
+			in:           &UpdateClusterRequest{ClusterId: "invalid"},
+			buildStubs:   func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {},
+			expectedCode: pb.Code_INVALID_ARGUMENT,
+		},
+		{
+			name:         "INVALID_NAME",
+			in:           &UpdateClusterRequest{ClusterId: clusterId, Name: " cluster"},
+			buildStubs:   func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {},
+			expectedCode: pb.Code_INVALID_ARGUMENT,
+		},
+		{
+			name:         "INVALID_LABEL",
+			in:           &UpdateClusterRequest{ClusterId: clusterId, Labels: map[string]string{"team": "a b"}},
+			buildStubs:   func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {},
+			expectedCode: pb.Code_INVALID_ARGUMENT,
+		},
+		{
+			name: "NO_CLUSTER",
+			in:   &UpdateClusterRequest{ClusterId: clusterId},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(nil, errors.New("NOT_FOUND"))
+			},
+			expectedCode: pb.Code_NOT_FOUND,
+		},
+		{
+			name: "RENAME_NOT_SUPPORTED",
+			in:   &UpdateClusterRequest{ClusterId: clusterId, Name: "renamed", Labels: map[string]string{"team": "b"}},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).Return(cluster, nil)
+			},
+			expectedCode: pb.Code_UNIMPLEMENTED,
+		},
+	}
+
+	// The cases run in order, since the labels of a case are kept for the next one.
+	for _, tc := range testCases {
+		ctrl := gomock.NewController(t)
+
+		mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+		clusterInfoClient = mockClusterInfoClient
+		tc.buildStubs(mockClusterInfoClient)
+
+		s := server{}
+		res, err := s.UpdateCluster(context.Background(), tc.in)
+		require.Equal(t, tc.expectedCode, res.Code, tc.name)
+		if tc.expectedCode == pb.Code_OK_UNSPECIFIED {
+			require.NoError(t, err, tc.name)
+
+			md, err := clusterMetadatas.get(clusterId)
+			require.NoError(t, err)
+			require.Equal(t, len(tc.expectedLabels), len(md.Labels), tc.name)
+			for key, value := range tc.expectedLabels {
+				require.Equal(t, value, md.Labels[key], tc.name)
+			}
+
+			protection, err := getDeletionProtection(context.Background(), clusterId)
+			require.NoError(t, err)
+			require.Equal(t, isProduction(tc.expectedLabels), protection.Production, tc.name)
+		} else {
+			require.Error(t, err, tc.name)
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestInstallAppGroups(t *testing.T) {
+	testCases := []struct {
+		name       string
+		in         *pb.InstallAppGroupsRequest
+		buildStubs func(mockArgoClient *mockargo.MockClient,
+			mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+			mockClusterInfoClient *mocktks.MockClusterInfoServiceClient)
+		checkResponse func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error)
+	}{
+		{
+			name: "OK",
+			in:   installAppGroupsRequest,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetClusterResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+						}, nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetAppGroupsResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+						}, errors.New("NOT_EXISTED_APPGROUPS"))
+
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
+
+				mockAppInfoClient.EXPECT().CreateAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.IDResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+							Id:    createdAppGroupId,
+						}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-lma-federation", gomock.Any(), gomock.Any()).Times(1).
+					Return(randomString("workflowName"), nil)
+			},
+			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
+				require.Equal(t, len(installAppGroupsRequest.AppGroups), len(res.Ids))
+				require.Equal(t, createdAppGroupId, res.Ids[0])
+
+			},
+		},
+		{
+			name: "OK_NO_APPGROUPS",
+			in: &pb.InstallAppGroupsRequest{
+				AppGroups: []*pb.AppGroup{},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+			},
+			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
+				require.Equal(t, len(res.Ids), 0)
+			},
+		},
+		{
+			name: "OK_EXISTED_APPGROUP",
+			in:   installAppGroupsRequest,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetClusterResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+						}, nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetAppGroupsResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+							AppGroups: []*pb.AppGroup{
+								{
+									AppGroupId:    createdAppGroupId,
+									Type:          installAppGroupsRequest.GetAppGroups()[0].GetType(),
+									AppGroupName:  installAppGroupsRequest.GetAppGroups()[0].GetAppGroupName(),
+									ExternalLabel: installAppGroupsRequest.GetAppGroups()[0].GetExternalLabel(),
+								},
+							},
+						}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-lma-federation", gomock.Any(), gomock.Any()).Times(1).
+					Return(randomString("workflowName"), nil)
+
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
+			},
+			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
+				require.Equal(t, res.Ids[0], createdAppGroupId)
+			},
+		},
+		{
+			name: "INVALID_ARGUMENT_CLUSTER_ID",
+			in: &pb.InstallAppGroupsRequest{
+				AppGroups: []*pb.AppGroup{
+					{
 						ClusterId: "THIS_IS_NOT_UUID",
 					},
 				},
 			},
 			buildStubs: func(mockArgoClient *mockargo.MockClient,
-				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+			},
+			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
+		{
+			name: "INVALID_ARGUMENT_NO_NAME",
+			in: &pb.InstallAppGroupsRequest{
+				AppGroups: []*pb.AppGroup{
+					{
+						ClusterId:    helper.GenerateClusterId(),
+						AppGroupName: "",
+					},
+				},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+			},
+			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
+		{
+			name: "INVALID_ARGUMENT_UNKNOWN_TYPE",
+			in: &pb.InstallAppGroupsRequest{
+				AppGroups: []*pb.AppGroup{
+					{
+						ClusterId:     helper.GenerateClusterId(),
+						AppGroupName:  randomString("APPGROUP"),
+						Type:          pb.AppGroupType_APP_TYPE_UNSPECIFIED,
+						ExternalLabel: randomString("EXTERNAL_LABEL"),
+					},
+				},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+			},
+			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
+		{
+			name: "NOT_EXIST_CLUSTER_ID",
+			in:   installAppGroupsRequest,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetClusterResponse{}, errors.New("NOT_EXIST_CLUSTER_ID"))
+			},
+			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_NOT_FOUND)
+				require.True(t, len(installAppGroupsRequest.AppGroups) > len(res.Ids))
+			},
+		},
+		{
+			name: "FAILED_TO_CREATE_APPGROUP",
+			in:   installAppGroupsRequest,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetClusterResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+						}, nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetAppGroupsResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+						}, errors.New("NOT_EXISTED_APPGROUPS"))
+
+				mockAppInfoClient.EXPECT().CreateAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDResponse{}, errors.New("Failed to create appgroup"))
+			},
+			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INTERNAL)
+				require.True(t, len(installAppGroupsRequest.AppGroups) > len(res.Ids))
+			},
+		},
+		{
+			name: "SERVICEMESH_CALL_WORKFLOW",
+			in: &pb.InstallAppGroupsRequest{
+				AppGroups: []*pb.AppGroup{
+					{
+						AppGroupId:    helper.GenerateApplicaionGroupId(),
+						AppGroupName:  randomString("APPGROUP"),
+						Type:          pb.AppGroupType_SERVICE_MESH,
+						ClusterId:     helper.GenerateClusterId(),
+						Status:        pb.AppGroupStatus_APP_GROUP_UNSPECIFIED,
+						ExternalLabel: randomString("EXTERNAL_LABEL"),
+					},
+				},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetClusterResponse{}, nil)
+
+				mockAppInfoClient.EXPECT().CreateAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDResponse{Id: createdAppGroupId}, nil)
+
+				// The service mesh depends on the LMA, which is running in the cluster.
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{
+						Code: pb.Code_OK_UNSPECIFIED,
+						AppGroups: []*pb.AppGroup{
+							{
+								AppGroupId: helper.GenerateApplicaionGroupId(),
+								Type:       pb.AppGroupType_LMA,
+								Status:     pb.AppGroupStatus_APP_GROUP_RUNNING,
+							},
+						},
+					}, nil)
+
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-service-mesh", gomock.Any(), gomock.Any()).Times(1).
+					Return(randomString("workflowName"), nil)
+			},
+			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
+				require.True(t, len(installAppGroupsRequest.AppGroups) == len(res.Ids))
+			},
+		},
+		{
+			name: "FAILED_TO_CALL_WORKFLOW",
+			in:   installAppGroupsRequest,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetClusterResponse{}, nil)
+
+				mockAppInfoClient.EXPECT().CreateAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDResponse{Id: createdAppGroupId}, nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, errors.New("NOT_EXISTED_APPGROUPS"))
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+					Return("", errors.New("FAILED_TO_CALL_WORKFLOW"))
+			},
+			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INTERNAL)
+				require.Equal(t, len(res.Ids), 0)
+			},
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// mocking and injection
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+
+			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+			appInfoClient = mockAppInfoClient
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
+
+			tc.buildStubs(mockArgoClient, mockAppInfoClient, mockClusterInfoClient)
+
+			s := server{}
+			res, err := s.InstallAppGroups(ctx, tc.in)
+			tc.checkResponse(tc.in, res, err)
+		})
+	}
+}
+
+func TestUninstallAppGroups(t *testing.T) {
+	testCases := []struct {
+		name          string
+		in            *pb.UninstallAppGroupsRequest
+		buildStubs    func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient)
+		checkResponse func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error)
+	}{
+		{
+			name: "OK",
+			in: &pb.UninstallAppGroupsRequest{
+				AppGroupIds: []string{createdAppGroupId},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetAppGroupResponse{
+							Code:     pb.Code_OK_UNSPECIFIED,
+							Error:    nil,
+							AppGroup: installAppGroupsRequest.GetAppGroups()[0],
+						}, nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{
+						Code:      pb.Code_OK_UNSPECIFIED,
+						AppGroups: installAppGroupsRequest.GetAppGroups(),
+					}, nil)
+
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+					Return(randomString("workflowName"), nil)
+			},
+			checkResponse: func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
+				require.Equal(t, len(res.Ids), 1)
+				require.Equal(t, createdAppGroupId, res.Ids[0])
+			},
+		},
+		{
+			name: "INVALID_ARGUMENT_APPGROUP_ID",
+			in: &pb.UninstallAppGroupsRequest{
+				AppGroupIds: []string{"THIS_IS_NOT_UUID"},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			},
+			checkResponse: func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+			},
+		},
+		{
+			name: "NOT_EXISTED_APPGROUP",
+			in: &pb.UninstallAppGroupsRequest{
+				AppGroupIds: []string{helper.GenerateApplicaionGroupId()},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetAppGroupResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+						}, errors.New("NOT_EXISTED_APPGROUP"))
+			},
+			checkResponse: func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_NOT_FOUND)
+				require.Equal(t, len(res.Ids), 0)
+			},
+		},
+		{
+			name: "FAILED_TO_CALL_WORKFLOW",
+			in: &pb.UninstallAppGroupsRequest{
+				AppGroupIds: []string{createdAppGroupId},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetAppGroupResponse{
+							Code:     pb.Code_OK_UNSPECIFIED,
+							Error:    nil,
+							AppGroup: installAppGroupsRequest.GetAppGroups()[0],
+						}, nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{
+						Code:      pb.Code_OK_UNSPECIFIED,
+						AppGroups: installAppGroupsRequest.GetAppGroups(),
+					}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+					Return("", errors.New("FAILED_TO_CALL_WORKFLOW"))
+			},
+			checkResponse: func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, res.Code, pb.Code_INTERNAL)
+				require.Equal(t, len(res.Ids), 0)
+			},
+		},
+		{
+			name: "PARTIALLY_SUCCESS",
+			in: &pb.UninstallAppGroupsRequest{
+				AppGroupIds: []string{createdAppGroupId, helper.GenerateApplicaionGroupId()},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), &pb.GetAppGroupRequest{AppGroupId: createdAppGroupId}).Times(1).
+					Return(
+						&pb.GetAppGroupResponse{
+							Code:     pb.Code_OK_UNSPECIFIED,
+							Error:    nil,
+							AppGroup: installAppGroupsRequest.GetAppGroups()[0],
+						}, nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{
+						Code:      pb.Code_OK_UNSPECIFIED,
+						AppGroups: installAppGroupsRequest.GetAppGroups(),
+					}, nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(
+						&pb.GetAppGroupResponse{
+							Code:  pb.Code_OK_UNSPECIFIED,
+							Error: nil,
+						}, errors.New("NOT_EXISTED_APPGROUP"))
+
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+					Return(randomString("workflowName"), nil)
+			},
+			checkResponse: func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pb.Code_ABORTED, res.Code)
+				require.NotNil(t, res.Error)
+				require.Equal(t, len(res.Ids), 1)
+				require.Equal(t, createdAppGroupId, res.Ids[0])
+			},
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// mocking and injection
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+
+			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+			appInfoClient = mockAppInfoClient
+
+			tc.buildStubs(mockArgoClient, mockAppInfoClient)
+
+			s := server{}
+			res, err := s.UninstallAppGroups(ctx, tc.in)
+			tc.checkResponse(tc.in, res, err)
+		})
+	}
+
+}
+
+// Helpers
+
+func TestUpgradeCluster(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+
+	clusterWithStatus := func(status pb.ClusterStatus) *pb.GetClusterResponse {
+		return &pb.GetClusterResponse{
+			Code: pb.Code_OK_UNSPECIFIED,
+			Cluster: &pb.Cluster{
+				Id:     clusterId,
+				Status: status,
+			},
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		in             *UpgradeClusterRequest
+		currentVersion string
+		buildStubs     func(mockArgoClient *mockargo.MockClient,
+			mockClusterInfoClient *mocktks.MockClusterInfoServiceClient)
+		checkResponse func(req *UpgradeClusterRequest, res *pb.SimpleResponse, err error)
+	}{
+		{
+			name:           "OK",
+			in:             &UpgradeClusterRequest{ClusterId: clusterId, Version: "v1.24.10"},
+			currentVersion: "v1.23.16",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-upgrade-usercluster", "argo", gomock.Any()).Times(1).
+					DoAndReturn(func(workflow string, namespace string, opts argowf.SubmitOptions) (string, error) {
+						require.Contains(t, opts.Parameters, "current_kubernetes_version=v1.23.16")
+						require.Contains(t, opts.Parameters, "kubernetes_version=v1.24.10")
+						return randomString("workflowName"), nil
+					})
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_INSTALLING, in.GetStatus())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+			checkResponse: func(req *UpgradeClusterRequest, res *pb.SimpleResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+
+				// the version is updated when the workflow succeeds
+				md, err := clusterMetadatas.get(req.ClusterId)
+				require.NoError(t, err)
+				require.Equal(t, "v1.23.16", md.KubernetesVersion)
+			},
+		},
+		{
+			name:           "INVALID_ARGUMENT_VERSION",
+			in:             &UpgradeClusterRequest{ClusterId: clusterId, Version: "1.24"},
+			currentVersion: "v1.23.16",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+			},
+			checkResponse: func(req *UpgradeClusterRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name:           "CLUSTER_STATUS_IS_NOT_RUNNING",
+			in:             &UpgradeClusterRequest{ClusterId: clusterId, Version: "v1.24.10"},
+			currentVersion: "v1.23.16",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_INSTALLING), nil)
+			},
+			checkResponse: func(req *UpgradeClusterRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name: "UNKNOWN_CURRENT_VERSION",
+			in:   &UpgradeClusterRequest{ClusterId: clusterId, Version: "v1.24.10"},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+			},
+			checkResponse: func(req *UpgradeClusterRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_FAILED_PRECONDITION, res.Code)
+			},
+		},
+		{
+			name:           "SKIP_MINOR_VERSION",
+			in:             &UpgradeClusterRequest{ClusterId: clusterId, Version: "v1.25.6"},
+			currentVersion: "v1.23.16",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+			},
+			checkResponse: func(req *UpgradeClusterRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name:           "FAILED_TO_CALL_WORKFLOW",
+			in:             &UpgradeClusterRequest{ClusterId: clusterId, Version: "v1.24.10"},
+			currentVersion: "v1.23.16",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+					Return("", errors.New("FAILED_TO_CALL_WORKFLOW"))
+			},
+			checkResponse: func(req *UpgradeClusterRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INTERNAL, res.Code)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// mocking and injection
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
+			mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).AnyTimes().
+				Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
+
+			require.NoError(t, clusterMetadatas.update(clusterId, func(md *clusterMetadata) error {
+				md.KubernetesVersion = tc.currentVersion
+				return nil
+			}))
+
+			tc.buildStubs(mockArgoClient, mockClusterInfoClient)
+
+			s := server{}
+			res, err := s.UpgradeCluster(ctx, tc.in)
+			tc.checkResponse(tc.in, res, err)
+		})
+	}
+}
+
+func TestUpgradeAppGroup(t *testing.T) {
+	appGroupId := helper.GenerateApplicaionGroupId()
+	clusterId := helper.GenerateClusterId()
+
+	appGroupWithStatus := func(appGroupType pb.AppGroupType, status pb.AppGroupStatus) *pb.GetAppGroupResponse {
+		return &pb.GetAppGroupResponse{
+			Code: pb.Code_OK_UNSPECIFIED,
+			AppGroup: &pb.AppGroup{
+				AppGroupId: appGroupId,
+				ClusterId:  clusterId,
+				Type:       appGroupType,
+				Status:     status,
+			},
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		in              *UpgradeAppGroupRequest
+		currentRevision string
+		buildStubs      func(mockArgoClient *mockargo.MockClient,
+			mockAppInfoClient *mocktks.MockAppInfoServiceClient)
+		checkResponse func(req *UpgradeAppGroupRequest, res *pb.SimpleResponse, err error)
+	}{
+		{
+			name:            "OK",
+			in:              &UpgradeAppGroupRequest{AppGroupId: appGroupId, Revision: "v1.1.0"},
+			currentRevision: "v1.0.0",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(appGroupWithStatus(pb.AppGroupType_LMA, pb.AppGroupStatus_APP_GROUP_RUNNING), nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-upgrade-lma-federation", "argo", gomock.Any()).Times(1).
+					DoAndReturn(func(workflow string, namespace string, opts argowf.SubmitOptions) (string, error) {
+						require.Contains(t, opts.Parameters, "revision=v1.1.0")
+						require.Contains(t, opts.Parameters, "current_revision=v1.0.0")
+						require.Contains(t, opts.Parameters, "logging_component=loki")
+						// the custom values of the install are kept
+						require.Contains(t, opts.Parameters, `app_group_values={"loki_retention":"168h"}`)
+						return "upgrade-lma-workflow", nil
+					})
+
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateAppGroupStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.AppGroupStatus_APP_GROUP_INSTALLING, in.GetStatus())
+						require.Equal(t, "upgrade-lma-workflow", in.GetWorkflowId())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+			checkResponse: func(req *UpgradeAppGroupRequest, res *pb.SimpleResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+
+				op, err := operations.last(req.AppGroupId, operationUpgradeAppGroup)
+				require.NoError(t, err)
+				require.Equal(t, "upgrade-lma-workflow", op.WorkflowId)
+				require.Equal(t, operationPhaseRunning, op.Phase)
+			},
+		},
+		{
+			name: "INVALID_ARGUMENT_REVISION",
+			in:   &UpgradeAppGroupRequest{AppGroupId: appGroupId, Revision: "../main"},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			},
+			checkResponse: func(req *UpgradeAppGroupRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name: "NOT_EXISTED_APPGROUP",
+			in:   &UpgradeAppGroupRequest{AppGroupId: appGroupId, Revision: "v1.1.0"},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupResponse{}, errors.New("NOT_EXISTED_APPGROUP"))
+			},
+			checkResponse: func(req *UpgradeAppGroupRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_NOT_FOUND, res.Code)
+			},
+		},
+		{
+			name: "APPGROUP_STATUS_IS_NOT_RUNNING",
+			in:   &UpgradeAppGroupRequest{AppGroupId: appGroupId, Revision: "v1.1.0"},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(appGroupWithStatus(pb.AppGroupType_LMA, pb.AppGroupStatus_APP_GROUP_INSTALLING), nil)
+			},
+			checkResponse: func(req *UpgradeAppGroupRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name:            "SAME_REVISION",
+			in:              &UpgradeAppGroupRequest{AppGroupId: appGroupId, Revision: "v1.0.0"},
+			currentRevision: "v1.0.0",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(appGroupWithStatus(pb.AppGroupType_LMA, pb.AppGroupStatus_APP_GROUP_RUNNING), nil)
+			},
+			checkResponse: func(req *UpgradeAppGroupRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name:            "FAILED_TO_CALL_WORKFLOW",
+			in:              &UpgradeAppGroupRequest{AppGroupId: appGroupId, Revision: "v1.1.0"},
+			currentRevision: "v1.0.0",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+					Return(appGroupWithStatus(pb.AppGroupType_SERVICE_MESH, pb.AppGroupStatus_APP_GROUP_RUNNING), nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-upgrade-service-mesh", gomock.Any(), gomock.Any()).Times(1).
+					Return("", errors.New("FAILED_TO_CALL_WORKFLOW"))
+			},
+			checkResponse: func(req *UpgradeAppGroupRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INTERNAL, res.Code)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// mocking and injection
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+			appInfoClient = mockAppInfoClient
+			operations = newTestOperationStore(t)
+
+			if tc.currentRevision != "" {
+				op := recordOperation(ctx, operationInstallAppGroup, appGroupId, randomString("workflowName"), []string{
+					"revision=" + tc.currentRevision,
+					`app_group_values={"loki_retention":"168h"}`,
+				}, nil)
+				require.NoError(t, operations.finish(op, operationPhaseSucceeded, ""))
+			}
+
+			tc.buildStubs(mockArgoClient, mockAppInfoClient)
+
+			s := server{}
+			res, err := s.UpgradeAppGroup(ctx, tc.in)
+			tc.checkResponse(tc.in, res, err)
+		})
+	}
+}
+
+func TestValidateRevision(t *testing.T) {
+	for _, revision := range []string{"main", "v1.1.0", "release/1.1", "feature_x-2"} {
+		require.NoError(t, validateRevision(revision), revision)
+	}
+	for _, revision := range []string{"", "-main", "../main", "release/", "v1.1.", "main branch", "a//b"} {
+		require.Error(t, validateRevision(revision), revision)
+	}
+}
+
+func TestUpdateClusterCredentials(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+	ca := testCACertificate(t)
+	current := testKubeconfigWithCA("https://10.0.0.1:6443", ca, randomString("TOKEN"))
+	rotated := testKubeconfigWithCA("https://10.0.0.1:6443", ca, randomString("TOKEN"))
+
+	clusterWithStatus := func(status pb.ClusterStatus) *pb.GetClusterResponse {
+		return &pb.GetClusterResponse{
+			Code: pb.Code_OK_UNSPECIFIED,
+			Cluster: &pb.Cluster{
+				Id:     clusterId,
+				Status: status,
+			},
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		in         *UpdateClusterCredentialsRequest
+		imported   bool
+		buildStubs func(mockArgoClient *mockargo.MockClient,
+			mockClusterInfoClient *mocktks.MockClusterInfoServiceClient)
+		checkResponse func(res *pb.SimpleResponse, err error)
+	}{
+		{
+			name:     "OK",
+			in:       &UpdateClusterCredentialsRequest{ClusterId: clusterId, Kubeconfig: rotated},
+			imported: true,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-refresh-credentials-usercluster", "argo", gomock.Any()).Times(1).
+					DoAndReturn(func(workflow string, namespace string, opts argowf.SubmitOptions) (string, error) {
+						require.Contains(t, opts.Parameters, "kubeconfig_secret_name="+pendingKubeconfigSecretName(clusterId))
+						return randomString("workflowName"), nil
+					})
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+			checkResponse: func(res *pb.SimpleResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+
+				// The current kubeconfig is kept until the workflow succeeds.
+				data, err := secrets.get(context.Background(), kubeconfigSecretName(clusterId))
+				require.NoError(t, err)
+				require.Equal(t, current, data[kubeconfigSecretKey])
+
+				require.NoError(t, promotePendingKubeconfig(context.Background(), clusterId))
+				data, err = secrets.get(context.Background(), kubeconfigSecretName(clusterId))
+				require.NoError(t, err)
+				require.Equal(t, rotated, data[kubeconfigSecretKey])
+				_, err = secrets.get(context.Background(), pendingKubeconfigSecretName(clusterId))
+				require.Error(t, err)
+			},
+		},
+		{
+			name:     "INVALID_KUBECONFIG",
+			in:       &UpdateClusterCredentialsRequest{ClusterId: clusterId, Kubeconfig: []byte(randomString("KUBECONFIG"))},
+			imported: true,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+			},
+			checkResponse: func(res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name:     "INVALID_CLUSTER_STATUS",
+			in:       &UpdateClusterCredentialsRequest{ClusterId: clusterId, Kubeconfig: rotated},
+			imported: true,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_INSTALLING), nil)
+			},
+			checkResponse: func(res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name: "NOT_IMPORTED_CLUSTER",
+			in:   &UpdateClusterCredentialsRequest{ClusterId: clusterId, Kubeconfig: rotated},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+			},
+			checkResponse: func(res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_FAILED_PRECONDITION, res.Code)
+			},
+		},
+		{
+			name:     "OTHER_CLUSTER",
+			in:       &UpdateClusterCredentialsRequest{ClusterId: clusterId, Kubeconfig: testKubeconfigWithCA("https://10.0.0.1:6443", testCACertificate(t), "token")},
+			imported: true,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+			},
+			checkResponse: func(res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name:     "FAILED_TO_CALL_WORKFLOW",
+			in:       &UpdateClusterCredentialsRequest{ClusterId: clusterId, Kubeconfig: rotated},
+			imported: true,
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+					Return("", errors.New("FAILED_TO_CALL_WORKFLOW"))
+			},
+			checkResponse: func(res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INTERNAL, res.Code)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
+			mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).AnyTimes().
+				Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
+
+			require.NoError(t, secrets.delete(context.Background(), kubeconfigSecretName(clusterId)))
+			if tc.imported {
+				require.NoError(t, secrets.put(context.Background(), kubeconfigSecretName(clusterId),
+					map[string][]byte{kubeconfigSecretKey: current}))
+			}
+
+			tc.buildStubs(mockArgoClient, mockClusterInfoClient)
+
+			s := server{}
+			res, err := s.UpdateClusterCredentials(context.Background(), tc.in)
+			tc.checkResponse(res, err)
+		})
+	}
+}
+
+func TestAddNodePool(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+
+	clusterWithStatus := func(status pb.ClusterStatus) *pb.GetClusterResponse {
+		return &pb.GetClusterResponse{
+			Code: pb.Code_OK_UNSPECIFIED,
+			Cluster: &pb.Cluster{
+				Id:     clusterId,
+				CspId:  uuid.New().String(),
+				Status: status,
+				Conf: &pb.ClusterConf{
+					Region:  "ap-northeast-2",
+					NumOfAz: 3,
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		in         *NodePoolRequest
+		buildStubs func(mockArgoClient *mockargo.MockClient,
+			mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+			mockClusterInfoClient *mocktks.MockClusterInfoServiceClient)
+		checkResponse func(req *NodePoolRequest, res *pb.SimpleResponse, err error)
+	}{
+		{
+			name: "OK",
+			in: &NodePoolRequest{
+				ClusterId: clusterId,
+				NodePool:  &NodePool{Name: "memory", MachineType: "r5.xlarge", Replicas: 3},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-apply-nodepool-usercluster", "argo", gomock.Any()).Times(1).
+					DoAndReturn(func(workflow string, namespace string, opts argowf.SubmitOptions) (string, error) {
+						require.Contains(t, opts.Parameters, "action=add")
+						require.Contains(t, opts.Parameters, "node_pool_name=memory")
+						return randomString("workflowName"), nil
+					})
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_INSTALLING, in.GetStatus())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+			checkResponse: func(req *NodePoolRequest, res *pb.SimpleResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+				require.Equal(t, 1, req.NodePool.MinSizePerAz)
+
+				op, err := operations.last(clusterId, operationAddNodePool)
+				require.NoError(t, err)
+				require.NotNil(t, op)
+				require.Equal(t, operationPhaseRunning, op.Phase)
+			},
+		},
+		{
+			name: "ALREADY_EXISTS",
+			in: &NodePoolRequest{
+				ClusterId: clusterId,
+				NodePool:  &NodePool{Name: "system", MachineType: "t3.large", Replicas: 3},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+			},
+			checkResponse: func(req *NodePoolRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_ALREADY_EXISTS, res.Code)
+			},
+		},
+		{
+			name: "INVALID_NODE_POOL",
+			in: &NodePoolRequest{
+				ClusterId: clusterId,
+				NodePool:  &NodePool{Name: "memory", MachineType: "r5.xlarge", Replicas: 100},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
+			},
+			checkResponse: func(req *NodePoolRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name: "CLUSTER_STATUS_IS_NOT_RUNNING",
+			in: &NodePoolRequest{
+				ClusterId: clusterId,
+				NodePool:  &NodePool{Name: "memory", MachineType: "r5.xlarge", Replicas: 3},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_INSTALLING), nil)
+			},
+			checkResponse: func(req *NodePoolRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// mocking and injection
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
+
+			require.NoError(t, clusterMetadatas.update(clusterId, func(md *clusterMetadata) error {
+				md.NodePools = []*NodePool{{Name: "system", MachineType: "t3.large", Replicas: 3}}
+				return nil
+			}))
+
+			tc.buildStubs(mockArgoClient, mockCspInfoClient, mockClusterInfoClient)
+
+			s := server{}
+			res, err := s.AddNodePool(ctx, tc.in)
+			tc.checkResponse(tc.in, res, err)
+		})
+	}
+}
+
+func TestRemoveNodePool(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+
+	testCases := []struct {
+		name       string
+		in         *RemoveNodePoolRequest
+		buildStubs func(mockArgoClient *mockargo.MockClient,
+			mockClusterInfoClient *mocktks.MockClusterInfoServiceClient)
+		checkResponse func(req *RemoveNodePoolRequest, res *pb.SimpleResponse, err error)
+	}{
+		{
+			name: "OK",
+			in:   &RemoveNodePoolRequest{ClusterId: clusterId, Name: "system"},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetClusterResponse{
+						Code:    pb.Code_OK_UNSPECIFIED,
+						Cluster: &pb.Cluster{Id: clusterId, Status: pb.ClusterStatus_RUNNING},
+					}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-apply-nodepool-usercluster", "argo", gomock.Any()).Times(1).
+					DoAndReturn(func(workflow string, namespace string, opts argowf.SubmitOptions) (string, error) {
+						require.Contains(t, opts.Parameters, "action=remove")
+						require.Contains(t, opts.Parameters, "node_pool_name=system")
+						return randomString("workflowName"), nil
+					})
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+			checkResponse: func(req *RemoveNodePoolRequest, res *pb.SimpleResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+			},
+		},
+		{
+			name: "NOT_FOUND",
+			in:   &RemoveNodePoolRequest{ClusterId: clusterId, Name: "memory"},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetClusterResponse{
+						Code:    pb.Code_OK_UNSPECIFIED,
+						Cluster: &pb.Cluster{Id: clusterId, Status: pb.ClusterStatus_RUNNING},
+					}, nil)
+			},
+			checkResponse: func(req *RemoveNodePoolRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_NOT_FOUND, res.Code)
+			},
+		},
+		{
+			name: "INVALID_CLUSTER_ID",
+			in:   &RemoveNodePoolRequest{ClusterId: "invalid", Name: "system"},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+			},
+			checkResponse: func(req *RemoveNodePoolRequest, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// mocking and injection
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
+			mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).AnyTimes().
+				Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
+
+			require.NoError(t, clusterMetadatas.update(clusterId, func(md *clusterMetadata) error {
+				md.NodePools = []*NodePool{{Name: "system", MachineType: "t3.large", Replicas: 3}}
+				return nil
+			}))
+
+			tc.buildStubs(mockArgoClient, mockClusterInfoClient)
+
+			s := server{}
+			res, err := s.RemoveNodePool(ctx, tc.in)
+			tc.checkResponse(tc.in, res, err)
+		})
+	}
+}
+
+func TestRetryCluster(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+	cspId := uuid.New().String()
+	workflowId := randomString("workflowName")
+	createParameters := []string{"contract_id=" + helper.GenerateContractId(), "cluster_id=" + clusterId}
+
+	clusterWithStatus := func(status pb.ClusterStatus) *pb.GetClusterResponse {
+		return &pb.GetClusterResponse{
+			Code: pb.Code_OK_UNSPECIFIED,
+			Cluster: &pb.Cluster{
+				Id:         clusterId,
+				CspId:      cspId,
+				Status:     status,
+				WorkflowId: workflowId,
+			},
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		in         *RetryClusterRequest
+		operations []Operation
+		buildStubs func(mockArgoClient *mockargo.MockClient,
+			mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+			mockClusterInfoClient *mocktks.MockClusterInfoServiceClient)
+		checkResponse func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error)
+	}{
+		{
+			name:       "OK",
+			in:         &RetryClusterRequest{ClusterId: clusterId},
+			operations: []Operation{{Type: operationCreateCluster, Phase: operationPhaseFailed}},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_ERROR), nil)
+
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), &pb.IDRequest{Id: cspId}).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, CspType: pb.CspType_AWS}, nil)
+
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("create-tks-usercluster", "argo", gomock.Any()).Times(1).
+					DoAndReturn(func(workflow string, namespace string, opts argowf.SubmitOptions) (string, error) {
+						require.Equal(t, createParameters, opts.Parameters)
+						return randomString("workflowName"), nil
+					})
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_INSTALLING, in.GetStatus())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+				require.Empty(t, controller.retried)
+			},
+		},
+		{
+			name:       "OK_FROM_FAILED_STEP",
+			in:         &RetryClusterRequest{ClusterId: clusterId, FromFailedStep: true},
+			operations: []Operation{{Type: operationImportCluster, Phase: operationPhaseFailed}},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_ERROR), nil)
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_INSTALLING, in.GetStatus())
+						require.Equal(t, workflowId, in.GetWorkflowId())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+				require.Equal(t, []string{workflowId}, controller.retried)
+			},
+		},
+		{
+			name: "INVALID_ARGUMENT_CLUSTER_ID",
+			in:   &RetryClusterRequest{ClusterId: "THIS_IS_INVALID_ID"},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+			},
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name:       "CLUSTER_STATUS_IS_NOT_ERROR",
+			in:         &RetryClusterRequest{ClusterId: clusterId},
+			operations: []Operation{{Type: operationCreateCluster, Phase: operationPhaseFailed}},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_RUNNING), nil)
+			},
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name: "LAST_OPERATION_IS_NOT_CREATION",
+			in:   &RetryClusterRequest{ClusterId: clusterId},
+			operations: []Operation{
+				{Type: operationCreateCluster, Phase: operationPhaseFailed},
+				{Type: operationDeleteCluster, Phase: operationPhaseFailed},
+			},
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
 				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_ERROR), nil)
 			},
-			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
 				require.Error(t, err)
-				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+				require.Equal(t, pb.Code_FAILED_PRECONDITION, res.Code)
 			},
 		},
 		{
-			name: "INVALID_ARGUMENT_NO_NAME",
-			in: &pb.InstallAppGroupsRequest{
-				AppGroups: []*pb.AppGroup{
-					{
-						ClusterId:    helper.GenerateClusterId(),
-						AppGroupName: "",
-					},
-				},
+			name: "LAST_OPERATION_IS_FAILED_UPGRADE",
+			in:   &RetryClusterRequest{ClusterId: clusterId},
+			operations: []Operation{
+				{Type: operationCreateCluster, Phase: operationPhaseSucceeded},
+				{Type: operationUpgradeCluster, Phase: operationPhaseFailed},
 			},
 			buildStubs: func(mockArgoClient *mockargo.MockClient,
-				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
 				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+					Return(clusterWithStatus(pb.ClusterStatus_ERROR), nil)
 			},
-			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
 				require.Error(t, err)
-				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+				require.Equal(t, pb.Code_FAILED_PRECONDITION, res.Code)
+				require.Empty(t, controller.retried)
 			},
 		},
 		{
-			name: "NOT_EXIST_CLUSTER_ID",
-			in:   installAppGroupsRequest,
+			name:       "CREATION_DID_NOT_FAIL",
+			in:         &RetryClusterRequest{ClusterId: clusterId},
+			operations: []Operation{{Type: operationCreateCluster, Phase: operationPhaseSucceeded}},
 			buildStubs: func(mockArgoClient *mockargo.MockClient,
-				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
 				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
 				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.GetClusterResponse{}, errors.New("NOT_EXIST_CLUSTER_ID"))
+					Return(clusterWithStatus(pb.ClusterStatus_ERROR), nil)
 			},
-			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
-				require.NoError(t, err)
-				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.True(t, len(installAppGroupsRequest.AppGroups) > len(res.Ids))
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_FAILED_PRECONDITION, res.Code)
 			},
 		},
 		{
-			name: "FAILED_TO_CREATE_APPGROUP",
-			in:   installAppGroupsRequest,
+			name:       "OK_IMPORTED_CLUSTER",
+			in:         &RetryClusterRequest{ClusterId: clusterId},
+			operations: []Operation{{Type: operationImportCluster, Phase: operationPhaseFailed}},
 			buildStubs: func(mockArgoClient *mockargo.MockClient,
-				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
 				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
 				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.GetClusterResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-						}, nil)
+					Return(clusterWithStatus(pb.ClusterStatus_ERROR), nil)
 
-				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
-					Return(
-						&pb.GetAppGroupsResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-						}, errors.New("NOT_EXISTED_APPGROUPS"))
+				require.NoError(t, secrets.put(context.Background(), kubeconfigSecretName(clusterId),
+					map[string][]byte{kubeconfigSecretKey: []byte("kubeconfig")}))
 
-				mockAppInfoClient.EXPECT().CreateAppGroup(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.IDResponse{}, errors.New("Failed to create appgroup"))
+				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("import-tks-usercluster", gomock.Any(), gomock.Any()).Times(1).
+					Return(randomString("workflowName"), nil)
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
 			},
-			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.NoError(t, secrets.delete(context.Background(), kubeconfigSecretName(clusterId)))
 				require.NoError(t, err)
-				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.True(t, len(installAppGroupsRequest.AppGroups) > len(res.Ids))
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
 			},
 		},
 		{
-			name: "SERVICEMESH_CALL_WORKFLOW",
-			in: &pb.InstallAppGroupsRequest{
-				AppGroups: []*pb.AppGroup{
-					{
-						AppGroupId:    helper.GenerateApplicaionGroupId(),
-						AppGroupName:  randomString("APPGROUP"),
-						Type:          pb.AppGroupType_SERVICE_MESH,
-						ClusterId:     helper.GenerateClusterId(),
-						Status:        pb.AppGroupStatus_APP_GROUP_UNSPECIFIED,
-						ExternalLabel: randomString("EXTERNAL_LABEL"),
-					},
-				},
-			},
+			name:       "IMPORTED_CLUSTER_WITHOUT_SECRET",
+			in:         &RetryClusterRequest{ClusterId: clusterId},
+			operations: []Operation{{Type: operationImportCluster, Phase: operationPhaseFailed}},
 			buildStubs: func(mockArgoClient *mockargo.MockClient,
-				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
 				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
 				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.GetClusterResponse{}, nil)
-
-				mockAppInfoClient.EXPECT().CreateAppGroup(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.IDResponse{Id: createdAppGroupId}, nil)
-
-				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, errors.New("NOT_EXISTED_APPGROUPS"))
-
-				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
-
-				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("tks-service-mesh", gomock.Any(), gomock.Any()).Times(1).
-					Return(randomString("workflowName"), nil)
+					Return(clusterWithStatus(pb.ClusterStatus_ERROR), nil)
 			},
-			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
-				require.NoError(t, err)
-				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.True(t, len(installAppGroupsRequest.AppGroups) == len(res.Ids))
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_FAILED_PRECONDITION, res.Code)
 			},
 		},
 		{
-			name: "FAILED_TO_CALL_WORKFLOW",
-			in:   installAppGroupsRequest,
+			name:       "FAILED_TO_CALL_WORKFLOW",
+			in:         &RetryClusterRequest{ClusterId: clusterId},
+			operations: []Operation{{Type: operationCreateCluster, Phase: operationPhaseFailed}},
 			buildStubs: func(mockArgoClient *mockargo.MockClient,
-				mockAppInfoClient *mocktks.MockAppInfoServiceClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
 				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient) {
 				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.GetClusterResponse{}, nil)
-
-				mockAppInfoClient.EXPECT().CreateAppGroup(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.IDResponse{Id: createdAppGroupId}, nil)
+					Return(clusterWithStatus(pb.ClusterStatus_ERROR), nil)
 
-				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, errors.New("NOT_EXISTED_APPGROUPS"))
+				mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
 
 				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
 					Return("", errors.New("FAILED_TO_CALL_WORKFLOW"))
 			},
-			checkResponse: func(req *pb.InstallAppGroupsRequest, res *pb.IDsResponse, err error) {
-				require.NoError(t, err)
-				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.Equal(t, len(res.Ids), 0)
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INTERNAL, res.Code)
 			},
 		},
 	}
+
 	for i := range testCases {
 		tc := testCases[i]
 
@@ -847,144 +2980,194 @@ func TestInstallAppGroups(t *testing.T) {
 			// mocking and injection
 			mockArgoClient := mockargo.NewMockClient(ctrl)
 			argowfClient = mockArgoClient
+			controller := &fakeWorkflowController{}
+			argowfController = controller
 
-			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
-			appInfoClient = mockAppInfoClient
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
 			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
 			clusterInfoClient = mockClusterInfoClient
 
-			tc.buildStubs(mockArgoClient, mockAppInfoClient, mockClusterInfoClient)
+			operations = newTestOperationStore(t)
+			for _, recorded := range tc.operations {
+				op := recordOperation(ctx, recorded.Type, clusterId, workflowId, createParameters, nil)
+				require.NoError(t, operations.finish(op, recorded.Phase, ""))
+			}
+
+			tc.buildStubs(mockArgoClient, mockCspInfoClient, mockClusterInfoClient)
 
 			s := server{}
-			res, err := s.InstallAppGroups(ctx, tc.in)
-			tc.checkResponse(tc.in, res, err)
+			res, err := s.RetryCluster(ctx, tc.in)
+			tc.checkResponse(controller, res, err)
 		})
 	}
 }
 
-func TestUninstallAppGroups(t *testing.T) {
+func TestCancelOperation(t *testing.T) {
+	clusterId := helper.GenerateClusterId()
+	pendingClusterId := helper.GenerateClusterId()
+	appGroupId := helper.GenerateApplicaionGroupId()
+	workflowId := randomString("workflowName")
+
 	testCases := []struct {
-		name          string
-		in            *pb.UninstallAppGroupsRequest
-		buildStubs    func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient)
-		checkResponse func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error)
+		name       string
+		in         *pb.IDRequest
+		controller *fakeWorkflowController
+		buildStubs func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+			mockAppInfoClient *mocktks.MockAppInfoServiceClient)
+		checkResponse func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error)
 	}{
 		{
-			name: "OK",
-			in: &pb.UninstallAppGroupsRequest{
-				AppGroupIds: []string{createdAppGroupId},
-			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
-				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+			name:       "OK_CLUSTER",
+			in:         &pb.IDRequest{Id: clusterId},
+			controller: &fakeWorkflowController{},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
 					Return(
-						&pb.GetAppGroupResponse{
-							Code:     pb.Code_OK_UNSPECIFIED,
-							Error:    nil,
-							AppGroup: installAppGroupsRequest.GetAppGroups()[0],
+						&pb.GetClusterResponse{
+							Code: pb.Code_OK_UNSPECIFIED,
+							Cluster: &pb.Cluster{
+								Id:         clusterId,
+								Status:     pb.ClusterStatus_INSTALLING,
+								WorkflowId: workflowId,
+							},
 						}, nil)
 
-				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
-
-				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
-					Return(randomString("workflowName"), nil)
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_ERROR, in.GetStatus())
+						require.NotEmpty(t, in.GetStatusDesc())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
 			},
-			checkResponse: func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
 				require.NoError(t, err)
-				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.Equal(t, len(res.Ids), 1)
-				require.Equal(t, createdAppGroupId, res.Ids[0])
-			},
-		},
-		{
-			name: "INVALID_ARGUMENT_APPGROUP_ID",
-			in: &pb.UninstallAppGroupsRequest{
-				AppGroupIds: []string{"THIS_IS_NOT_UUID"},
-			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
-			},
-			checkResponse: func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error) {
-				require.Error(t, err)
-				require.Equal(t, res.Code, pb.Code_INVALID_ARGUMENT)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+				require.Equal(t, []string{workflowId}, controller.terminated)
 			},
 		},
 		{
-			name: "NOT_EXISTED_APPGROUP",
-			in: &pb.UninstallAppGroupsRequest{
-				AppGroupIds: []string{helper.GenerateApplicaionGroupId()},
-			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+			name:       "OK_APPGROUP",
+			in:         &pb.IDRequest{Id: appGroupId},
+			controller: &fakeWorkflowController{},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
 				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
 					Return(
 						&pb.GetAppGroupResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-						}, errors.New("NOT_EXISTED_APPGROUP"))
+							Code: pb.Code_OK_UNSPECIFIED,
+							AppGroup: &pb.AppGroup{
+								AppGroupId: appGroupId,
+								Status:     pb.AppGroupStatus_APP_GROUP_INSTALLING,
+								WorkflowId: workflowId,
+							},
+						}, nil)
+
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateAppGroupStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.AppGroupStatus_APP_GROUP_ERROR, in.GetStatus())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
 			},
-			checkResponse: func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
 				require.NoError(t, err)
-				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.Equal(t, len(res.Ids), 0)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+				require.Equal(t, []string{workflowId}, controller.terminated)
 			},
 		},
 		{
-			name: "FAILED_TO_CALL_WORKFLOW",
-			in: &pb.UninstallAppGroupsRequest{
-				AppGroupIds: []string{createdAppGroupId},
-			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
-				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+			name:       "OK_PENDING_CLUSTER",
+			in:         &pb.IDRequest{Id: pendingClusterId},
+			controller: &fakeWorkflowController{},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
 					Return(
-						&pb.GetAppGroupResponse{
-							Code:     pb.Code_OK_UNSPECIFIED,
-							Error:    nil,
-							AppGroup: installAppGroupsRequest.GetAppGroups()[0],
+						&pb.GetClusterResponse{
+							Code: pb.Code_OK_UNSPECIFIED,
+							Cluster: &pb.Cluster{
+								Id:     pendingClusterId,
+								Status: pb.ClusterStatus_DELETING,
+							},
 						}, nil)
 
-				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
-					Return("", errors.New("FAILED_TO_CALL_WORKFLOW"))
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_ERROR, in.GetStatus())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
 			},
-			checkResponse: func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error) {
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
 				require.NoError(t, err)
-				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.Equal(t, len(res.Ids), 0)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+				require.Empty(t, controller.terminated)
+
+				op, err := operations.last(pendingClusterId, operationDeleteCluster)
+				require.NoError(t, err)
+				require.Equal(t, operationPhaseCancelled, op.Phase)
+				require.Nil(t, op.Pending)
 			},
 		},
 		{
-			name: "PARTIALLY_SUCCESS",
-			in: &pb.UninstallAppGroupsRequest{
-				AppGroupIds: []string{createdAppGroupId, helper.GenerateApplicaionGroupId()},
+			name:       "INVALID_ARGUMENT_ID",
+			in:         &pb.IDRequest{Id: "THIS_IS_INVALID_ID"},
+			controller: &fakeWorkflowController{},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
 			},
-			buildStubs: func(mockArgoClient *mockargo.MockClient, mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
-				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), &pb.GetAppGroupRequest{AppGroupId: createdAppGroupId}).Times(1).
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name:       "CLUSTER_HAS_NO_OPERATION_IN_PROGRESS",
+			in:         &pb.IDRequest{Id: clusterId},
+			controller: &fakeWorkflowController{},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
 					Return(
-						&pb.GetAppGroupResponse{
-							Code:     pb.Code_OK_UNSPECIFIED,
-							Error:    nil,
-							AppGroup: installAppGroupsRequest.GetAppGroups()[0],
+						&pb.GetClusterResponse{
+							Code: pb.Code_OK_UNSPECIFIED,
+							Cluster: &pb.Cluster{
+								Id:         clusterId,
+								Status:     pb.ClusterStatus_RUNNING,
+								WorkflowId: workflowId,
+							},
 						}, nil)
-
-				mockAppInfoClient.EXPECT().GetAppGroup(gomock.Any(), gomock.Any()).Times(1).
+			},
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_FAILED_PRECONDITION, res.Code)
+				require.Empty(t, controller.terminated)
+			},
+		},
+		{
+			name:       "FAILED_TO_TERMINATE_WORKFLOW",
+			in:         &pb.IDRequest{Id: clusterId},
+			controller: &fakeWorkflowController{err: errors.New("FAILED_TO_TERMINATE_WORKFLOW")},
+			buildStubs: func(mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
 					Return(
-						&pb.GetAppGroupResponse{
-							Code:  pb.Code_OK_UNSPECIFIED,
-							Error: nil,
-						}, errors.New("NOT_EXISTED_APPGROUP"))
-
-				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
-					Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED, Error: nil}, nil)
-
-				mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
-					Return(randomString("workflowName"), nil)
+						&pb.GetClusterResponse{
+							Code: pb.Code_OK_UNSPECIFIED,
+							Cluster: &pb.Cluster{
+								Id:         clusterId,
+								Status:     pb.ClusterStatus_DELETING,
+								WorkflowId: workflowId,
+							},
+						}, nil)
 			},
-			checkResponse: func(req *pb.UninstallAppGroupsRequest, res *pb.IDsResponse, err error) {
-				require.NoError(t, err)
-				require.Equal(t, res.Code, pb.Code_OK_UNSPECIFIED)
-				require.Equal(t, len(res.Ids), 1)
-				require.Equal(t, createdAppGroupId, res.Ids[0])
+			checkResponse: func(controller *fakeWorkflowController, res *pb.SimpleResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INTERNAL, res.Code)
 			},
 		},
 	}
+
 	for i := range testCases {
 		tc := testCases[i]
 
@@ -996,24 +3179,30 @@ func TestUninstallAppGroups(t *testing.T) {
 			defer ctrl.Finish()
 
 			// mocking and injection
-			mockArgoClient := mockargo.NewMockClient(ctrl)
-			argowfClient = mockArgoClient
+			argowfController = tc.controller
 
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
 			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
 			appInfoClient = mockAppInfoClient
 
-			tc.buildStubs(mockArgoClient, mockAppInfoClient)
+			tc.buildStubs(mockClusterInfoClient, mockAppInfoClient)
+
+			// The cluster removal of a cascade delete waits for the app groups without a workflow.
+			operations = newTestOperationStore(t)
+			recordPendingOperation(ctx, operationDeleteCluster, pendingClusterId, nil, &pendingWorkflow{
+				Template:  "tks-remove-usercluster",
+				Workflows: []string{randomString("workflowName")},
+				Deadline:  time.Now().Add(time.Hour),
+			})
 
 			s := server{}
-			res, err := s.UninstallAppGroups(ctx, tc.in)
-			tc.checkResponse(tc.in, res, err)
+			res, err := s.CancelOperation(ctx, tc.in)
+			tc.checkResponse(tc.controller, res, err)
 		})
 	}
-
 }
 
-// Helpers
-
 func randomString(prefix string) string {
 	s := rand.NewSource(time.Now().UnixNano())
 	r := rand.New(s)
@@ -1029,7 +3218,7 @@ func randomCreateClusterRequest() *pb.CreateClusterRequest {
 			SshKeyName:      randomString("SSHKEYNAME"),
 			Region:          "ap-northeast-2",
 			NumOfAz:         3,
-			MachineType:     randomString("MACHINETYPE"),
+			MachineType:     "t3.large",
 			MachineReplicas: 3,
 		},
 		Creator:     uuid.New().String(),
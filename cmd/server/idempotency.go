@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/openinfradev/tks-common/pkg/log"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// idempotencyKeyHeader is an optional request header. A retried request with the same key
+// returns the result of the original request instead of provisioning again.
+// The keys are kept in the local store of the replica, so a retry is deduplicated
+// only when it reaches the replica which handled the original request.
+const idempotencyKeyHeader = "x-idempotency-key"
+
+// fingerprintHeaders are the request headers which change what CreateCluster and ImportCluster do,
+// so they belong to the request of an idempotency key as well as the message.
+var fingerprintHeaders = []string{
+	clusterLabelsHeader,
+	nodePoolsHeader,
+	sizePerAzHeader,
+	cspIdHeader,
+}
+
+const idempotencyBucket = "idempotency"
+
+var (
+	errIdempotencyKeyInProgress = errors.New("a request with the idempotency key is in progress")
+	errIdempotencyKeyReused     = errors.New("the idempotency key was used for a different request")
+)
+
+type idempotencyRecord struct {
+	Method      string    `json:"method"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	ClusterId   string    `json:"clusterId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// idempotencyStore remembers idempotency keys and the cluster created for them during the window.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	store   *fileStore
+	window  time.Duration
+	pending map[string]bool
+}
+
+func newIdempotencyStore(store *fileStore, window time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		store:   store,
+		window:  window,
+		pending: map[string]bool{},
+	}
+}
+
+// getIdempotencyKey returns the idempotency key of the incoming request, if any.
+func getIdempotencyKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(idempotencyKeyHeader)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// requestFingerprint returns a digest of the request, to detect a key reused for a different request.
+// The headers of fingerprintHeaders are added only when they are given,
+// so a request without them has the same digest as the message alone.
+func requestFingerprint(ctx context.Context, in proto.Message) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(in)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(data)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, header := range fingerprintHeaders {
+			if values := md.Get(header); len(values) > 0 {
+				h.Write([]byte("\n" + header + "=" + strings.Join(values, "\n")))
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func idempotencyStoreKey(method string, key string) string {
+	sum := sha256.Sum256([]byte(method + "/" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// begin looks up the key. It returns the original cluster ID if the same request was already handled
+// within the window. Otherwise the key is reserved until end is called.
+func (s *idempotencyStore) begin(method string, key string, fingerprint string) (clusterId string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	storeKey := idempotencyStoreKey(method, key)
+	if s.pending[storeKey] {
+		return "", fmt.Errorf("%w : %s", errIdempotencyKeyInProgress, key)
+	}
+
+	record := &idempotencyRecord{}
+	found, err := s.store.get(idempotencyBucket, storeKey, record)
+	if err != nil {
+		return "", err
+	}
+	if found && time.Since(record.CreatedAt) < s.window {
+		if record.Fingerprint != fingerprint {
+			return "", fmt.Errorf("%w : %s", errIdempotencyKeyReused, key)
+		}
+		return record.ClusterId, nil
+	}
+
+	s.pending[storeKey] = true
+	return "", nil
+}
+
+// end releases the key reserved by begin. The key is remembered only when the cluster was created.
+func (s *idempotencyStore) end(method string, key string, fingerprint string, clusterId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	storeKey := idempotencyStoreKey(method, key)
+	delete(s.pending, storeKey)
+	if clusterId == "" {
+		return
+	}
+
+	if err := s.store.put(idempotencyBucket, storeKey, &idempotencyRecord{
+		Method:      method,
+		Key:         key,
+		Fingerprint: fingerprint,
+		ClusterId:   clusterId,
+		CreatedAt:   time.Now(),
+	}); err != nil {
+		log.Error("Failed to store idempotency key. err : ", err)
+	}
+}
+
+// purge removes the keys whose window has passed.
+func (s *idempotencyStore) purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := []string{}
+	err := s.store.list(idempotencyBucket, func(key string, data []byte) error {
+		record := &idempotencyRecord{}
+		if err := json.Unmarshal(data, record); err != nil || time.Since(record.CreatedAt) >= s.window {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("Failed to list idempotency keys. err : ", err)
+		return
+	}
+	for _, key := range expired {
+		if err := s.store.delete(idempotencyBucket, key); err != nil {
+			log.Error("Failed to delete idempotency key. err : ", err)
+		}
+	}
+}
+
+// withIdempotencyKey runs the create request once per idempotency key.
+// Requests without the key header are handled as they are.
+func withIdempotencyKey(ctx context.Context, method string, in proto.Message, create func() (*pb.IDResponse, error)) (*pb.IDResponse, error) {
+	key := getIdempotencyKey(ctx)
+	if key == "" {
+		return create()
+	}
+
+	fingerprint, err := requestFingerprint(ctx, in)
+	if err != nil {
+		log.Error("Failed to make request fingerprint. err : ", err)
+		return &pb.IDResponse{
+			Code: pb.Code_INTERNAL,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+
+	clusterId, err := idempotencyKeys.begin(method, key, fingerprint)
+	if err != nil {
+		code := pb.Code_INTERNAL
+		if errors.Is(err, errIdempotencyKeyInProgress) {
+			code = pb.Code_ABORTED
+		} else if errors.Is(err, errIdempotencyKeyReused) {
+			code = pb.Code_INVALID_ARGUMENT
+		}
+		log.Error("Failed to check idempotency key. err : ", err)
+		return &pb.IDResponse{
+			Code: code,
+			Error: &pb.Error{
+				Msg: fmt.Sprint(err),
+			},
+		}, err
+	}
+	if clusterId != "" {
+		log.Info(fmt.Sprintf("Request '%s' was already handled for idempotency key %s. clusterId : %s", method, key, clusterId))
+		return &pb.IDResponse{
+			Code:  pb.Code_OK_UNSPECIFIED,
+			Error: nil,
+			Id:    clusterId,
+		}, nil
+	}
+
+	res, err := create()
+	createdId := ""
+	if err == nil && res.GetCode() == pb.Code_OK_UNSPECIFIED {
+		createdId = res.GetId()
+	}
+	idempotencyKeys.end(method, key, fingerprint, createdId)
+	return res, err
+}
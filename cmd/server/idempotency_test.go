@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+
+	mockargo "github.com/openinfradev/tks-common/pkg/argowf/mock"
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+	mocktks "github.com/openinfradev/tks-proto/tks_pb/mock"
+)
+
+func TestCreateClusterIdempotencyKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockArgoClient := mockargo.NewMockClient(ctrl)
+	argowfClient = mockArgoClient
+	mockContractClient := mocktks.NewMockContractServiceClient(ctrl)
+	contractClient = mockContractClient
+	mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+	cspInfoClient = mockCspInfoClient
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+
+	store, err := newFileStore(t.TempDir())
+	require.NoError(t, err)
+	idempotencyKeys = newIdempotencyStore(store, time.Hour)
+
+	req := randomCreateClusterRequest()
+	clusterId := helper.GenerateClusterId()
+
+	// The cluster is provisioned only once.
+	mockContractClient.EXPECT().GetContract(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.GetContractResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+	mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, ContractId: req.ContractId}, nil)
+	mockClusterInfoClient.EXPECT().AddClusterInfo(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.IDResponse{Code: pb.Code_OK_UNSPECIFIED, Id: clusterId}, nil)
+	mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+		Return(randomString("workflowName"), nil)
+	mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+	key := randomString("KEY")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyKeyHeader, key))
+	s := server{}
+
+	res, err := s.CreateCluster(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, clusterId, res.Id)
+
+	// retried request
+	res, err = s.CreateCluster(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+	require.Equal(t, clusterId, res.Id)
+
+	// different request with the same key
+	res, err = s.CreateCluster(ctx, randomCreateClusterRequest())
+	require.Error(t, err)
+	require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+
+	// same request with different headers
+	for _, header := range fingerprintHeaders {
+		headerCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyKeyHeader, key, header, "{}"))
+		res, err = s.CreateCluster(headerCtx, req)
+		require.Error(t, err, header)
+		require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code, header)
+	}
+}
+
+func TestRequestFingerprint(t *testing.T) {
+	req := randomCreateClusterRequest()
+
+	fingerprint, err := requestFingerprint(context.Background(), req)
+	require.NoError(t, err)
+
+	// Other headers are not a part of the request.
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyKeyHeader, "KEY", confirmClusterNameHeader, "NAME"))
+	other, err := requestFingerprint(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, fingerprint, other)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(clusterLabelsHeader, `{"team": "a"}`))
+	labelled, err := requestFingerprint(ctx, req)
+	require.NoError(t, err)
+	require.NotEqual(t, fingerprint, labelled)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(clusterLabelsHeader, `{"team": "b"}`))
+	relabelled, err := requestFingerprint(ctx, req)
+	require.NoError(t, err)
+	require.NotEqual(t, labelled, relabelled)
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	require.NoError(t, err)
+	s := newIdempotencyStore(store, time.Hour)
+
+	clusterId, err := s.begin("CreateCluster", "KEY", "FINGERPRINT")
+	require.NoError(t, err)
+	require.Empty(t, clusterId)
+
+	// in progress
+	_, err = s.begin("CreateCluster", "KEY", "FINGERPRINT")
+	require.ErrorIs(t, err, errIdempotencyKeyInProgress)
+
+	// keys are per method
+	clusterId, err = s.begin("ImportCluster", "KEY", "FINGERPRINT")
+	require.NoError(t, err)
+	require.Empty(t, clusterId)
+	s.end("ImportCluster", "KEY", "FINGERPRINT", "")
+
+	// failed request is not remembered
+	s.end("CreateCluster", "KEY", "FINGERPRINT", "")
+	clusterId, err = s.begin("CreateCluster", "KEY", "FINGERPRINT")
+	require.NoError(t, err)
+	require.Empty(t, clusterId)
+
+	s.end("CreateCluster", "KEY", "FINGERPRINT", "CLUSTER_ID")
+	clusterId, err = s.begin("CreateCluster", "KEY", "FINGERPRINT")
+	require.NoError(t, err)
+	require.Equal(t, "CLUSTER_ID", clusterId)
+
+	// expired key
+	s.window = 0
+	clusterId, err = s.begin("CreateCluster", "KEY", "FINGERPRINT")
+	require.NoError(t, err)
+	require.Empty(t, clusterId)
+
+	s.purge()
+	found, err := store.get(idempotencyBucket, idempotencyStoreKey("CreateCluster", "KEY"), &idempotencyRecord{})
+	require.NoError(t, err)
+	require.False(t, found)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// instanceCatalog is a catalog of machine types per CSP, loaded from a JSON file.
+// It is keyed on the name of the CSP provider. eg) aws
+type instanceCatalog struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	csps    map[string]map[string]*instanceType
+}
+
+type instanceType struct {
+	Name         string  `json:"name"`
+	Vcpu         int     `json:"vcpu"`
+	MemoryGiB    float64 `json:"memoryGiB"`
+	Architecture string  `json:"architecture"`
+	// Regions is a list of regions where the type is available. Empty means all regions.
+	Regions []string `json:"regions,omitempty"`
+}
+
+func newInstanceCatalog(path string) (*instanceCatalog, error) {
+	c := &instanceCatalog{path: path}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *instanceCatalog) load() error {
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string][]*instanceType{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse instance catalog %s : %s", c.path, err)
+	}
+
+	csps := map[string]map[string]*instanceType{}
+	for csp, types := range raw {
+		csps[csp] = map[string]*instanceType{}
+		for _, t := range types {
+			if t == nil {
+				return fmt.Errorf("invalid instance type null for %s", csp)
+			}
+			if t.Name == "" || t.Vcpu <= 0 || t.MemoryGiB <= 0 {
+				return fmt.Errorf("invalid instance type %+v for %s", t, csp)
+			}
+			if _, ok := csps[csp][t.Name]; ok {
+				return fmt.Errorf("duplicated instance type %s for %s", t.Name, csp)
+			}
+			csps[csp][t.Name] = t
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.csps = csps
+	c.modTime = fi.ModTime()
+	return nil
+}
+
+// watch reloads the catalog whenever the file is modified.
+// The previous catalog is kept if the modified file is invalid.
+func (c *instanceCatalog) watch(ctx context.Context, interval time.Duration) {
+	watchFile(ctx, c.path, interval, c.lastModified, c.load)
+}
+
+func (c *instanceCatalog) lastModified() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.modTime
+}
+
+// validate checks if the machine type exists and is available in the region.
+func (c *instanceCatalog) validate(csp string, region string, machineType string) (*instanceType, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	t, ok := c.csps[csp][machineType]
+	if !ok {
+		return nil, fmt.Errorf("Unknown machineType %s for %s", machineType, csp)
+	}
+	if len(t.Regions) == 0 {
+		return t, nil
+	}
+	for _, r := range t.Regions {
+		if r == region {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid machineType %s: not available in region %s", machineType, region)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instance-types.json")
+	writeTestFile(t, path, `{
+  "aws": [
+    {"name": "t3.large", "vcpu": 2, "memoryGiB": 8, "architecture": "x86_64"},
+    {"name": "m6g.large", "vcpu": 2, "memoryGiB": 8, "architecture": "arm64", "regions": ["us-east-1"]}
+  ]
+}`)
+
+	c, err := newInstanceCatalog(path)
+	require.NoError(t, err)
+
+	instance, err := c.validate("aws", "ap-northeast-2", "t3.large")
+	require.NoError(t, err)
+	require.Equal(t, 2, instance.Vcpu)
+
+	_, err = c.validate("aws", "us-east-1", "m6g.large")
+	require.NoError(t, err)
+
+	_, err = c.validate("aws", "ap-northeast-2", "m6g.large")
+	require.Error(t, err)
+
+	_, err = c.validate("aws", "ap-northeast-2", "t3.lrage")
+	require.Error(t, err)
+
+	_, err = c.validate("gcp", "us-central1", "t3.large")
+	require.Error(t, err)
+
+	writeTestFile(t, path, `{"aws": [{"name": "t3.large"}]}`)
+	_, err = newInstanceCatalog(path)
+	require.Error(t, err)
+
+	writeTestFile(t, path, `{"aws": [null]}`)
+	_, err = newInstanceCatalog(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// kubeconfig is the part of a kubeconfig file which is needed to reach the API server.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			ClientCertificateData string      `yaml:"client-certificate-data"`
+			ClientKeyData         string      `yaml:"client-key-data"`
+			Token                 string      `yaml:"token"`
+			Username              string      `yaml:"username"`
+			Password              string      `yaml:"password"`
+			Exec                  interface{} `yaml:"exec"`
+			AuthProvider          interface{} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// kubeconfigTarget is the API server and the credentials of the current context of a kubeconfig.
+type kubeconfigTarget struct {
+	Server                string
+	CAData                []byte
+	InsecureSkipTLSVerify bool
+	ClientCertData        []byte
+	ClientKeyData         []byte
+	Token                 string
+	Username              string
+	Password              string
+}
+
+// parseKubeconfig resolves the current context of the kubeconfig.
+// The context must have a cluster with a server URL and a user with credentials.
+// Credentials in files are not accepted, since the files are not available to the workflows.
+func parseKubeconfig(data []byte) (*kubeconfigTarget, error) {
+	conf := kubeconfig{}
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig : %s", err)
+	}
+	if conf.CurrentContext == "" {
+		return nil, errors.New("invalid kubeconfig : no current-context")
+	}
+
+	clusterName, userName := "", ""
+	found := false
+	for _, c := range conf.Contexts {
+		if c.Name == conf.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("invalid kubeconfig : context %s not found", conf.CurrentContext)
+	}
+
+	target := &kubeconfigTarget{}
+	found = false
+	for _, c := range conf.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		target.Server = c.Cluster.Server
+		target.InsecureSkipTLSVerify = c.Cluster.InsecureSkipTLSVerify
+		if c.Cluster.CertificateAuthorityData != "" {
+			ca, err := base64.StdEncoding.DecodeString(c.Cluster.CertificateAuthorityData)
+			if err != nil {
+				return nil, fmt.Errorf("invalid kubeconfig : certificate-authority-data of cluster %s : %s", clusterName, err)
+			}
+			target.CAData = ca
+		}
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("invalid kubeconfig : cluster %s not found", clusterName)
+	}
+	u, err := url.Parse(target.Server)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid kubeconfig : invalid server URL %s of cluster %s", target.Server, clusterName)
+	}
+
+	found = false
+	for _, c := range conf.Users {
+		if c.Name != userName {
+			continue
+		}
+		found = true
+		user := c.User
+		if user.ClientCertificateData != "" || user.ClientKeyData != "" {
+			if target.ClientCertData, err = base64.StdEncoding.DecodeString(user.ClientCertificateData); err != nil {
+				return nil, fmt.Errorf("invalid kubeconfig : client-certificate-data of user %s : %s", userName, err)
+			}
+			if target.ClientKeyData, err = base64.StdEncoding.DecodeString(user.ClientKeyData); err != nil {
+				return nil, fmt.Errorf("invalid kubeconfig : client-key-data of user %s : %s", userName, err)
+			}
+			if len(target.ClientCertData) == 0 || len(target.ClientKeyData) == 0 {
+				return nil, fmt.Errorf("invalid kubeconfig : user %s needs both client-certificate-data and client-key-data", userName)
+			}
+		}
+		target.Token = user.Token
+		target.Username, target.Password = user.Username, user.Password
+
+		if len(target.ClientCertData) == 0 && target.Token == "" && target.Username == "" {
+			// Workflows can not run the plugins of exec and auth-provider, so the credentials must be static.
+			if user.Exec != nil || user.AuthProvider != nil {
+				return nil, fmt.Errorf("invalid kubeconfig : user %s has only exec or auth-provider credentials which are not supported", userName)
+			}
+			return nil, fmt.Errorf("invalid kubeconfig : user %s has no credentials", userName)
+		}
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("invalid kubeconfig : user %s not found", userName)
+	}
+	return target, nil
+}
+
+// probeKubeconfig calls the /version endpoint of the API server with the credentials of the kubeconfig.
+func probeKubeconfig(ctx context.Context, target *kubeconfigTarget, timeout time.Duration) error {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: target.InsecureSkipTLSVerify,
+	}
+	if len(target.CAData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(target.CAData) {
+			return errors.New("invalid kubeconfig : certificate-authority-data has no certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(target.ClientCertData) > 0 {
+		cert, err := tls.X509KeyPair(target.ClientCertData, target.ClientKeyData)
+		if err != nil {
+			return fmt.Errorf("invalid kubeconfig : invalid client certificate : %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.Server+"/version", nil)
+	if err != nil {
+		return err
+	}
+	if target.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+target.Token)
+	} else if target.Username != "" {
+		req.SetBasicAuth(target.Username, target.Password)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("API server %s is unreachable : %s", target.Server, err)
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("API server %s returned %d for /version : %s", target.Server, res.StatusCode, string(body))
+	}
+	version := struct {
+		GitVersion string `json:"gitVersion"`
+	}{}
+	if err := json.Unmarshal(body, &version); err != nil || version.GitVersion == "" {
+		return fmt.Errorf("API server %s returned an invalid /version response", target.Server)
+	}
+	return nil
+}
+
+// caFingerprint returns the SHA-256 fingerprint of the first certificate of the CA data.
+// It returns an empty string if the kubeconfig has no CA data.
+func (t *kubeconfigTarget) caFingerprint() (string, error) {
+	if len(t.CAData) == 0 {
+		return "", nil
+	}
+	block, _ := pem.Decode(t.CAData)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", errors.New("invalid kubeconfig : certificate-authority-data has no certificate")
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// normalizedServer returns the server URL without the default port and the trailing slash, in lower case.
+func (t *kubeconfigTarget) normalizedServer() string {
+	u, err := url.Parse(t.Server)
+	if err != nil {
+		return t.Server
+	}
+	host, port := u.Hostname(), u.Port()
+	if (u.Scheme == "https" && port == "443") || (u.Scheme == "http" && port == "80") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return strings.ToLower(u.Scheme + "://" + host + strings.TrimSuffix(u.Path, "/"))
+}
+
+// sameCluster checks that the kubeconfig points to the same cluster as the current one.
+// The CA identifies the cluster when both kubeconfigs have it, so that the server URL may change.
+// Otherwise the server URLs must be the same.
+func (t *kubeconfigTarget) sameCluster(current *kubeconfigTarget) error {
+	fingerprint, err := t.caFingerprint()
+	if err != nil {
+		return err
+	}
+	currentFingerprint, err := current.caFingerprint()
+	if err != nil {
+		return fmt.Errorf("the current kubeconfig is invalid : %s", err)
+	}
+	if fingerprint != "" && currentFingerprint != "" {
+		if fingerprint != currentFingerprint {
+			return fmt.Errorf("the CA of the kubeconfig does not match the cluster. fingerprint : %s, expected : %s", fingerprint, currentFingerprint)
+		}
+		return nil
+	}
+	if t.normalizedServer() != current.normalizedServer() {
+		return fmt.Errorf("the server of the kubeconfig does not match the cluster. server : %s, expected : %s", t.Server, current.Server)
+	}
+	return nil
+}
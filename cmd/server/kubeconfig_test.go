@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+	mocktks "github.com/openinfradev/tks-proto/tks_pb/mock"
+)
+
+// testKubeconfig returns a kubeconfig of the server with a bearer token.
+func testKubeconfig(server string, token string) []byte {
+	return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: admin@test
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: admin@test
+  context:
+    cluster: test
+    user: admin
+users:
+- name: admin
+  user:
+    token: %s
+`, server, token))
+}
+
+// testKubeconfigWithCA returns a kubeconfig of the server with the CA and a bearer token.
+func testKubeconfigWithCA(server string, ca []byte, token string) []byte {
+	return []byte(fmt.Sprintf(`current-context: admin@test
+clusters:
+- name: test
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+contexts:
+- name: admin@test
+  context: {cluster: test, user: admin}
+users:
+- name: admin
+  user: {token: %s}
+`, server, base64.StdEncoding.EncodeToString(ca), token))
+}
+
+// testCACertificate returns a self-signed CA certificate in PEM.
+func testCACertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestParseKubeconfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		kubeconfig string
+		valid      bool
+	}{
+		{
+			name:       "TOKEN",
+			kubeconfig: string(testKubeconfig("https://10.0.0.1:6443", "token")),
+			valid:      true,
+		},
+		{
+			name: "CLIENT_CERTIFICATE",
+			kubeconfig: `current-context: c
+clusters:
+- name: test
+  cluster:
+    server: https://10.0.0.1:6443
+    certificate-authority-data: ` + base64.StdEncoding.EncodeToString([]byte("ca")) + `
+contexts:
+- name: c
+  context: {cluster: test, user: admin}
+users:
+- name: admin
+  user:
+    client-certificate-data: ` + base64.StdEncoding.EncodeToString([]byte("cert")) + `
+    client-key-data: ` + base64.StdEncoding.EncodeToString([]byte("key")) + `
+`,
+			valid: true,
+		},
+		{
+			name:       "NOT_YAML",
+			kubeconfig: "{",
+		},
+		{
+			name:       "NO_CURRENT_CONTEXT",
+			kubeconfig: "clusters: []\n",
+		},
+		{
+			name: "CONTEXT_NOT_FOUND",
+			kubeconfig: `current-context: other
+contexts:
+- name: c
+  context: {cluster: test, user: admin}
+`,
+		},
+		{
+			name: "CLUSTER_NOT_FOUND",
+			kubeconfig: `current-context: c
+contexts:
+- name: c
+  context: {cluster: test, user: admin}
+`,
+		},
+		{
+			name: "INVALID_SERVER",
+			kubeconfig: `current-context: c
+clusters:
+- name: test
+  cluster: {server: "10.0.0.1:6443"}
+contexts:
+- name: c
+  context: {cluster: test, user: admin}
+users:
+- name: admin
+  user: {token: token}
+`,
+		},
+		{
+			name: "USER_NOT_FOUND",
+			kubeconfig: `current-context: c
+clusters:
+- name: test
+  cluster: {server: "https://10.0.0.1:6443"}
+contexts:
+- name: c
+  context: {cluster: test, user: admin}
+`,
+		},
+		{
+			name: "NO_CREDENTIALS",
+			kubeconfig: `current-context: c
+clusters:
+- name: test
+  cluster: {server: "https://10.0.0.1:6443"}
+contexts:
+- name: c
+  context: {cluster: test, user: admin}
+users:
+- name: admin
+  user: {}
+`,
+		},
+		{
+			name: "EXEC_ONLY",
+			kubeconfig: `current-context: c
+clusters:
+- name: test
+  cluster: {server: "https://10.0.0.1:6443"}
+contexts:
+- name: c
+  context: {cluster: test, user: admin}
+users:
+- name: admin
+  user:
+    exec: {apiVersion: client.authentication.k8s.io/v1beta1, command: aws}
+`,
+		},
+		{
+			name: "AUTH_PROVIDER_ONLY",
+			kubeconfig: `current-context: c
+clusters:
+- name: test
+  cluster: {server: "https://10.0.0.1:6443"}
+contexts:
+- name: c
+  context: {cluster: test, user: admin}
+users:
+- name: admin
+  user:
+    auth-provider: {name: gcp}
+`,
+		},
+		{
+			name: "CLIENT_KEY_MISSING",
+			kubeconfig: `current-context: c
+clusters:
+- name: test
+  cluster: {server: "https://10.0.0.1:6443"}
+contexts:
+- name: c
+  context: {cluster: test, user: admin}
+users:
+- name: admin
+  user: {client-certificate-data: Y2VydA==}
+`,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			target, err := parseKubeconfig([]byte(tc.kubeconfig))
+			if tc.valid {
+				require.NoError(t, err)
+				require.Equal(t, "https://10.0.0.1:6443", target.Server)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestProbeKubeconfig(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"gitVersion": "v1.22.5"}`))
+	}))
+	defer ts.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	target := &kubeconfigTarget{Server: ts.URL, CAData: ca, Token: "token"}
+	require.NoError(t, probeKubeconfig(context.Background(), target, time.Second))
+
+	target.Token = "invalid"
+	require.Error(t, probeKubeconfig(context.Background(), target, time.Second))
+
+	// The certificate of the server is not trusted without the CA.
+	target = &kubeconfigTarget{Server: ts.URL, Token: "token"}
+	require.Error(t, probeKubeconfig(context.Background(), target, time.Second))
+
+	url := ts.URL
+	ts.Close()
+	target = &kubeconfigTarget{Server: url, CAData: ca, Token: "token"}
+	require.Error(t, probeKubeconfig(context.Background(), target, time.Second))
+}
+
+func TestImportClusterUnreachable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := ts.URL
+	ts.Close()
+
+	kubeconfigProbeTimeout = time.Second
+	defer func() { kubeconfigProbeTimeout = 0 }()
+
+	s := server{}
+	res, err := s.ImportCluster(context.Background(), &pb.ImportClusterRequest{
+		ContractId: helper.GenerateContractId(),
+		Name:       randomString("NAME"),
+		Kubeconfig: testKubeconfig(url, "token"),
+	})
+	require.Error(t, err)
+	require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+}
+
+func TestKubeconfigSameCluster(t *testing.T) {
+	ca := testCACertificate(t)
+	otherCa := testCACertificate(t)
+
+	testCases := []struct {
+		name    string
+		current []byte
+		updated []byte
+		same    bool
+	}{
+		{
+			name:    "SAME_CA",
+			current: testKubeconfigWithCA("https://10.0.0.1:6443", ca, "token"),
+			updated: testKubeconfigWithCA("https://api.example.com:6443", ca, "rotated"),
+			same:    true,
+		},
+		{
+			name:    "OTHER_CA",
+			current: testKubeconfigWithCA("https://10.0.0.1:6443", ca, "token"),
+			updated: testKubeconfigWithCA("https://10.0.0.1:6443", otherCa, "rotated"),
+		},
+		{
+			name:    "SAME_SERVER_WITHOUT_CA",
+			current: testKubeconfig("https://API.example.com:443/", "token"),
+			updated: testKubeconfig("https://api.example.com", "rotated"),
+			same:    true,
+		},
+		{
+			name:    "OTHER_SERVER_WITHOUT_CA",
+			current: testKubeconfig("https://10.0.0.1:6443", "token"),
+			updated: testKubeconfigWithCA("https://10.0.0.2:6443", ca, "rotated"),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			current, err := parseKubeconfig(tc.current)
+			require.NoError(t, err)
+			updated, err := parseKubeconfig(tc.updated)
+			require.NoError(t, err)
+
+			if tc.same {
+				require.NoError(t, updated.sameCluster(current))
+			} else {
+				require.Error(t, updated.sameCluster(current))
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"time"
 
 	"github.com/openinfradev/tks-common/pkg/argowf"
 	"github.com/openinfradev/tks-common/pkg/grpc_client"
@@ -16,10 +18,23 @@ type server struct {
 
 var (
 	argowfClient      argowf.Client
+	argowfController  workflowController
 	contractClient    pb.ContractServiceClient
 	cspInfoClient     pb.CspInfoServiceClient
 	clusterInfoClient pb.ClusterInfoServiceClient
 	appInfoClient     pb.AppInfoServiceClient
+
+	cspRegions    *regionCatalog
+	instanceTypes *instanceCatalog
+	k8sVersions   *versionMatrix
+
+	autoscalingPolicies *autoscalingPolicyCatalog
+	appGroupTypes       *appGroupTypeRegistry
+
+	operations       *operationStore
+	idempotencyKeys  *idempotencyStore
+	clusterMetadatas *clusterMetadataStore
+	secrets          secretStore
 )
 
 var (
@@ -38,6 +53,26 @@ var (
 	revision        string
 	gitBaseUrl      string
 	gitAccount      string
+
+	reconcileInterval time.Duration
+	sweepInterval     time.Duration
+	orphanGracePeriod time.Duration
+
+	kubeconfigProbeTimeout time.Duration
+
+	regionCatalogPath     string
+	instanceCatalogPath   string
+	versionMatrixPath     string
+	autoscalingPolicyPath string
+	appGroupTypePath      string
+	catalogReloadInterval time.Duration
+
+	storePath          string
+	idempotencyWindow  time.Duration
+	operationRetention time.Duration
+
+	secretBackend   string
+	secretNamespace string
 )
 
 func init() {
@@ -55,6 +90,21 @@ func init() {
 	flag.StringVar(&revision, "revision", "main", "revision for workflow parameter")
 	flag.StringVar(&gitBaseUrl, "git-base-url", "https://github.com", "git base url")
 	flag.StringVar(&gitAccount, "git-account", "tks-management", "git repository name for workflow parameter")
+	flag.StringVar(&regionCatalogPath, "region-catalog-path", "./region-catalog.json", "path of region catalog file")
+	flag.StringVar(&instanceCatalogPath, "instance-catalog-path", "./instance-types.json", "path of instance type catalog file")
+	flag.StringVar(&versionMatrixPath, "version-matrix-path", "./k8s-versions.json", "path of supported kubernetes version matrix file")
+	flag.StringVar(&autoscalingPolicyPath, "autoscaling-policy-path", "./autoscaling-policies.json", "path of autoscaling policy file")
+	flag.StringVar(&appGroupTypePath, "app-group-type-path", "./app-group-types.json", "path of app group type registry file")
+	flag.DurationVar(&catalogReloadInterval, "catalog-reload-interval", 30*time.Second, "interval for checking catalog files to reload")
+	flag.StringVar(&storePath, "store-path", "./data", "path of directory for the local store")
+	flag.DurationVar(&idempotencyWindow, "idempotency-window", 24*time.Hour, "duration to remember idempotency keys of create requests")
+	flag.DurationVar(&operationRetention, "operation-retention", 30*24*time.Hour, "duration to keep finished operation records (0 to keep forever)")
+	flag.StringVar(&secretBackend, "secret-backend", secretBackendAuto, "backend of the secret store for kubeconfigs (auto, kubernetes or file)")
+	flag.StringVar(&secretNamespace, "secret-namespace", "argo", "namespace of kubeconfig secrets, which must be the namespace of workflows")
+	flag.DurationVar(&reconcileInterval, "reconcile-interval", 30*time.Second, "interval for reconciling workflow status (0 to disable)")
+	flag.DurationVar(&sweepInterval, "sweep-interval", 10*time.Minute, "interval for reconciling all clusters and app groups of tks-info")
+	flag.DurationVar(&orphanGracePeriod, "orphan-grace-period", 10*time.Minute, "grace period before a cluster without workflow is marked as ERROR")
+	flag.DurationVar(&kubeconfigProbeTimeout, "kubeconfig-probe-timeout", 0, "timeout for probing the API server of imported kubeconfigs (0 to disable)")
 }
 
 func main() {
@@ -74,14 +124,71 @@ func main() {
 	log.Info("revision : ", revision)
 	log.Info("gitBaseUrl : ", gitBaseUrl)
 	log.Info("gitAccount : ", gitAccount)
+	log.Info("reconcileInterval : ", reconcileInterval)
+	log.Info("sweepInterval : ", sweepInterval)
+	log.Info("orphanGracePeriod : ", orphanGracePeriod)
+	log.Info("kubeconfigProbeTimeout : ", kubeconfigProbeTimeout)
+	log.Info("regionCatalogPath : ", regionCatalogPath)
+	log.Info("instanceCatalogPath : ", instanceCatalogPath)
+	log.Info("versionMatrixPath : ", versionMatrixPath)
+	log.Info("autoscalingPolicyPath : ", autoscalingPolicyPath)
+	log.Info("appGroupTypePath : ", appGroupTypePath)
+	log.Info("catalogReloadInterval : ", catalogReloadInterval)
+	log.Info("storePath : ", storePath)
+	log.Info("idempotencyWindow : ", idempotencyWindow)
+	log.Info("operationRetention : ", operationRetention)
+	log.Info("secretBackend : ", secretBackend)
+	log.Info("secretNamespace : ", secretNamespace)
 	log.Info("****************** ")
 
-	// initialize clients
+	// load catalogs
 	var err error
+	if cspRegions, err = newRegionCatalog(regionCatalogPath); err != nil {
+		log.Fatal("failed to load region catalog : ", err)
+	}
+	go cspRegions.watch(context.Background(), catalogReloadInterval)
+
+	if instanceTypes, err = newInstanceCatalog(instanceCatalogPath); err != nil {
+		log.Fatal("failed to load instance catalog : ", err)
+	}
+	go instanceTypes.watch(context.Background(), catalogReloadInterval)
+
+	if k8sVersions, err = newVersionMatrix(versionMatrixPath); err != nil {
+		log.Fatal("failed to load version matrix : ", err)
+	}
+	go k8sVersions.watch(context.Background(), catalogReloadInterval)
+
+	if autoscalingPolicies, err = newAutoscalingPolicyCatalog(autoscalingPolicyPath); err != nil {
+		log.Fatal("failed to load autoscaling policy : ", err)
+	}
+	go autoscalingPolicies.watch(context.Background(), catalogReloadInterval)
+
+	if appGroupTypes, err = newAppGroupTypeRegistry(appGroupTypePath); err != nil {
+		log.Fatal("failed to load app group type registry : ", err)
+	}
+	go appGroupTypes.watch(context.Background(), catalogReloadInterval)
+
+	// open local store
+	store, err := newFileStore(storePath)
+	if err != nil {
+		log.Fatal("failed to open local store : ", err)
+	}
+	if operations, err = newOperationStore(store, operationRetention); err != nil {
+		log.Fatal("failed to load operations : ", err)
+	}
+	idempotencyKeys = newIdempotencyStore(store, idempotencyWindow)
+	clusterMetadatas = newClusterMetadataStore(store)
+
+	if secrets, err = newSecretStore(secretBackend, store, secretNamespace); err != nil {
+		log.Fatal("failed to create secret store : ", err)
+	}
+
+	// initialize clients
 	argowfClient, err = argowf.New(argoAddress, argoPort, false, "")
 	if err != nil {
 		log.Fatal("failed to create argowf client : ", err)
 	}
+	argowfController = newArgoWorkflowController(argoAddress, argoPort)
 
 	if _, contractClient, err = grpc_client.CreateContractClient(contractAddress, contractPort, tlsEnabled, tlsClientCertPath); err != nil {
 		log.Fatal("failed to create contract client : ", err)
@@ -99,6 +206,11 @@ func main() {
 		log.Fatal("failed to create appinfo client : ", err)
 	}
 
+	// start workflow status reconciler
+	if reconcileInterval > 0 {
+		go newReconciler(reconcileInterval, sweepInterval, orphanGracePeriod).run(context.Background())
+	}
+
 	// start server
 	s, conn, err := grpc_server.CreateServer(port, tlsEnabled, tlsCertPath, tlsKeyPath)
 	if err != nil {
@@ -106,6 +218,7 @@ func main() {
 	}
 
 	pb.RegisterClusterLcmServiceServer(s, &server{})
+	registerClusterLcmExtensionServer(s, &server{})
 	if err := s.Serve(conn); err != nil {
 		log.Fatal("failed to serve: ", err)
 	}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// Request and response messages of the LCM APIs which are not defined in tks-proto yet.
+// They follow the style of tks-proto messages and are to be moved there later.
+// Until then, the APIs are served by ClusterLcmExtensionService in JSON. See extension_service.go.
+
+type GetOperationResponse struct {
+	Code      pb.Code
+	Error     *pb.Error
+	Operation *Operation
+}
+
+type ListOperationsRequest struct {
+	// TargetId filters operations by a cluster ID or an app group ID.
+	TargetId string
+	// Type filters operations by the operation type.
+	Type string
+}
+
+type ListOperationsResponse struct {
+	Code       pb.Code
+	Error      *pb.Error
+	Operations []*Operation
+}
+
+type RetryClusterRequest struct {
+	ClusterId string
+	// FromFailedStep resubmits the failed workflow from its failed steps instead of submitting a new workflow.
+	FromFailedStep bool
+}
+
+type UpgradeClusterRequest struct {
+	ClusterId string
+	// Version is the target Kubernetes version. eg) v1.24.10
+	Version string
+}
+
+type UpgradeAppGroupRequest struct {
+	AppGroupId string
+	// Revision is the target revision of the manifests. eg) v1.1.0
+	Revision string
+}
+
+type UpdateClusterCredentialsRequest struct {
+	ClusterId string
+	// Kubeconfig replaces the kubeconfig which the cluster was imported with.
+	Kubeconfig []byte
+}
+
+type NodePoolRequest struct {
+	ClusterId string
+	NodePool  *NodePool
+}
+
+type RemoveNodePoolRequest struct {
+	ClusterId string
+	// Name is the name of the node pool to remove.
+	Name string
+}
+
+// ClusterPlan is what CreateCluster or ImportCluster resolves before it changes anything.
+type ClusterPlan struct {
+	ContractId string
+	CspId      string
+	// CspType is the name of the CSP provider. eg) aws
+	CspType           string
+	Conf              *pb.ClusterConf
+	DesiredSizePerAz  int
+	NodePools         []*NodePool
+	Labels            map[string]string
+	KubernetesVersion string
+	// Workflow is the name of the workflow template to submit.
+	Workflow string
+	// Parameters are the workflow parameters. The cluster ID is shown as <cluster_id>.
+	Parameters []string
+}
+
+type PlanClusterResponse struct {
+	Code  pb.Code
+	Error *pb.Error
+	Plan  *ClusterPlan
+}
+
+// UpdateClusterRequest has the fields to update. Empty fields are not updated.
+type UpdateClusterRequest struct {
+	ClusterId   string
+	Name        string
+	Description string
+	// Labels replace all labels of the cluster. A nil map keeps them, and an empty map removes them.
+	Labels map[string]string
+}
+
+type SetDeletionProtectionRequest struct {
+	ClusterId          string
+	DeletionProtection bool
+}
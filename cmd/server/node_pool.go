@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// nodePoolsHeader is an optional request header of CreateCluster.
+// It carries a JSON array of the node pools to create in addition to the default pool of the cluster conf.
+const nodePoolsHeader = "x-node-pools"
+
+// defaultNodePoolName is the name of the node pool described by pb.ClusterConf.
+// It is scaled by ScaleCluster, not by the node pool API.
+const defaultNodePoolName = "default"
+
+// Node pool actions. The action is passed to the workflow as is.
+const (
+	nodePoolActionAdd    = "add"
+	nodePoolActionUpdate = "update"
+	nodePoolActionRemove = "remove"
+)
+
+var nodePoolOperations = map[string]string{
+	nodePoolActionAdd:    operationAddNodePool,
+	nodePoolActionUpdate: operationUpdateNodePool,
+	nodePoolActionRemove: operationRemoveNodePool,
+}
+
+var (
+	nodePoolNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	labelKeyRegex     = regexp.MustCompile(`^([a-z0-9]([-a-z0-9.]*[a-z0-9])?/)?[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	labelValueRegex   = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+)
+
+var taintEffects = map[string]bool{
+	"NoSchedule":       true,
+	"PreferNoSchedule": true,
+	"NoExecute":        true,
+}
+
+// NodePool is a named group of worker nodes which share a machine type, labels and taints.
+type NodePool struct {
+	Name        string            `json:"name"`
+	MachineType string            `json:"machineType"`
+	Replicas    int               `json:"replicas"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Taints      []NodeTaint       `json:"taints,omitempty"`
+	// MinSizePerAz and MaxSizePerAz are derived from Replicas by validation.
+	MinSizePerAz int `json:"minSizePerAz"`
+	MaxSizePerAz int `json:"maxSizePerAz"`
+}
+
+type NodeTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+// getNodePools returns the node pools of the incoming request header, if any.
+func getNodePools(ctx context.Context) ([]*NodePool, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	values := md.Get(nodePoolsHeader)
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	pools := []*NodePool{}
+	if err := json.Unmarshal([]byte(values[0]), &pools); err != nil {
+		return nil, fmt.Errorf("invalid %s header : %s", nodePoolsHeader, err)
+	}
+	for i, pool := range pools {
+		if pool == nil {
+			return nil, fmt.Errorf("invalid %s header : node pool %d is null", nodePoolsHeader, i)
+		}
+	}
+	return pools, nil
+}
+
+// validateNodePool validates the pool with the same rules as the default pool
+// and derives the sizes per AZ from the replicas.
+func validateNodePool(provider cspProvider, policy autoscalingPolicy, region string, numOfAz int, pool *NodePool) error {
+	if pool == nil {
+		return errors.New("node pool must have value")
+	}
+	if pool.Name == defaultNodePoolName {
+		return fmt.Errorf("node pool name %s is reserved", defaultNodePoolName)
+	}
+	if len(pool.Name) > 63 || !nodePoolNameRegex.MatchString(pool.Name) {
+		return fmt.Errorf("invalid node pool name %s", pool.Name)
+	}
+	if pool.Replicas <= 0 {
+		return fmt.Errorf("replicas of node pool %s must be greater than 0", pool.Name)
+	}
+
+	regionInfo, err := cspRegions.region(provider.Name(), region)
+	if err != nil {
+		return err
+	}
+	if err := validateMachineType(provider, regionInfo, pool.MachineType); err != nil {
+		return err
+	}
+
+	size, err := policy.sizePerAz(nil, pool.Replicas, numOfAz)
+	if err != nil {
+		return fmt.Errorf("node pool %s : %s", pool.Name, err)
+	}
+	pool.MinSizePerAz, pool.MaxSizePerAz = size.MinSizePerAz, size.MaxSizePerAz
+
+	if err := validateLabels(pool.Labels); err != nil {
+		return fmt.Errorf("node pool %s : %s", pool.Name, err)
+	}
+	for _, taint := range pool.Taints {
+		if !labelKeyRegex.MatchString(taint.Key) || !labelValueRegex.MatchString(taint.Value) {
+			return fmt.Errorf("invalid taint %s=%s of node pool %s", taint.Key, taint.Value, pool.Name)
+		}
+		if !taintEffects[taint.Effect] {
+			return fmt.Errorf("invalid taint effect %s of node pool %s", taint.Effect, pool.Name)
+		}
+	}
+	return nil
+}
+
+// validateNodePools validates the pools and checks that the names are unique.
+func validateNodePools(provider cspProvider, policy autoscalingPolicy, region string, numOfAz int, pools []*NodePool) error {
+	names := map[string]bool{}
+	for _, pool := range pools {
+		if err := validateNodePool(provider, policy, region, numOfAz, pool); err != nil {
+			return err
+		}
+		if names[pool.Name] {
+			return fmt.Errorf("duplicated node pool %s", pool.Name)
+		}
+		names[pool.Name] = true
+	}
+	return nil
+}
+
+// findNodePool returns the index of the named pool, or -1.
+func findNodePool(pools []*NodePool, name string) int {
+	for i, pool := range pools {
+		if pool.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// applyNodePoolOperation applies the node pool change of the succeeded operation to the metadata.
+func applyNodePoolOperation(md *clusterMetadata, op *Operation) error {
+	i := findNodePool(md.NodePools, op.parameter("node_pool_name"))
+	if op.Type == operationRemoveNodePool {
+		if i >= 0 {
+			md.NodePools = append(md.NodePools[:i], md.NodePools[i+1:]...)
+		}
+		return nil
+	}
+
+	pool := &NodePool{}
+	if err := json.Unmarshal([]byte(op.parameter("node_pool")), pool); err != nil {
+		return fmt.Errorf("invalid node pool of operation %s : %s", op.OperationId, err)
+	}
+	if i >= 0 {
+		md.NodePools[i] = pool
+	} else {
+		md.NodePools = append(md.NodePools, pool)
+	}
+	return nil
+}
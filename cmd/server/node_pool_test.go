@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/openinfradev/tks-common/pkg/argowf"
+	mockargo "github.com/openinfradev/tks-common/pkg/argowf/mock"
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+	mocktks "github.com/openinfradev/tks-proto/tks_pb/mock"
+)
+
+func TestValidateNodePools(t *testing.T) {
+	testCases := []struct {
+		name  string
+		pools []*NodePool
+		ok    bool
+	}{
+		{
+			name: "OK",
+			pools: []*NodePool{
+				{Name: "system", MachineType: "t3.large", Replicas: 3},
+				{
+					Name:        "memory",
+					MachineType: "r5.xlarge",
+					Replicas:    6,
+					Labels:      map[string]string{"tks.io/pool": "memory"},
+					Taints:      []NodeTaint{{Key: "dedicated", Value: "memory", Effect: "NoSchedule"}},
+				},
+			},
+			ok: true,
+		},
+		{
+			name:  "RESERVED_NAME",
+			pools: []*NodePool{{Name: defaultNodePoolName, MachineType: "t3.large", Replicas: 3}},
+		},
+		{
+			name:  "INVALID_NAME",
+			pools: []*NodePool{{Name: "Memory_Pool", MachineType: "t3.large", Replicas: 3}},
+		},
+		{
+			name: "DUPLICATED_NAME",
+			pools: []*NodePool{
+				{Name: "system", MachineType: "t3.large", Replicas: 3},
+				{Name: "system", MachineType: "t3.xlarge", Replicas: 3},
+			},
+		},
+		{
+			name:  "UNKNOWN_MACHINE_TYPE",
+			pools: []*NodePool{{Name: "system", MachineType: "t3.lrage", Replicas: 3}},
+		},
+		{
+			name:  "TOO_MANY_REPLICAS",
+			pools: []*NodePool{{Name: "system", MachineType: "t3.large", Replicas: 100}},
+		},
+		{
+			name:  "ZERO_REPLICAS",
+			pools: []*NodePool{{Name: "system", MachineType: "t3.large"}},
+		},
+		{
+			name: "INVALID_LABEL",
+			pools: []*NodePool{
+				{Name: "system", MachineType: "t3.large", Replicas: 3, Labels: map[string]string{"tks.io/pool": "a b"}},
+			},
+		},
+		{
+			name:  "NULL_POOL",
+			pools: []*NodePool{nil},
+		},
+		{
+			name: "INVALID_TAINT_EFFECT",
+			pools: []*NodePool{
+				{Name: "system", MachineType: "t3.large", Replicas: 3, Taints: []NodeTaint{{Key: "dedicated", Effect: "Never"}}},
+			},
+		},
+	}
+
+	provider, err := getCspProvider(pb.CspType_AWS.String())
+	require.NoError(t, err)
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateNodePools(provider, autoscalingPolicies.policy(""), "ap-northeast-2", 3, tc.pools)
+			if tc.ok {
+				require.NoError(t, err)
+				require.Equal(t, 1, tc.pools[0].MinSizePerAz)
+				require.Equal(t, 2, tc.pools[1].MinSizePerAz)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestGetNodePoolsNull(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(nodePoolsHeader, `[null]`))
+	_, err := getNodePools(ctx)
+	require.Error(t, err)
+}
+
+func TestCreateClusterNodePools(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockArgoClient := mockargo.NewMockClient(ctrl)
+	argowfClient = mockArgoClient
+	mockContractClient := mocktks.NewMockContractServiceClient(ctrl)
+	contractClient = mockContractClient
+	mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+	cspInfoClient = mockCspInfoClient
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+
+	req := randomCreateClusterRequest()
+	clusterId := helper.GenerateClusterId()
+
+	mockContractClient.EXPECT().GetContract(gomock.Any(), gomock.Any()).Times(2).
+		Return(&pb.GetContractResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+	mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(2).
+		Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, ContractId: req.ContractId}, nil)
+	mockClusterInfoClient.EXPECT().AddClusterInfo(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.IDResponse{Code: pb.Code_OK_UNSPECIFIED, Id: clusterId}, nil)
+	mockArgoClient.EXPECT().SumbitWorkflowFromWftpl(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+		DoAndReturn(func(workflow string, namespace string, opts argowf.SubmitOptions) (string, error) {
+			require.Contains(t, opts.Parameters,
+				`node_pools=[{"name":"memory","machineType":"r5.xlarge","replicas":3,"minSizePerAz":1,"maxSizePerAz":5}]`)
+			return randomString("workflowName"), nil
+		})
+	mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+	s := server{}
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(nodePoolsHeader, `[{"name":"memory","machineType":"r5.xlarge","replicas":3}]`))
+	res, err := s.CreateCluster(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, clusterId, res.Id)
+
+	md, err := clusterMetadatas.get(clusterId)
+	require.NoError(t, err)
+	require.Len(t, md.NodePools, 1)
+	require.Equal(t, "memory", md.NodePools[0].Name)
+
+	// invalid pools are rejected before the cluster is created
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(nodePoolsHeader, `[{"name":"default","machineType":"r5.xlarge","replicas":3}]`))
+	res, err = s.CreateCluster(ctx, req)
+	require.Error(t, err)
+	require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+}
+
+func TestApplyNodePoolOperation(t *testing.T) {
+	md := &clusterMetadata{
+		NodePools: []*NodePool{{Name: "system", MachineType: "t3.large", Replicas: 3}},
+	}
+
+	require.NoError(t, applyNodePoolOperation(md, &Operation{
+		Type:       operationAddNodePool,
+		Parameters: []string{"node_pool_name=memory", `node_pool={"name":"memory","machineType":"r5.xlarge","replicas":3}`},
+	}))
+	require.Len(t, md.NodePools, 2)
+
+	require.NoError(t, applyNodePoolOperation(md, &Operation{
+		Type:       operationUpdateNodePool,
+		Parameters: []string{"node_pool_name=system", `node_pool={"name":"system","machineType":"t3.xlarge","replicas":6}`},
+	}))
+	require.Equal(t, "t3.xlarge", md.NodePools[0].MachineType)
+	require.Equal(t, 6, md.NodePools[0].Replicas)
+
+	require.NoError(t, applyNodePoolOperation(md, &Operation{
+		Type:       operationRemoveNodePool,
+		Parameters: []string{"node_pool_name=system"},
+	}))
+	require.Len(t, md.NodePools, 1)
+	require.Equal(t, "memory", md.NodePools[0].Name)
+}
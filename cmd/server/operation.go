@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/openinfradev/tks-common/pkg/log"
+)
+
+// Operation types
+const (
+	operationCreateCluster     = "CREATE_CLUSTER"
+	operationImportCluster     = "IMPORT_CLUSTER"
+	operationScaleCluster      = "SCALE_CLUSTER"
+	operationUpgradeCluster    = "UPGRADE_CLUSTER"
+	operationDeleteCluster     = "DELETE_CLUSTER"
+	operationUpdateCredentials = "UPDATE_CLUSTER_CREDENTIALS"
+	operationAddNodePool       = "ADD_NODE_POOL"
+	operationUpdateNodePool    = "UPDATE_NODE_POOL"
+	operationRemoveNodePool    = "REMOVE_NODE_POOL"
+	operationInstallAppGroup   = "INSTALL_APP_GROUP"
+	operationUninstallAppGroup = "UNINSTALL_APP_GROUP"
+	operationUpgradeAppGroup   = "UPGRADE_APP_GROUP"
+)
+
+// clusterOperationTypes are the operation types whose target is a cluster.
+var clusterOperationTypes = []string{
+	operationCreateCluster,
+	operationImportCluster,
+	operationScaleCluster,
+	operationUpgradeCluster,
+	operationDeleteCluster,
+	operationUpdateCredentials,
+	operationAddNodePool,
+	operationUpdateNodePool,
+	operationRemoveNodePool,
+}
+
+// Operation phases
+const (
+	operationPhaseRunning   = "RUNNING"
+	operationPhaseSucceeded = "SUCCEEDED"
+	operationPhaseFailed    = "FAILED"
+	operationPhaseCancelled = "CANCELLED"
+)
+
+// operationIdHeader is a response header which carries the ID of the operation started by the request.
+const operationIdHeader = "x-operation-id"
+
+const operationBucket = "operations"
+
+// Operation is a record of a long-running operation started by a LCM API.
+type Operation struct {
+	OperationId string     `json:"operationId"`
+	Type        string     `json:"type"`
+	TargetId    string     `json:"targetId"`
+	WorkflowId  string     `json:"workflowId"`
+	Parameters  []string   `json:"parameters"`
+	Phase       string     `json:"phase"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"startedAt"`
+	EndedAt     *time.Time `json:"endedAt,omitempty"`
+	// Pending is the workflow which is not submitted yet. The operation has no workflow until then.
+	Pending *pendingWorkflow `json:"pending,omitempty"`
+}
+
+// last returns the latest operation of the target among the given types.
+func (s *operationStore) last(targetId string, types ...string) (*Operation, error) {
+	ops := s.listTarget(targetId, func(op *Operation) bool {
+		return containsString(types, op.Type)
+	})
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	return ops[len(ops)-1], nil
+}
+
+// lastSucceeded returns the latest succeeded operation of the target among the given types.
+func (s *operationStore) lastSucceeded(targetId string, types ...string) (*Operation, error) {
+	ops := s.listTarget(targetId, func(op *Operation) bool {
+		return op.Phase == operationPhaseSucceeded && containsString(types, op.Type)
+	})
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	return ops[len(ops)-1], nil
+}
+
+// operationStore keeps the operations in the file store, and caches them in memory
+// with indexes by target and of the running ones, so that reads never scan the files.
+// Finished operations are purged after the retention.
+type operationStore struct {
+	mu        sync.RWMutex
+	store     *fileStore
+	retention time.Duration
+
+	ops      map[string]*Operation
+	byTarget map[string][]*Operation
+	running  map[string]*Operation
+}
+
+func newOperationStore(store *fileStore, retention time.Duration) (*operationStore, error) {
+	s := &operationStore{
+		store:     store,
+		retention: retention,
+		ops:       map[string]*Operation{},
+		byTarget:  map[string][]*Operation{},
+		running:   map[string]*Operation{},
+	}
+	err := store.list(operationBucket, func(key string, data []byte) error {
+		op := &Operation{}
+		if err := json.Unmarshal(data, op); err != nil {
+			log.Error("Failed to parse operation ", key, ". err : ", err)
+			return nil
+		}
+		s.index(op)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// index caches the operation, replacing the previous one with the same ID.
+// The caller must hold the lock.
+func (s *operationStore) index(op *Operation) {
+	if prev, ok := s.ops[op.OperationId]; ok {
+		s.unindex(prev)
+	}
+	s.ops[op.OperationId] = op
+	if op.Phase == operationPhaseRunning {
+		s.running[op.OperationId] = op
+	}
+
+	// Keep the operations of the target ordered by start time.
+	ops := s.byTarget[op.TargetId]
+	i := sort.Search(len(ops), func(i int) bool { return op.StartedAt.Before(ops[i].StartedAt) })
+	ops = append(ops, nil)
+	copy(ops[i+1:], ops[i:])
+	ops[i] = op
+	s.byTarget[op.TargetId] = ops
+}
+
+// unindex removes the operation from the cache. The caller must hold the lock.
+func (s *operationStore) unindex(op *Operation) {
+	delete(s.ops, op.OperationId)
+	delete(s.running, op.OperationId)
+	ops := s.byTarget[op.TargetId]
+	for i := range ops {
+		if ops[i].OperationId == op.OperationId {
+			ops = append(ops[:i], ops[i+1:]...)
+			break
+		}
+	}
+	if len(ops) == 0 {
+		delete(s.byTarget, op.TargetId)
+	} else {
+		s.byTarget[op.TargetId] = ops
+	}
+}
+
+func (s *operationStore) put(op *Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.store.put(operationBucket, op.OperationId, op); err != nil {
+		return err
+	}
+	// The cache keeps its own copy, as the caller may go on changing the operation.
+	cached := *op
+	s.index(&cached)
+	return nil
+}
+
+func (s *operationStore) get(operationId string) (*Operation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	op, ok := s.ops[operationId]
+	if !ok {
+		return nil, nil
+	}
+	cached := *op
+	return &cached, nil
+}
+
+// list returns the operations which match the filter, ordered by start time.
+func (s *operationStore) list(filter func(op *Operation) bool) ([]*Operation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ops := s.filter(s.ops, filter)
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].StartedAt.Before(ops[j].StartedAt) })
+	return ops, nil
+}
+
+// listTarget returns the operations of the target which match the filter, ordered by start time.
+func (s *operationStore) listTarget(targetId string, filter func(op *Operation) bool) []*Operation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ops := []*Operation{}
+	for _, op := range s.byTarget[targetId] {
+		if filter == nil || filter(op) {
+			cached := *op
+			ops = append(ops, &cached)
+		}
+	}
+	return ops
+}
+
+// listRunning returns the running operations, ordered by start time.
+func (s *operationStore) listRunning() []*Operation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ops := s.filter(s.running, nil)
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].StartedAt.Before(ops[j].StartedAt) })
+	return ops
+}
+
+// filter returns copies of the cached operations which match the filter. The caller must hold the lock.
+func (s *operationStore) filter(cached map[string]*Operation, filter func(op *Operation) bool) []*Operation {
+	ops := []*Operation{}
+	for _, op := range cached {
+		if filter == nil || filter(op) {
+			c := *op
+			ops = append(ops, &c)
+		}
+	}
+	return ops
+}
+
+// purge removes the operations which have ended before the retention. A zero retention keeps them forever.
+func (s *operationStore) purge() {
+	if s.retention <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, op := range s.ops {
+		if op.EndedAt == nil || time.Since(*op.EndedAt) < s.retention {
+			continue
+		}
+		if err := s.store.delete(operationBucket, op.OperationId); err != nil {
+			log.Error("Failed to delete operation. err : ", err)
+			continue
+		}
+		s.unindex(op)
+	}
+}
+
+// finish marks the operation as ended with the phase.
+func (s *operationStore) finish(op *Operation, phase string, message string) error {
+	now := time.Now()
+	op.Phase = phase
+	op.Error = message
+	op.EndedAt = &now
+	return s.put(op)
+}
+
+// finishByWorkflowId ends the running operations of the workflow with the phase.
+func (s *operationStore) finishByWorkflowId(workflowId string, phase string, message string) {
+	for _, op := range s.listRunning() {
+		if op.WorkflowId != workflowId {
+			continue
+		}
+		if err := s.finish(op, phase, message); err != nil {
+			log.Error("Failed to update operation. err : ", err)
+		}
+	}
+}
+
+// parameter returns the value of the workflow parameter of the operation.
+func (op *Operation) parameter(name string) string {
+	for _, param := range op.Parameters {
+		if strings.HasPrefix(param, name+"=") {
+			return strings.TrimPrefix(param, name+"=")
+		}
+	}
+	return ""
+}
+
+// recordOperation stores an operation for the submitted workflow and returns it to the caller
+// with the response header. submitErr is the error of the workflow submission, if any.
+// Failures of the store are logged only, so that they never fail the request itself.
+func recordOperation(ctx context.Context, opType string, targetId string, workflowId string, parameters []string, submitErr error) *Operation {
+	op := &Operation{
+		OperationId: uuid.New().String(),
+		Type:        opType,
+		TargetId:    targetId,
+		WorkflowId:  workflowId,
+		Parameters:  redactParameters(parameters),
+		Phase:       operationPhaseRunning,
+		StartedAt:   time.Now(),
+	}
+	if submitErr != nil {
+		op.Phase = operationPhaseFailed
+		op.Error = submitErr.Error()
+		op.EndedAt = &op.StartedAt
+	}
+
+	if err := operations.put(op); err != nil {
+		log.Error("Failed to store operation. err : ", err)
+		return op
+	}
+	log.Debug("Recorded operation : ", op.OperationId)
+
+	// Setting a header fails when the context is not a grpc server stream (eg. unit tests).
+	_ = grpc.SetHeader(ctx, metadata.Pairs(operationIdHeader, op.OperationId))
+	return op
+}
+
+// redactedParameters are workflow parameters which must not be stored in operation records.
+var redactedParameters = []string{"kubeconfig"}
+
+func redactParameters(parameters []string) []string {
+	res := make([]string, 0, len(parameters))
+	for _, param := range parameters {
+		for _, name := range redactedParameters {
+			if strings.HasPrefix(param, name+"=") {
+				param = name + "=<redacted>"
+				break
+			}
+		}
+		res = append(res, param)
+	}
+	return res
+}
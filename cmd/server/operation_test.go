@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openinfradev/tks-common/pkg/argowf"
+	mockargo "github.com/openinfradev/tks-common/pkg/argowf/mock"
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+	mocktks "github.com/openinfradev/tks-proto/tks_pb/mock"
+)
+
+func newTestOperationStore(t *testing.T) *operationStore {
+	store, err := newFileStore(t.TempDir())
+	require.NoError(t, err)
+	operations, err := newOperationStore(store, 0)
+	require.NoError(t, err)
+	return operations
+}
+
+func TestRecordOperation(t *testing.T) {
+	operations = newTestOperationStore(t)
+	clusterId := helper.GenerateClusterId()
+
+	op := recordOperation(context.Background(), operationImportCluster, clusterId, "import-tks-usercluster-abcde",
+		[]string{"cluster_id=" + clusterId, "kubeconfig=c2VjcmV0"}, nil)
+	require.Equal(t, operationPhaseRunning, op.Phase)
+	require.Nil(t, op.EndedAt)
+
+	stored, err := operations.get(op.OperationId)
+	require.NoError(t, err)
+	require.Equal(t, operationImportCluster, stored.Type)
+	require.Equal(t, clusterId, stored.TargetId)
+	require.Equal(t, []string{"cluster_id=" + clusterId, "kubeconfig=<redacted>"}, stored.Parameters)
+
+	op = recordOperation(context.Background(), operationDeleteCluster, clusterId, "", nil, errors.New("FAILED_TO_CALL_WORKFLOW"))
+	require.Equal(t, operationPhaseFailed, op.Phase)
+	require.Equal(t, "FAILED_TO_CALL_WORKFLOW", op.Error)
+	require.NotNil(t, op.EndedAt)
+}
+
+func TestOperationStore(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	require.NoError(t, err)
+	s, err := newOperationStore(store, time.Hour)
+	require.NoError(t, err)
+
+	clusterId := helper.GenerateClusterId()
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	expired := &Operation{OperationId: uuid.New().String(), Type: operationCreateCluster, TargetId: clusterId,
+		Phase: operationPhaseSucceeded, StartedAt: old, EndedAt: &old}
+	finished := &Operation{OperationId: uuid.New().String(), Type: operationScaleCluster, TargetId: clusterId,
+		Phase: operationPhaseFailed, StartedAt: now.Add(-time.Minute), EndedAt: &now}
+	running := &Operation{OperationId: uuid.New().String(), Type: operationUpgradeCluster, TargetId: clusterId,
+		Phase: operationPhaseRunning, StartedAt: now}
+	for _, op := range []*Operation{running, expired, finished} {
+		require.NoError(t, s.put(op))
+	}
+
+	// The indexes are rebuilt from the file store.
+	s, err = newOperationStore(store, time.Hour)
+	require.NoError(t, err)
+
+	ops := s.listTarget(clusterId, nil)
+	require.Len(t, ops, 3)
+	require.Equal(t, []string{expired.OperationId, finished.OperationId, running.OperationId},
+		[]string{ops[0].OperationId, ops[1].OperationId, ops[2].OperationId})
+	require.Len(t, s.listRunning(), 1)
+
+	// Changes of a returned operation are not cached until it is put.
+	ops[2].Phase = operationPhaseSucceeded
+	require.Len(t, s.listRunning(), 1)
+	require.NoError(t, s.finish(ops[2], operationPhaseSucceeded, ""))
+	require.Empty(t, s.listRunning())
+
+	s.purge()
+	op, err := s.get(expired.OperationId)
+	require.NoError(t, err)
+	require.Nil(t, op)
+	require.Len(t, s.listTarget(clusterId, nil), 2)
+
+	s, err = newOperationStore(store, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, s.listTarget(clusterId, nil), 2)
+}
+
+func TestGetOperation(t *testing.T) {
+	operations = newTestOperationStore(t)
+	op := recordOperation(context.Background(), operationCreateCluster, helper.GenerateClusterId(), randomString("workflowName"), nil, nil)
+
+	testCases := []struct {
+		name          string
+		in            *pb.IDRequest
+		checkResponse func(res *GetOperationResponse, err error)
+	}{
+		{
+			name: "OK",
+			in:   &pb.IDRequest{Id: op.OperationId},
+			checkResponse: func(res *GetOperationResponse, err error) {
+				require.NoError(t, err)
+				require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+				require.Equal(t, op.WorkflowId, res.Operation.WorkflowId)
+			},
+		},
+		{
+			name: "INVALID_OPERATION_ID",
+			in:   &pb.IDRequest{Id: "THIS_IS_INVALID_ID"},
+			checkResponse: func(res *GetOperationResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_INVALID_ARGUMENT, res.Code)
+			},
+		},
+		{
+			name: "NOT_FOUND_OPERATION",
+			in:   &pb.IDRequest{Id: uuid.New().String()},
+			checkResponse: func(res *GetOperationResponse, err error) {
+				require.Error(t, err)
+				require.Equal(t, pb.Code_NOT_FOUND, res.Code)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			s := server{}
+			res, err := s.GetOperation(context.Background(), tc.in)
+			tc.checkResponse(res, err)
+		})
+	}
+}
+
+func TestListOperations(t *testing.T) {
+	operations = newTestOperationStore(t)
+	clusterId := helper.GenerateClusterId()
+	recordOperation(context.Background(), operationCreateCluster, clusterId, randomString("workflowName"), nil, nil)
+	recordOperation(context.Background(), operationDeleteCluster, clusterId, randomString("workflowName"), nil, nil)
+	recordOperation(context.Background(), operationCreateCluster, helper.GenerateClusterId(), randomString("workflowName"), nil, nil)
+
+	testCases := []struct {
+		name          string
+		in            *ListOperationsRequest
+		checkResponse func(res *ListOperationsResponse, err error)
+	}{
+		{
+			name: "OK_ALL",
+			in:   &ListOperationsRequest{},
+			checkResponse: func(res *ListOperationsResponse, err error) {
+				require.NoError(t, err)
+				require.Len(t, res.Operations, 3)
+			},
+		},
+		{
+			name: "OK_BY_TARGET",
+			in:   &ListOperationsRequest{TargetId: clusterId},
+			checkResponse: func(res *ListOperationsResponse, err error) {
+				require.NoError(t, err)
+				require.Len(t, res.Operations, 2)
+				require.Equal(t, operationCreateCluster, res.Operations[0].Type)
+				require.Equal(t, operationDeleteCluster, res.Operations[1].Type)
+			},
+		},
+		{
+			name: "OK_BY_TARGET_AND_TYPE",
+			in:   &ListOperationsRequest{TargetId: clusterId, Type: operationDeleteCluster},
+			checkResponse: func(res *ListOperationsResponse, err error) {
+				require.NoError(t, err)
+				require.Len(t, res.Operations, 1)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			s := server{}
+			res, err := s.ListOperations(context.Background(), tc.in)
+			tc.checkResponse(res, err)
+		})
+	}
+}
+
+func TestReconcileOperations(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockArgoClient := mockargo.NewMockClient(ctrl)
+	argowfClient = mockArgoClient
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+	operations = newTestOperationStore(t)
+
+	succeeded := recordOperation(context.Background(), operationCreateCluster, helper.GenerateClusterId(), randomString("workflowName"), nil, nil)
+	failed := recordOperation(context.Background(), operationDeleteCluster, helper.GenerateClusterId(), randomString("workflowName"), nil, nil)
+	running := recordOperation(context.Background(), operationScaleCluster, helper.GenerateClusterId(), randomString("workflowName"), nil, nil)
+	upgraded := recordOperation(context.Background(), operationUpgradeCluster, helper.GenerateClusterId(), randomString("workflowName"),
+		[]string{"current_kubernetes_version=v1.23.16", "kubernetes_version=v1.24.10"}, nil)
+	scaled := recordOperation(context.Background(), operationScaleCluster, helper.GenerateClusterId(), randomString("workflowName"),
+		[]string{"min_size_per_az=2", "desired_size_per_az=4", "max_size_per_az=8"}, nil)
+
+	mockArgoClient.EXPECT().GetWorkflow("argo", succeeded.WorkflowId).Times(1).
+		Return(&argowf.Workflow{Status: argowf.WorkflowStatus{Phase: workflowPhaseSucceeded}}, nil)
+	mockArgoClient.EXPECT().GetWorkflow("argo", failed.WorkflowId).Times(1).
+		Return(&argowf.Workflow{Status: argowf.WorkflowStatus{Phase: workflowPhaseFailed, Message: "FAILED"}}, nil)
+	mockArgoClient.EXPECT().GetWorkflow("argo", running.WorkflowId).Times(1).
+		Return(&argowf.Workflow{Status: argowf.WorkflowStatus{Phase: "Running"}}, nil)
+	mockArgoClient.EXPECT().GetWorkflow("argo", upgraded.WorkflowId).Times(1).
+		Return(&argowf.Workflow{Status: argowf.WorkflowStatus{Phase: workflowPhaseSucceeded}}, nil)
+	mockArgoClient.EXPECT().GetWorkflow("argo", scaled.WorkflowId).Times(1).
+		Return(&argowf.Workflow{Status: argowf.WorkflowStatus{Phase: workflowPhaseSucceeded}}, nil)
+
+	// The sizes of the scaled cluster are written to tks-info only when the workflow has succeeded.
+	// The targets of the finished operations are reconciled as well.
+	mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(5).
+		DoAndReturn(func(ctx context.Context, in *pb.GetClusterRequest, opts ...interface{}) (*pb.GetClusterResponse, error) {
+			return &pb.GetClusterResponse{
+				Code: pb.Code_OK_UNSPECIFIED,
+				Cluster: &pb.Cluster{
+					Id:     in.GetClusterId(),
+					Status: pb.ClusterStatus_RUNNING,
+					Conf:   &pb.ClusterConf{Region: "ap-northeast-2", NumOfAz: 3, MachineType: "t3.large", MinSizePerAz: 1, MaxSizePerAz: 5},
+				},
+			}, nil
+		})
+	mockClusterInfoClient.EXPECT().UpdateClusterConf(gomock.Any(), gomock.Any()).Times(1).
+		DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterConfRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+			require.Equal(t, "ap-northeast-2", in.GetConf().GetRegion())
+			require.Equal(t, int32(2), in.GetConf().GetMinSizePerAz())
+			require.Equal(t, int32(8), in.GetConf().GetMaxSizePerAz())
+			return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+		})
+
+	newReconciler(0, 0, 0).reconcileOperations(context.Background())
+
+	op, err := operations.get(succeeded.OperationId)
+	require.NoError(t, err)
+	require.Equal(t, operationPhaseSucceeded, op.Phase)
+	require.NotNil(t, op.EndedAt)
+
+	op, err = operations.get(failed.OperationId)
+	require.NoError(t, err)
+	require.Equal(t, operationPhaseFailed, op.Phase)
+	require.Equal(t, "FAILED", op.Error)
+
+	op, err = operations.get(running.OperationId)
+	require.NoError(t, err)
+	require.Equal(t, operationPhaseRunning, op.Phase)
+
+	md, err := clusterMetadatas.get(upgraded.TargetId)
+	require.NoError(t, err)
+	require.Equal(t, "v1.24.10", md.KubernetesVersion)
+
+	op, err = operations.get(scaled.OperationId)
+	require.NoError(t, err)
+	require.Equal(t, operationPhaseSucceeded, op.Phase)
+	md, err = clusterMetadatas.get(scaled.TargetId)
+	require.NoError(t, err)
+	require.Equal(t, 4, md.DesiredSizePerAz)
+}
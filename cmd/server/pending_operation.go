@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openinfradev/tks-common/pkg/argowf"
+	"github.com/openinfradev/tks-common/pkg/log"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// pendingWorkflow is a workflow of an operation which is submitted by the reconciler once the operation gets ready.
+// It is stored with the operation, so that the wait goes on after a restart.
+type pendingWorkflow struct {
+	Template   string   `json:"template"`
+	Parameters []string `json:"parameters"`
+	// Workflows must all succeed before the submission.
+	Workflows []string `json:"workflows,omitempty"`
+	// AppGroups are the dependencies to wait for. Any of the app groups of a dependency must get running.
+	AppGroups [][]string `json:"appGroups,omitempty"`
+	Deadline  time.Time  `json:"deadline"`
+}
+
+// recordPendingOperation records a running operation whose workflow is submitted later by the reconciler.
+func recordPendingOperation(ctx context.Context, opType string, targetId string, parameters []string, pending *pendingWorkflow) *Operation {
+	op := recordOperation(ctx, opType, targetId, "", parameters, nil)
+	op.Pending = pending
+	if err := operations.put(op); err != nil {
+		log.Error("Failed to store operation. err : ", err)
+	}
+	return op
+}
+
+// pendingOperation returns the running operation of the target which waits for its workflow.
+func pendingOperation(targetId string) *Operation {
+	ops := operations.listTarget(targetId, func(op *Operation) bool {
+		return op.Phase == operationPhaseRunning && op.Pending != nil
+	})
+	if len(ops) == 0 {
+		return nil
+	}
+	return ops[len(ops)-1]
+}
+
+// advancePendingOperation checks what the operation waits for, and submits its workflow once everything is ready.
+// The workflows which have succeeded are dropped from the record, as argo may remove them later.
+func (r *reconciler) advancePendingOperation(ctx context.Context, op *Operation) {
+	if time.Now().After(op.Pending.Deadline) {
+		r.failPendingOperation(ctx, op, fmt.Sprintf("Operation did not get ready by %s", op.Pending.Deadline.Format(time.RFC3339)))
+		return
+	}
+
+	// The cached operation shares the pending workflow, so a copy is changed.
+	pending := *op.Pending
+	pending.Workflows = []string{}
+	for _, workflowId := range op.Pending.Workflows {
+		phase, message, err := getWorkflowPhase(workflowId)
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to get workflow %s. err : ", workflowId), err)
+		}
+		switch phase {
+		case workflowPhaseSucceeded:
+			continue
+		case workflowPhaseFailed, workflowPhaseError:
+			r.failPendingOperation(ctx, op, fmt.Sprintf("Workflow %s %s : %s", workflowId, phase, message))
+			return
+		}
+		pending.Workflows = append(pending.Workflows, workflowId)
+	}
+
+	pending.AppGroups = [][]string{}
+	for _, candidates := range op.Pending.AppGroups {
+		running, failed := r.checkAppGroups(ctx, candidates)
+		if running {
+			continue
+		}
+		if failed {
+			r.failPendingOperation(ctx, op, fmt.Sprintf("App groups %s did not get running", strings.Join(candidates, ",")))
+			return
+		}
+		pending.AppGroups = append(pending.AppGroups, candidates)
+	}
+
+	if len(pending.Workflows) > 0 || len(pending.AppGroups) > 0 {
+		if len(pending.Workflows) != len(op.Pending.Workflows) || len(pending.AppGroups) != len(op.Pending.AppGroups) {
+			op.Pending = &pending
+			if err := operations.put(op); err != nil {
+				log.Error("Failed to update operation. err : ", err)
+			}
+		}
+		return
+	}
+	r.submitPendingWorkflow(ctx, op)
+}
+
+// checkAppGroups returns whether any of the app groups is running, or all of them can not get running any more.
+func (r *reconciler) checkAppGroups(ctx context.Context, appGroupIds []string) (running bool, failed bool) {
+	failures := 0
+	for _, appGroupId := range appGroupIds {
+		callCtx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+		res, err := appInfoClient.GetAppGroup(callCtx, &pb.GetAppGroupRequest{AppGroupId: appGroupId})
+		cancel()
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to get app group %s. err : ", appGroupId), err)
+			continue
+		}
+		switch res.GetAppGroup().GetStatus() {
+		case pb.AppGroupStatus_APP_GROUP_RUNNING:
+			return true, false
+		case pb.AppGroupStatus_APP_GROUP_ERROR, pb.AppGroupStatus_APP_GROUP_DELETING, pb.AppGroupStatus_APP_GROUP_DELETED:
+			failures++
+		}
+	}
+	return false, failures == len(appGroupIds)
+}
+
+// submitPendingWorkflow submits the workflow of the operation and attaches it to the target.
+// The reconciler then ends the target status and the operation with the workflow.
+func (r *reconciler) submitPendingWorkflow(ctx context.Context, op *Operation) {
+	// The operation may have been cancelled since it was listed.
+	if current, err := operations.get(op.OperationId); err != nil || current == nil || current.Phase != operationPhaseRunning {
+		return
+	}
+
+	opts := argowf.SubmitOptions{}
+	opts.Parameters = op.Pending.Parameters
+
+	log.Info("Submitting workflow: ", op.Pending.Template)
+	workflowId, err := argowfClient.SumbitWorkflowFromWftpl(op.Pending.Template, "argo", opts)
+	if err != nil {
+		r.failPendingOperation(ctx, op, fmt.Sprintf("Failed to submit workflow %s : %s", op.Pending.Template, err))
+		return
+	}
+	log.Debug("submited workflow name : ", workflowId)
+
+	callCtx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+	defer cancel()
+	switch op.Type {
+	case operationDeleteCluster:
+		_, err = clusterInfoClient.UpdateClusterStatus(callCtx, &pb.UpdateClusterStatusRequest{
+			ClusterId:  op.TargetId,
+			Status:     pb.ClusterStatus_DELETING,
+			WorkflowId: workflowId,
+		})
+	case operationInstallAppGroup:
+		_, err = appInfoClient.UpdateAppGroupStatus(callCtx, &pb.UpdateAppGroupStatusRequest{
+			AppGroupId: op.TargetId,
+			Status:     pb.AppGroupStatus_APP_GROUP_INSTALLING,
+			WorkflowId: workflowId,
+		})
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to attach workflow %s to %s. err : ", workflowId, op.TargetId), err)
+	}
+
+	op.WorkflowId = workflowId
+	op.Pending = nil
+	if err := operations.put(op); err != nil {
+		log.Error("Failed to update operation. err : ", err)
+		return
+	}
+	log.Info(fmt.Sprintf("Submitted pending workflow of operation %s : %s", op.OperationId, workflowId))
+}
+
+// failPendingOperation ends the operation and sets its target to the error status.
+func (r *reconciler) failPendingOperation(ctx context.Context, op *Operation, reason string) {
+	log.Error(fmt.Sprintf("Operation %s failed : %s", op.OperationId, reason))
+
+	callCtx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+	defer cancel()
+	var err error
+	switch op.Type {
+	case operationDeleteCluster:
+		_, err = clusterInfoClient.UpdateClusterStatus(callCtx, &pb.UpdateClusterStatusRequest{
+			ClusterId:  op.TargetId,
+			Status:     pb.ClusterStatus_ERROR,
+			StatusDesc: reason,
+		})
+	case operationInstallAppGroup:
+		_, err = appInfoClient.UpdateAppGroupStatus(callCtx, &pb.UpdateAppGroupStatusRequest{
+			AppGroupId: op.TargetId,
+			Status:     pb.AppGroupStatus_APP_GROUP_ERROR,
+			StatusDesc: reason,
+		})
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to update status of %s. err : ", op.TargetId), err)
+	}
+
+	op.Pending = nil
+	if err := operations.finish(op, operationPhaseFailed, reason); err != nil {
+		log.Error("Failed to update operation. err : ", err)
+	}
+}
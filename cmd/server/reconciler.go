@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+
+	"github.com/openinfradev/tks-common/pkg/log"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+)
+
+// Argo workflow phases
+const (
+	workflowPhaseSucceeded = "Succeeded"
+	workflowPhaseFailed    = "Failed"
+	workflowPhaseError     = "Error"
+)
+
+// reconcileCallTimeout limits each call of the reconciler to tks-info.
+var reconcileCallTimeout = 10 * time.Second
+
+// reconciler periodically moves clusters and app groups out of their transitional
+// statuses once the argo workflow recorded for them has finished.
+// On every interval, only the targets of the operations whose workflow has finished are fetched from tks-info.
+// All clusters and app groups are swept on the sweep interval, which also catches orphan clusters
+// which have no workflow after the grace period.
+type reconciler struct {
+	interval          time.Duration
+	sweepInterval     time.Duration
+	orphanGracePeriod time.Duration
+
+	lastSweep time.Time
+}
+
+func newReconciler(interval time.Duration, sweepInterval time.Duration, orphanGracePeriod time.Duration) *reconciler {
+	return &reconciler{
+		interval:          interval,
+		sweepInterval:     sweepInterval,
+		orphanGracePeriod: orphanGracePeriod,
+	}
+}
+
+func (r *reconciler) run(ctx context.Context) {
+	log.Info("Starting workflow status reconciler. interval : ", r.interval)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopped workflow status reconciler")
+			return
+		case <-ticker.C:
+			r.reconcile(ctx)
+		}
+	}
+}
+
+func (r *reconciler) reconcile(ctx context.Context) {
+	r.reconcileOperations(ctx)
+	operations.purge()
+	idempotencyKeys.purge()
+
+	if time.Since(r.lastSweep) < r.sweepInterval {
+		return
+	}
+	r.lastSweep = time.Now()
+	r.sweep(ctx)
+}
+
+// sweep reconciles all clusters and app groups of tks-info.
+func (r *reconciler) sweep(ctx context.Context) {
+	callCtx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+	res, err := cspInfoClient.GetCSPIDs(callCtx, &empty.Empty{})
+	cancel()
+	if err != nil {
+		log.Error("Failed to get csp ids. err : ", err)
+		return
+	}
+
+	for _, cspId := range res.GetIds() {
+		callCtx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+		resClusters, err := clusterInfoClient.GetClusters(callCtx, &pb.GetClustersRequest{CspId: cspId})
+		cancel()
+		if err != nil {
+			log.Error("Failed to get clusters by cspId. err : ", err)
+			continue
+		}
+
+		for _, cluster := range resClusters.GetClusters() {
+			r.reconcileCluster(ctx, cluster)
+
+			if cluster.GetStatus() == pb.ClusterStatus_DELETED {
+				continue
+			}
+			callCtx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+			resAppGroups, err := appInfoClient.GetAppGroupsByClusterID(callCtx, &pb.IDRequest{Id: cluster.GetId()})
+			cancel()
+			if err != nil || resAppGroups.GetCode() != pb.Code_OK_UNSPECIFIED {
+				continue
+			}
+			for _, appGroup := range resAppGroups.GetAppGroups() {
+				r.reconcileAppGroup(ctx, appGroup)
+			}
+		}
+	}
+}
+
+// reconcileTarget reconciles the cluster or app group of the finished operation.
+func (r *reconciler) reconcileTarget(ctx context.Context, op *Operation) {
+	callCtx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+	defer cancel()
+
+	switch op.Type {
+	case operationInstallAppGroup, operationUninstallAppGroup, operationUpgradeAppGroup:
+		res, err := appInfoClient.GetAppGroup(callCtx, &pb.GetAppGroupRequest{AppGroupId: op.TargetId})
+		if err != nil || res.GetCode() != pb.Code_OK_UNSPECIFIED {
+			log.Error(fmt.Sprintf("Failed to get appgroup %s. err : ", op.TargetId), err)
+			return
+		}
+		r.reconcileAppGroup(ctx, res.GetAppGroup())
+	default:
+		res, err := clusterInfoClient.GetCluster(callCtx, &pb.GetClusterRequest{ClusterId: op.TargetId})
+		if err != nil || res.GetCode() != pb.Code_OK_UNSPECIFIED {
+			log.Error(fmt.Sprintf("Failed to get cluster %s. err : ", op.TargetId), err)
+			return
+		}
+		r.reconcileCluster(ctx, res.GetCluster())
+	}
+}
+
+func (r *reconciler) reconcileCluster(ctx context.Context, cluster *pb.Cluster) {
+	if r.isOrphanCluster(cluster) {
+		r.sweepOrphanCluster(ctx, cluster)
+		return
+	}
+
+	var succeeded pb.ClusterStatus
+	switch cluster.GetStatus() {
+	case pb.ClusterStatus_INSTALLING:
+		succeeded = pb.ClusterStatus_RUNNING
+	case pb.ClusterStatus_DELETING:
+		succeeded = pb.ClusterStatus_DELETED
+	default:
+		return
+	}
+
+	phase, message, err := getWorkflowPhase(cluster.GetWorkflowId())
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to get workflow for cluster %s. err : ", cluster.GetId()), err)
+		return
+	}
+
+	status := succeeded
+	switch phase {
+	case workflowPhaseSucceeded:
+	case workflowPhaseFailed, workflowPhaseError:
+		status = pb.ClusterStatus_ERROR
+	default:
+		return
+	}
+
+	log.Info(fmt.Sprintf("Reconciled cluster %s : %s -> %s", cluster.GetId(), cluster.GetStatus(), status))
+	ctx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+	defer cancel()
+	if _, err := clusterInfoClient.UpdateClusterStatus(ctx, &pb.UpdateClusterStatusRequest{
+		ClusterId:  cluster.GetId(),
+		Status:     status,
+		StatusDesc: message,
+		WorkflowId: cluster.GetWorkflowId(),
+	}); err != nil {
+		log.Error("Failed to update cluster status err : ", err)
+	}
+}
+
+// isOrphanCluster returns true if the cluster was added to tks-info but no workflow has been attached
+// within the grace period, eg. the server stopped between AddClusterInfo and the workflow submission.
+func (r *reconciler) isOrphanCluster(cluster *pb.Cluster) bool {
+	if cluster.GetWorkflowId() != "" || cluster.GetCreatedAt() == nil {
+		return false
+	}
+	if cluster.GetStatus() != pb.ClusterStatus_UNSPECIFIED && cluster.GetStatus() != pb.ClusterStatus_INSTALLING {
+		return false
+	}
+	return time.Since(cluster.GetCreatedAt().AsTime()) > r.orphanGracePeriod
+}
+
+func (r *reconciler) sweepOrphanCluster(ctx context.Context, cluster *pb.Cluster) {
+	log.Info(fmt.Sprintf("Sweeping orphan cluster %s : %s -> %s", cluster.GetId(), cluster.GetStatus(), pb.ClusterStatus_ERROR))
+	ctx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+	defer cancel()
+	if _, err := clusterInfoClient.UpdateClusterStatus(ctx, &pb.UpdateClusterStatusRequest{
+		ClusterId:  cluster.GetId(),
+		Status:     pb.ClusterStatus_ERROR,
+		StatusDesc: fmt.Sprintf("No workflow was started for the cluster within %s", r.orphanGracePeriod),
+	}); err != nil {
+		log.Error("Failed to update cluster status err : ", err)
+	}
+}
+
+func (r *reconciler) reconcileAppGroup(ctx context.Context, appGroup *pb.AppGroup) {
+	var succeeded pb.AppGroupStatus
+	switch appGroup.GetStatus() {
+	case pb.AppGroupStatus_APP_GROUP_INSTALLING:
+		succeeded = pb.AppGroupStatus_APP_GROUP_RUNNING
+	case pb.AppGroupStatus_APP_GROUP_DELETING:
+		succeeded = pb.AppGroupStatus_APP_GROUP_DELETED
+	default:
+		return
+	}
+
+	phase, message, err := getWorkflowPhase(appGroup.GetWorkflowId())
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to get workflow for appgroup %s. err : ", appGroup.GetAppGroupId()), err)
+		return
+	}
+
+	status := succeeded
+	switch phase {
+	case workflowPhaseSucceeded:
+	case workflowPhaseFailed, workflowPhaseError:
+		status = pb.AppGroupStatus_APP_GROUP_ERROR
+	default:
+		return
+	}
+
+	log.Info(fmt.Sprintf("Reconciled appgroup %s : %s -> %s", appGroup.GetAppGroupId(), appGroup.GetStatus(), status))
+	ctx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+	defer cancel()
+	if _, err := appInfoClient.UpdateAppGroupStatus(ctx, &pb.UpdateAppGroupStatusRequest{
+		AppGroupId: appGroup.GetAppGroupId(),
+		Status:     status,
+		StatusDesc: message,
+		WorkflowId: appGroup.GetWorkflowId(),
+	}); err != nil {
+		log.Error("Failed to update appgroup status err : ", err)
+	}
+}
+
+// reconcileOperations ends the running operations whose workflow has finished,
+// and reconciles their targets. The pending workflows of the operations are submitted once they get ready.
+func (r *reconciler) reconcileOperations(ctx context.Context) {
+	reconciled := map[string]bool{}
+	for _, op := range operations.listRunning() {
+		if op.Pending != nil {
+			r.advancePendingOperation(ctx, op)
+			continue
+		}
+
+		phase, message, err := getWorkflowPhase(op.WorkflowId)
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to get workflow for operation %s. err : ", op.OperationId), err)
+			continue
+		}
+
+		switch phase {
+		case workflowPhaseSucceeded:
+			// The operation stays running to be applied again on the next reconciliation if it fails.
+			if err := r.operationSucceeded(ctx, op); err != nil {
+				log.Error(fmt.Sprintf("Failed to apply operation %s. err : ", op.OperationId), err)
+				continue
+			}
+			err = operations.finish(op, operationPhaseSucceeded, "")
+		case workflowPhaseFailed, workflowPhaseError:
+			err = operations.finish(op, operationPhaseFailed, message)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Error("Failed to update operation. err : ", err)
+			continue
+		}
+		log.Info(fmt.Sprintf("Reconciled operation %s : %s", op.OperationId, op.Phase))
+
+		if !reconciled[op.TargetId] {
+			reconciled[op.TargetId] = true
+			r.reconcileTarget(ctx, op)
+		}
+	}
+}
+
+// operationSucceeded applies the result of the operation to the cluster metadata.
+// The kubeconfig secret of an imported cluster is removed with the cluster, and replaced by the pending one
+// when its credentials are refreshed. The sizes of a scaled cluster are written to tks-info.
+func (r *reconciler) operationSucceeded(ctx context.Context, op *Operation) error {
+	var apply func(md *clusterMetadata) error
+	switch op.Type {
+	case operationScaleCluster:
+		if err := updateScaledClusterConf(ctx, op); err != nil {
+			return err
+		}
+		apply = func(md *clusterMetadata) error {
+			md.DesiredSizePerAz, _ = strconv.Atoi(op.parameter("desired_size_per_az"))
+			return nil
+		}
+	case operationUpgradeCluster:
+		apply = func(md *clusterMetadata) error {
+			md.KubernetesVersion = op.parameter("kubernetes_version")
+			return nil
+		}
+	case operationAddNodePool, operationUpdateNodePool, operationRemoveNodePool:
+		apply = func(md *clusterMetadata) error {
+			return applyNodePoolOperation(md, op)
+		}
+	case operationDeleteCluster:
+		for _, name := range []string{kubeconfigSecretName(op.TargetId), pendingKubeconfigSecretName(op.TargetId)} {
+			if err := secrets.delete(context.Background(), name); err != nil {
+				log.Error("Failed to delete the kubeconfig secret. err : ", err)
+			}
+		}
+		return nil
+	case operationUpdateCredentials:
+		if err := promotePendingKubeconfig(context.Background(), op.TargetId); err != nil {
+			log.Error("Failed to promote the pending kubeconfig secret. err : ", err)
+		}
+		return nil
+	default:
+		return nil
+	}
+
+	if err := clusterMetadatas.update(op.TargetId, apply); err != nil {
+		log.Error("Failed to update cluster metadata. err : ", err)
+		return nil
+	}
+	log.Info(fmt.Sprintf("Applied operation %s %s to cluster %s", op.Type, op.OperationId, op.TargetId))
+	return nil
+}
+
+// updateScaledClusterConf updates the cluster conf in tks-info with the sizes per AZ of the scale operation.
+func updateScaledClusterConf(ctx context.Context, op *Operation) error {
+	ctx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+	defer cancel()
+
+	minSize, err := strconv.Atoi(op.parameter("min_size_per_az"))
+	if err != nil {
+		return fmt.Errorf("invalid min_size_per_az of operation %s", op.OperationId)
+	}
+	maxSize, err := strconv.Atoi(op.parameter("max_size_per_az"))
+	if err != nil {
+		return fmt.Errorf("invalid max_size_per_az of operation %s", op.OperationId)
+	}
+
+	res, err := clusterInfoClient.GetCluster(ctx, &pb.GetClusterRequest{ClusterId: op.TargetId})
+	if err != nil {
+		return err
+	}
+	conf := res.GetCluster().GetConf()
+	if _, err := clusterInfoClient.UpdateClusterConf(ctx, &pb.UpdateClusterConfRequest{
+		ClusterId: op.TargetId,
+		Conf: &pb.ClusterConf{
+			SshKeyName:   conf.GetSshKeyName(),
+			Region:       conf.GetRegion(),
+			NumOfAz:      conf.GetNumOfAz(),
+			MachineType:  conf.GetMachineType(),
+			MinSizePerAz: int32(minSize),
+			MaxSizePerAz: int32(maxSize),
+		},
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getWorkflowPhase returns the phase and message of the given argo workflow.
+// An empty phase means the workflow is still running or could not be found.
+func getWorkflowPhase(workflowId string) (phase string, message string, err error) {
+	if workflowId == "" {
+		return "", "", nil
+	}
+
+	workflow, err := argowfClient.GetWorkflow("argo", workflowId)
+	if err != nil {
+		return "", "", err
+	}
+	if workflow == nil {
+		return "", "", nil
+	}
+	return workflow.Status.Phase, workflow.Status.Message, nil
+}
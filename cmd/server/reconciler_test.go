@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/openinfradev/tks-common/pkg/argowf"
+	mockargo "github.com/openinfradev/tks-common/pkg/argowf/mock"
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+	mocktks "github.com/openinfradev/tks-proto/tks_pb/mock"
+)
+
+func TestReconcile(t *testing.T) {
+	cspId := uuid.New().String()
+	clusterId := helper.GenerateClusterId()
+	appGroupId := helper.GenerateApplicaionGroupId()
+	workflowId := randomString("workflowName")
+
+	clustersWithStatus := func(status pb.ClusterStatus) *pb.GetClustersResponse {
+		return &pb.GetClustersResponse{
+			Code: pb.Code_OK_UNSPECIFIED,
+			Clusters: []*pb.Cluster{
+				{
+					Id:         clusterId,
+					Status:     status,
+					WorkflowId: workflowId,
+				},
+			},
+		}
+	}
+
+	orphanClusterCreatedAt := func(createdAt time.Time) *pb.GetClustersResponse {
+		return &pb.GetClustersResponse{
+			Code: pb.Code_OK_UNSPECIFIED,
+			Clusters: []*pb.Cluster{
+				{
+					Id:        clusterId,
+					Status:    pb.ClusterStatus_UNSPECIFIED,
+					CreatedAt: timestamppb.New(createdAt),
+				},
+			},
+		}
+	}
+
+	appGroupsWithStatus := func(status pb.AppGroupStatus) *pb.GetAppGroupsResponse {
+		return &pb.GetAppGroupsResponse{
+			Code: pb.Code_OK_UNSPECIFIED,
+			AppGroups: []*pb.AppGroup{
+				{
+					AppGroupId: appGroupId,
+					ClusterId:  clusterId,
+					Status:     status,
+					WorkflowId: workflowId,
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		buildStubs func(mockArgoClient *mockargo.MockClient,
+			mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+			mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+			mockAppInfoClient *mocktks.MockAppInfoServiceClient)
+	}{
+		{
+			name: "CLUSTER_INSTALLING_TO_RUNNING",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId}}, nil)
+
+				mockClusterInfoClient.EXPECT().GetClusters(gomock.Any(), &pb.GetClustersRequest{CspId: cspId}).Times(1).
+					Return(clustersWithStatus(pb.ClusterStatus_INSTALLING), nil)
+
+				mockArgoClient.EXPECT().GetWorkflow("argo", workflowId).Times(1).
+					Return(workflowWithPhase(workflowPhaseSucceeded), nil)
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_RUNNING, in.GetStatus())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+		},
+		{
+			name: "CLUSTER_DELETING_TO_ERROR",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId}}, nil)
+
+				mockClusterInfoClient.EXPECT().GetClusters(gomock.Any(), gomock.Any()).Times(1).
+					Return(clustersWithStatus(pb.ClusterStatus_DELETING), nil)
+
+				mockArgoClient.EXPECT().GetWorkflow("argo", workflowId).Times(1).
+					Return(workflowWithPhase(workflowPhaseFailed), nil)
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_ERROR, in.GetStatus())
+						require.NotEmpty(t, in.GetStatusDesc())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+		},
+		{
+			name: "CLUSTER_WORKFLOW_STILL_RUNNING",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId}}, nil)
+
+				mockClusterInfoClient.EXPECT().GetClusters(gomock.Any(), gomock.Any()).Times(1).
+					Return(clustersWithStatus(pb.ClusterStatus_INSTALLING), nil)
+
+				mockArgoClient.EXPECT().GetWorkflow("argo", workflowId).Times(1).
+					Return(workflowWithPhase("Running"), nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+		},
+		{
+			name: "CLUSTER_WORKFLOW_NOT_FOUND",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId}}, nil)
+
+				mockClusterInfoClient.EXPECT().GetClusters(gomock.Any(), gomock.Any()).Times(1).
+					Return(clustersWithStatus(pb.ClusterStatus_INSTALLING), nil)
+
+				mockArgoClient.EXPECT().GetWorkflow("argo", workflowId).Times(1).
+					Return(nil, errors.New("NOT_FOUND_WORKFLOW"))
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+		},
+		{
+			name: "APPGROUP_INSTALLING_TO_RUNNING",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId}}, nil)
+
+				mockClusterInfoClient.EXPECT().GetClusters(gomock.Any(), gomock.Any()).Times(1).
+					Return(clustersWithStatus(pb.ClusterStatus_RUNNING), nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), &pb.IDRequest{Id: clusterId}).Times(1).
+					Return(appGroupsWithStatus(pb.AppGroupStatus_APP_GROUP_INSTALLING), nil)
+
+				mockArgoClient.EXPECT().GetWorkflow("argo", workflowId).Times(1).
+					Return(workflowWithPhase(workflowPhaseSucceeded), nil)
+
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateAppGroupStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.AppGroupStatus_APP_GROUP_RUNNING, in.GetStatus())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+		},
+		{
+			name: "APPGROUP_DELETING_TO_ERROR",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId}}, nil)
+
+				mockClusterInfoClient.EXPECT().GetClusters(gomock.Any(), gomock.Any()).Times(1).
+					Return(clustersWithStatus(pb.ClusterStatus_RUNNING), nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(appGroupsWithStatus(pb.AppGroupStatus_APP_GROUP_DELETING), nil)
+
+				mockArgoClient.EXPECT().GetWorkflow("argo", workflowId).Times(1).
+					Return(workflowWithPhase(workflowPhaseError), nil)
+
+				mockAppInfoClient.EXPECT().UpdateAppGroupStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateAppGroupStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.AppGroupStatus_APP_GROUP_ERROR, in.GetStatus())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+			},
+		},
+		{
+			name: "SKIP_DELETED_CLUSTER",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId}}, nil)
+
+				mockClusterInfoClient.EXPECT().GetClusters(gomock.Any(), gomock.Any()).Times(1).
+					Return(clustersWithStatus(pb.ClusterStatus_DELETED), nil)
+			},
+		},
+		{
+			name: "ORPHAN_CLUSTER_TO_ERROR",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId}}, nil)
+
+				mockClusterInfoClient.EXPECT().GetClusters(gomock.Any(), gomock.Any()).Times(1).
+					Return(orphanClusterCreatedAt(time.Now().Add(-time.Hour)), nil)
+
+				mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+					DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+						require.Equal(t, pb.ClusterStatus_ERROR, in.GetStatus())
+						require.NotEmpty(t, in.GetStatusDesc())
+						return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+					})
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+		},
+		{
+			name: "ORPHAN_CLUSTER_WITHIN_GRACE_PERIOD",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{cspId}}, nil)
+
+				mockClusterInfoClient.EXPECT().GetClusters(gomock.Any(), gomock.Any()).Times(1).
+					Return(orphanClusterCreatedAt(time.Now()), nil)
+
+				mockAppInfoClient.EXPECT().GetAppGroupsByClusterID(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.GetAppGroupsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+			},
+		},
+		{
+			name: "FAILED_TO_GET_CSP_IDS",
+			buildStubs: func(mockArgoClient *mockargo.MockClient,
+				mockCspInfoClient *mocktks.MockCspInfoServiceClient,
+				mockClusterInfoClient *mocktks.MockClusterInfoServiceClient,
+				mockAppInfoClient *mocktks.MockAppInfoServiceClient) {
+				mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+					Return(&pb.IDsResponse{}, errors.New("FAILED_TO_GET_CSP_IDS"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// mocking and injection
+			mockArgoClient := mockargo.NewMockClient(ctrl)
+			argowfClient = mockArgoClient
+
+			mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+			cspInfoClient = mockCspInfoClient
+			mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+			clusterInfoClient = mockClusterInfoClient
+			mockAppInfoClient := mocktks.NewMockAppInfoServiceClient(ctrl)
+			appInfoClient = mockAppInfoClient
+			operations = newTestOperationStore(t)
+
+			tc.buildStubs(mockArgoClient, mockCspInfoClient, mockClusterInfoClient, mockAppInfoClient)
+
+			r := newReconciler(time.Minute, 10*time.Minute, 10*time.Minute)
+			r.reconcile(ctx)
+		})
+	}
+}
+
+func workflowWithPhase(phase string) *argowf.Workflow {
+	return &argowf.Workflow{
+		Status: argowf.WorkflowStatus{
+			Phase:   phase,
+			Message: randomString("MESSAGE"),
+		},
+	}
+}
+
+func TestReconcileSweepInterval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockArgoClient := mockargo.NewMockClient(ctrl)
+	argowfClient = mockArgoClient
+	mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+	cspInfoClient = mockCspInfoClient
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+	operations = newTestOperationStore(t)
+
+	// The first reconciliation sweeps all clusters.
+	mockCspInfoClient.EXPECT().GetCSPIDs(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+	r := newReconciler(time.Minute, time.Hour, 10*time.Minute)
+	r.reconcile(context.Background())
+
+	// The next ones only fetch the targets of the finished operations, with a deadline.
+	clusterId := helper.GenerateClusterId()
+	op := recordOperation(context.Background(), operationCreateCluster, clusterId, randomString("workflowName"), nil, nil)
+	mockArgoClient.EXPECT().GetWorkflow("argo", op.WorkflowId).Times(2).
+		Return(workflowWithPhase(workflowPhaseSucceeded), nil)
+	mockClusterInfoClient.EXPECT().GetCluster(gomock.Any(), gomock.Any()).Times(1).
+		DoAndReturn(func(ctx context.Context, in *pb.GetClusterRequest, opts ...interface{}) (*pb.GetClusterResponse, error) {
+			_, ok := ctx.Deadline()
+			require.True(t, ok)
+			require.Equal(t, clusterId, in.GetClusterId())
+			return &pb.GetClusterResponse{
+				Code:    pb.Code_OK_UNSPECIFIED,
+				Cluster: &pb.Cluster{Id: clusterId, Status: pb.ClusterStatus_INSTALLING, WorkflowId: op.WorkflowId},
+			}, nil
+		})
+	mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+		DoAndReturn(func(ctx context.Context, in *pb.UpdateClusterStatusRequest, opts ...interface{}) (*pb.SimpleResponse, error) {
+			require.Equal(t, pb.ClusterStatus_RUNNING, in.GetStatus())
+			return &pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil
+		})
+	r.reconcile(context.Background())
+	r.reconcile(context.Background())
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/openinfradev/tks-common/pkg/log"
+)
+
+// regionCatalog is a catalog of regions per CSP, loaded from a JSON file.
+// It is keyed on the name of the CSP provider. eg) aws
+type regionCatalog struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	csps    map[string]*cspRegionCatalog
+}
+
+type cspRegionCatalog struct {
+	DefaultRegion      string               `json:"defaultRegion"`
+	DefaultMachineType string               `json:"defaultMachineType"`
+	DefaultSshKeyName  string               `json:"defaultSshKeyName"`
+	MachineTypes       []string             `json:"machineTypes"`
+	Regions            []regionCatalogEntry `json:"regions"`
+}
+
+type regionCatalogEntry struct {
+	Name string   `json:"name"`
+	Azs  []string `json:"azs"`
+	// MachineTypes and DefaultSshKeyName override the CSP wide values if they are given.
+	MachineTypes      []string `json:"machineTypes,omitempty"`
+	DefaultSshKeyName string   `json:"defaultSshKeyName,omitempty"`
+}
+
+// regionInfo is a resolved region of the catalog.
+type regionInfo struct {
+	Name              string
+	Azs               []string
+	MachineTypes      []string
+	DefaultSshKeyName string
+}
+
+func newRegionCatalog(path string) (*regionCatalog, error) {
+	c := &regionCatalog{path: path}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *regionCatalog) load() error {
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	csps := map[string]*cspRegionCatalog{}
+	if err := json.Unmarshal(data, &csps); err != nil {
+		return fmt.Errorf("failed to parse region catalog %s : %s", c.path, err)
+	}
+	for name, csp := range csps {
+		if csp == nil {
+			return fmt.Errorf("invalid region catalog for %s : null", name)
+		}
+		if err := csp.validate(); err != nil {
+			return fmt.Errorf("invalid region catalog for %s : %s", name, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.csps = csps
+	c.modTime = fi.ModTime()
+	return nil
+}
+
+func (c *cspRegionCatalog) validate() error {
+	names := map[string]bool{}
+	for _, region := range c.Regions {
+		if region.Name == "" {
+			return fmt.Errorf("region name must have value")
+		}
+		if names[region.Name] {
+			return fmt.Errorf("duplicated region %s", region.Name)
+		}
+		if len(region.Azs) == 0 {
+			return fmt.Errorf("region %s has no availability zone", region.Name)
+		}
+		names[region.Name] = true
+	}
+	if c.DefaultRegion != "" && !names[c.DefaultRegion] {
+		return fmt.Errorf("default region %s is not in the catalog", c.DefaultRegion)
+	}
+	return nil
+}
+
+// watch reloads the catalog whenever the file is modified.
+// The previous catalog is kept if the modified file is invalid.
+func (c *regionCatalog) watch(ctx context.Context, interval time.Duration) {
+	watchFile(ctx, c.path, interval, c.lastModified, c.load)
+}
+
+func (c *regionCatalog) lastModified() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.modTime
+}
+
+// defaults returns the default region and machine type of the CSP.
+func (c *regionCatalog) defaults(csp string) (region string, machineType string, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cspCatalog, ok := c.csps[csp]
+	if !ok {
+		return "", "", fmt.Errorf("No region catalog for CSP %s", csp)
+	}
+	return cspCatalog.DefaultRegion, cspCatalog.DefaultMachineType, nil
+}
+
+// region returns the region of the CSP. The name must match exactly.
+func (c *regionCatalog) region(csp string, name string) (*regionInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cspCatalog, ok := c.csps[csp]
+	if !ok {
+		return nil, fmt.Errorf("No region catalog for CSP %s", csp)
+	}
+	for _, region := range cspCatalog.Regions {
+		if region.Name != name {
+			continue
+		}
+		info := &regionInfo{
+			Name:              region.Name,
+			Azs:               region.Azs,
+			MachineTypes:      cspCatalog.MachineTypes,
+			DefaultSshKeyName: cspCatalog.DefaultSshKeyName,
+		}
+		if len(region.MachineTypes) > 0 {
+			info.MachineTypes = region.MachineTypes
+		}
+		if region.DefaultSshKeyName != "" {
+			info.DefaultSshKeyName = region.DefaultSshKeyName
+		}
+		return info, nil
+	}
+	return nil, fmt.Errorf("Unknown region %s for %s", name, csp)
+}
+
+func (r *regionInfo) allowsMachineType(machineType string) bool {
+	if len(r.MachineTypes) == 0 {
+		return true
+	}
+	for _, t := range r.MachineTypes {
+		if t == machineType {
+			return true
+		}
+	}
+	return false
+}
+
+// watchFile polls the modification time of the file and calls reload when it has changed.
+func watchFile(ctx context.Context, path string, interval time.Duration, lastModified func() time.Time, reload func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fi, err := os.Stat(path)
+			if err != nil {
+				log.Error("Failed to stat file. err : ", err)
+				continue
+			}
+			if fi.ModTime().Equal(lastModified()) {
+				continue
+			}
+			if err := reload(); err != nil {
+				log.Error("Failed to reload file. keep the previous one. err : ", err)
+				continue
+			}
+			log.Info("Reloaded file : ", path)
+		}
+	}
+}
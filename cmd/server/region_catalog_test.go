@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testRegionCatalog = `{
+  "aws": {
+    "defaultRegion": "ap-northeast-2",
+    "defaultMachineType": "t3.large",
+    "defaultSshKeyName": "tks-seoul",
+    "machineTypes": ["t3.large", "m5.large"],
+    "regions": [
+      {"name": "ap-northeast-2", "azs": ["ap-northeast-2a", "ap-northeast-2b"]},
+      {"name": "ap-northeast-1", "azs": ["ap-northeast-1a"], "machineTypes": ["m5.large"], "defaultSshKeyName": "tks-tokyo"}
+    ]
+  }
+}`
+
+func writeTestFile(t *testing.T, path string, content string) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func TestRegionCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "region-catalog.json")
+	writeTestFile(t, path, testRegionCatalog)
+
+	c, err := newRegionCatalog(path)
+	require.NoError(t, err)
+
+	region, machineType, err := c.defaults("aws")
+	require.NoError(t, err)
+	require.Equal(t, "ap-northeast-2", region)
+	require.Equal(t, "t3.large", machineType)
+
+	seoul, err := c.region("aws", "ap-northeast-2")
+	require.NoError(t, err)
+	require.Len(t, seoul.Azs, 2)
+	require.Equal(t, "tks-seoul", seoul.DefaultSshKeyName)
+	require.True(t, seoul.allowsMachineType("t3.large"))
+
+	tokyo, err := c.region("aws", "ap-northeast-1")
+	require.NoError(t, err)
+	require.Equal(t, "tks-tokyo", tokyo.DefaultSshKeyName)
+	require.False(t, tokyo.allowsMachineType("t3.large"))
+
+	// region names must match exactly
+	_, err = c.region("aws", "ap-northeast")
+	require.Error(t, err)
+
+	_, err = c.region("azure", "koreacentral")
+	require.Error(t, err)
+}
+
+func TestRegionCatalogInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "region-catalog.json")
+
+	_, err := newRegionCatalog(path)
+	require.Error(t, err)
+
+	writeTestFile(t, path, `{"aws": {"defaultRegion": "us-east-1", "regions": [{"name": "ap-northeast-2", "azs": ["a"]}]}}`)
+	_, err = newRegionCatalog(path)
+	require.Error(t, err)
+
+	writeTestFile(t, path, `{"aws": {"regions": [{"name": "ap-northeast-2"}]}}`)
+	_, err = newRegionCatalog(path)
+	require.Error(t, err)
+
+	writeTestFile(t, path, `{"aws": null}`)
+	_, err = newRegionCatalog(path)
+	require.Error(t, err)
+}
+
+func TestRegionCatalogReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "region-catalog.json")
+	writeTestFile(t, path, testRegionCatalog)
+
+	c, err := newRegionCatalog(path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.watch(ctx, 10*time.Millisecond)
+
+	// an invalid file keeps the previous catalog
+	writeTestFile(t, path, `{invalid`)
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	time.Sleep(50 * time.Millisecond)
+	_, err = c.region("aws", "ap-northeast-2")
+	require.NoError(t, err)
+
+	writeTestFile(t, path, `{"aws": {"regions": [{"name": "us-east-1", "azs": ["us-east-1a"]}]}}`)
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	require.Eventually(t, func() bool {
+		_, err := c.region("aws", "us-east-1")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = c.region("aws", "ap-northeast-2")
+	require.Error(t, err)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/openinfradev/tks-common/pkg/log"
+)
+
+// Secret store backends
+const (
+	secretBackendKubernetes = "kubernetes"
+	secretBackendFile       = "file"
+	// secretBackendAuto is the kubernetes backend in a kubernetes cluster, and the file backend elsewhere.
+	secretBackendAuto = "auto"
+)
+
+const secretBucket = "secrets"
+
+// kubeconfigSecretKey is the key of the kubeconfig in the secret, which the import workflow mounts.
+const kubeconfigSecretKey = "value"
+
+// errSecretNotFound is returned by secretStore.get for a missing secret.
+var errSecretNotFound = errors.New("secret not found")
+
+// secretStore keeps sensitive data, such as kubeconfigs, out of workflow parameters.
+// Workflows are given the name of the secret only.
+type secretStore interface {
+	put(ctx context.Context, name string, data map[string][]byte) error
+	get(ctx context.Context, name string) (map[string][]byte, error)
+	delete(ctx context.Context, name string) error
+}
+
+func newSecretStore(backend string, store *fileStore, namespace string) (secretStore, error) {
+	switch backend {
+	case secretBackendKubernetes:
+		return newKubeSecretStore(namespace)
+	case secretBackendFile:
+		return newFileSecretStore(store), nil
+	case secretBackendAuto:
+		if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+			return newKubeSecretStore(namespace)
+		}
+		log.Warn("Not running in a kubernetes cluster. Using the file secret store, whose secrets workflows can not read")
+		return newFileSecretStore(store), nil
+	}
+	return nil, fmt.Errorf("unsupported secret backend %s", backend)
+}
+
+// kubeconfigSecretName returns the name of the secret which keeps the kubeconfig of the imported cluster.
+func kubeconfigSecretName(clusterId string) string {
+	return clusterId + "-import-kubeconfig"
+}
+
+// pendingKubeconfigSecretName returns the name of the secret which keeps the new kubeconfig of the imported cluster
+// until the refresh workflow succeeds.
+func pendingKubeconfigSecretName(clusterId string) string {
+	return kubeconfigSecretName(clusterId) + "-pending"
+}
+
+// promotePendingKubeconfig replaces the kubeconfig of the imported cluster with the pending one.
+func promotePendingKubeconfig(ctx context.Context, clusterId string) error {
+	data, err := secrets.get(ctx, pendingKubeconfigSecretName(clusterId))
+	if err != nil {
+		return err
+	}
+	if err := secrets.put(ctx, kubeconfigSecretName(clusterId), data); err != nil {
+		return err
+	}
+	return secrets.delete(ctx, pendingKubeconfigSecretName(clusterId))
+}
+
+// fileSecretStore keeps secrets in the local store. It is meant for tests and local runs,
+// since workflows can not read the secrets.
+type fileSecretStore struct {
+	store *fileStore
+}
+
+func newFileSecretStore(store *fileStore) *fileSecretStore {
+	return &fileSecretStore{store: store}
+}
+
+func (s *fileSecretStore) put(ctx context.Context, name string, data map[string][]byte) error {
+	return s.store.put(secretBucket, name, data)
+}
+
+func (s *fileSecretStore) get(ctx context.Context, name string) (map[string][]byte, error) {
+	data := map[string][]byte{}
+	found, err := s.store.get(secretBucket, name, &data)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%w : %s", errSecretNotFound, name)
+	}
+	return data, nil
+}
+
+func (s *fileSecretStore) delete(ctx context.Context, name string) error {
+	return s.store.delete(secretBucket, name)
+}
+
+// Paths of the service account which is mounted into the pod.
+const (
+	serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCAPath    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// kubeSecretStore keeps secrets as Kubernetes Secrets in the namespace of the workflows.
+// It calls the API server with the service account of the pod.
+type kubeSecretStore struct {
+	server    string
+	namespace string
+	token     string
+	client    *http.Client
+}
+
+func newKubeSecretStore(namespace string) (*kubeSecretStore, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster. KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	token, err := ioutil.ReadFile(serviceAccountTokenPath)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(serviceAccountCAPath)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate in %s", serviceAccountCAPath)
+	}
+
+	return &kubeSecretStore{
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		token:     string(bytes.TrimSpace(token)),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+	}, nil
+}
+
+type kubeSecret struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Type string            `json:"type,omitempty"`
+	Data map[string][]byte `json:"data"`
+}
+
+func (s *kubeSecretStore) url(name string) string {
+	url := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets", s.server, s.namespace)
+	if name != "" {
+		url += "/" + name
+	}
+	return url
+}
+
+func (s *kubeSecretStore) do(ctx context.Context, method string, url string, body interface{}) (int, []byte, error) {
+	data := []byte{}
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	data, err = ioutil.ReadAll(res.Body)
+	return res.StatusCode, data, err
+}
+
+// put creates the secret, or replaces it if it exists.
+func (s *kubeSecretStore) put(ctx context.Context, name string, data map[string][]byte) error {
+	secret := kubeSecret{APIVersion: "v1", Kind: "Secret", Type: "Opaque", Data: data}
+	secret.Metadata.Name = name
+	secret.Metadata.Namespace = s.namespace
+	secret.Metadata.Labels = map[string]string{"app.kubernetes.io/managed-by": "tks-cluster-lcm"}
+
+	code, body, err := s.do(ctx, http.MethodPost, s.url(""), secret)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusConflict {
+		log.Debug("Replacing the existing secret ", name)
+		code, body, err = s.do(ctx, http.MethodPut, s.url(name), secret)
+		if err != nil {
+			return err
+		}
+	}
+	if code != http.StatusOK && code != http.StatusCreated {
+		return fmt.Errorf("failed to put secret %s/%s. status : %d, body : %s", s.namespace, name, code, string(body))
+	}
+	return nil
+}
+
+func (s *kubeSecretStore) get(ctx context.Context, name string) (map[string][]byte, error) {
+	code, body, err := s.do(ctx, http.MethodGet, s.url(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	if code == http.StatusNotFound {
+		return nil, fmt.Errorf("%w : %s/%s", errSecretNotFound, s.namespace, name)
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("failed to get secret %s/%s. status : %d, body : %s", s.namespace, name, code, string(body))
+	}
+	secret := kubeSecret{}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, err
+	}
+	return secret.Data, nil
+}
+
+// delete removes the secret. A missing secret is not an error.
+func (s *kubeSecretStore) delete(ctx context.Context, name string) error {
+	code, body, err := s.do(ctx, http.MethodDelete, s.url(name), nil)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK && code != http.StatusAccepted && code != http.StatusNotFound {
+		return fmt.Errorf("failed to delete secret %s/%s. status : %d, body : %s", s.namespace, name, code, string(body))
+	}
+	return nil
+}
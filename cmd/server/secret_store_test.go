@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openinfradev/tks-common/pkg/argowf"
+	mockargo "github.com/openinfradev/tks-common/pkg/argowf/mock"
+	"github.com/openinfradev/tks-common/pkg/helper"
+	pb "github.com/openinfradev/tks-proto/tks_pb"
+	mocktks "github.com/openinfradev/tks-proto/tks_pb/mock"
+)
+
+func TestNewSecretStore(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	host, ok := os.LookupEnv("KUBERNETES_SERVICE_HOST")
+	defer func() {
+		if ok {
+			os.Setenv("KUBERNETES_SERVICE_HOST", host)
+		} else {
+			os.Unsetenv("KUBERNETES_SERVICE_HOST")
+		}
+	}()
+
+	// The auto backend falls back to the file backend out of a kubernetes cluster.
+	os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	s, err := newSecretStore(secretBackendAuto, store, "argo")
+	require.NoError(t, err)
+	require.IsType(t, &fileSecretStore{}, s)
+
+	_, err = newSecretStore(secretBackendKubernetes, store, "argo")
+	require.Error(t, err)
+
+	_, err = newSecretStore("vault", store, "argo")
+	require.Error(t, err)
+}
+
+func TestFileSecretStore(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	require.NoError(t, err)
+	s := newFileSecretStore(store)
+	ctx := context.Background()
+
+	_, err = s.get(ctx, "secret")
+	require.True(t, errors.Is(err, errSecretNotFound))
+
+	require.NoError(t, s.put(ctx, "secret", map[string][]byte{"value": []byte("data")}))
+	data, err := s.get(ctx, "secret")
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), data["value"])
+
+	require.NoError(t, s.delete(ctx, "secret"))
+	require.NoError(t, s.delete(ctx, "secret"))
+	_, err = s.get(ctx, "secret")
+	require.Error(t, err)
+}
+
+// fakeKubeSecretServer serves the secrets API of a namespace in memory.
+func fakeKubeSecretServer(t *testing.T, namespace string) *httptest.Server {
+	mu := sync.Mutex{}
+	secrets := map[string][]byte{}
+	prefix := "/api/v1/namespaces/" + namespace + "/secrets"
+
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Header.Get("Authorization") != "Bearer token" || !strings.HasPrefix(r.URL.Path, prefix) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		body, _ := ioutil.ReadAll(r.Body)
+
+		switch r.Method {
+		case http.MethodPost:
+			secret := kubeSecret{}
+			require.NoError(t, json.Unmarshal(body, &secret))
+			if _, ok := secrets[secret.Metadata.Name]; ok {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			secrets[secret.Metadata.Name] = body
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPut:
+			if _, ok := secrets[name]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			secrets[name] = body
+		case http.MethodGet:
+			data, ok := secrets[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		case http.MethodDelete:
+			if _, ok := secrets[name]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(secrets, name)
+		}
+	}))
+}
+
+func TestKubeSecretStore(t *testing.T) {
+	ts := fakeKubeSecretServer(t, "argo")
+	defer ts.Close()
+
+	s := &kubeSecretStore{server: ts.URL, namespace: "argo", token: "token", client: ts.Client()}
+	ctx := context.Background()
+
+	_, err := s.get(ctx, "secret")
+	require.True(t, errors.Is(err, errSecretNotFound))
+
+	require.NoError(t, s.put(ctx, "secret", map[string][]byte{"value": []byte("data")}))
+	// put replaces the existing secret
+	require.NoError(t, s.put(ctx, "secret", map[string][]byte{"value": []byte("replaced")}))
+	data, err := s.get(ctx, "secret")
+	require.NoError(t, err)
+	require.Equal(t, []byte("replaced"), data["value"])
+
+	require.NoError(t, s.delete(ctx, "secret"))
+	require.NoError(t, s.delete(ctx, "secret"))
+
+	s.token = "invalid"
+	require.Error(t, s.put(ctx, "secret", map[string][]byte{"value": []byte("data")}))
+	_, err = s.get(ctx, "secret")
+	require.False(t, errors.Is(err, errSecretNotFound))
+}
+
+func TestImportClusterKubeconfigSecret(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockArgoClient := mockargo.NewMockClient(ctrl)
+	argowfClient = mockArgoClient
+	mockCspInfoClient := mocktks.NewMockCspInfoServiceClient(ctrl)
+	cspInfoClient = mockCspInfoClient
+	mockClusterInfoClient := mocktks.NewMockClusterInfoServiceClient(ctrl)
+	clusterInfoClient = mockClusterInfoClient
+
+	clusterId := helper.GenerateClusterId()
+	kubeconfig := testKubeconfig("https://10.0.0.1:6443", randomString("TOKEN"))
+
+	contractId := helper.GenerateContractId()
+	mockCspInfoClient.EXPECT().GetCSPIDsByContractID(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.IDsResponse{Code: pb.Code_OK_UNSPECIFIED, Ids: []string{uuid.New().String()}}, nil)
+	mockCspInfoClient.EXPECT().GetCSPInfo(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.GetCSPInfoResponse{Code: pb.Code_OK_UNSPECIFIED, ContractId: contractId, CspType: pb.CspType_AWS}, nil)
+	mockClusterInfoClient.EXPECT().AddClusterInfo(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.IDResponse{Code: pb.Code_OK_UNSPECIFIED, Id: clusterId}, nil)
+	mockArgoClient.EXPECT().SumbitWorkflowFromWftpl("import-tks-usercluster", "argo", gomock.Any()).Times(1).
+		DoAndReturn(func(workflow string, namespace string, opts argowf.SubmitOptions) (string, error) {
+			require.Contains(t, opts.Parameters, "kubeconfig_secret_name="+kubeconfigSecretName(clusterId))
+			for _, param := range opts.Parameters {
+				require.NotContains(t, param, "kubeconfig=")
+			}
+			return randomString("workflowName"), nil
+		})
+	mockClusterInfoClient.EXPECT().UpdateClusterStatus(gomock.Any(), gomock.Any()).Times(1).
+		Return(&pb.SimpleResponse{Code: pb.Code_OK_UNSPECIFIED}, nil)
+
+	s := server{}
+	res, err := s.ImportCluster(context.Background(), &pb.ImportClusterRequest{
+		ContractId: contractId,
+		Name:       randomString("NAME"),
+		Kubeconfig: kubeconfig,
+	})
+	require.NoError(t, err)
+	require.Equal(t, pb.Code_OK_UNSPECIFIED, res.Code)
+
+	data, err := secrets.get(context.Background(), kubeconfigSecretName(clusterId))
+	require.NoError(t, err)
+	require.Equal(t, kubeconfig, data[kubeconfigSecretKey])
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var storeKeyRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// fileStore is a local persistent store which keeps each record as a JSON file.
+// Records are grouped in buckets, and a bucket is a directory under the store directory.
+type fileStore struct {
+	mu  sync.RWMutex
+	dir string
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) path(bucket string, key string) (string, error) {
+	if !storeKeyRegex.MatchString(bucket) || !storeKeyRegex.MatchString(key) {
+		return "", fmt.Errorf("invalid store key %s/%s", bucket, key)
+	}
+	return filepath.Join(s.dir, bucket, key+".json"), nil
+}
+
+// put writes the record atomically.
+func (s *fileStore) put(bucket string, key string, v interface{}) error {
+	path, err := s.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// get reads the record into v. It returns false if the record does not exist.
+func (s *fileStore) get(bucket string, key string, v interface{}) (bool, error) {
+	path, err := s.path(bucket, key)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (s *fileStore) delete(bucket string, key string) error {
+	path, err := s.path(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// list calls fn with the raw data of every record in the bucket, ordered by key.
+func (s *fileStore) list(bucket string, fn func(key string, data []byte) error) error {
+	if !storeKeyRegex.MatchString(bucket) {
+		return fmt.Errorf("invalid store bucket %s", bucket)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, bucket))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, bucket, file.Name()))
+		if err != nil {
+			return err
+		}
+		if err := fn(strings.TrimSuffix(file.Name(), ".json"), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
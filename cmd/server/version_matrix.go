@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var kubernetesVersionRegex = regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)$`)
+
+// versionMatrix is a list of Kubernetes versions supported for user clusters, loaded from a JSON file.
+type versionMatrix struct {
+	mu             sync.RWMutex
+	path           string
+	modTime        time.Time
+	defaultVersion string
+	versions       map[string]bool
+}
+
+type versionMatrixFile struct {
+	// DefaultVersion is the version of the clusters created by CreateCluster.
+	DefaultVersion string   `json:"defaultVersion"`
+	Versions       []string `json:"versions"`
+}
+
+type kubernetesVersion struct {
+	major int
+	minor int
+	patch int
+}
+
+func parseKubernetesVersion(version string) (*kubernetesVersion, error) {
+	m := kubernetesVersionRegex.FindStringSubmatch(version)
+	if m == nil {
+		return nil, fmt.Errorf("invalid kubernetes version %s. eg) v1.23.16", version)
+	}
+	v := &kubernetesVersion{}
+	v.major, _ = strconv.Atoi(m[1])
+	v.minor, _ = strconv.Atoi(m[2])
+	v.patch, _ = strconv.Atoi(m[3])
+	return v, nil
+}
+
+func newVersionMatrix(path string) (*versionMatrix, error) {
+	c := &versionMatrix{path: path}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *versionMatrix) load() error {
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	raw := versionMatrixFile{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse version matrix %s : %s", c.path, err)
+	}
+	versions := map[string]bool{}
+	for _, version := range raw.Versions {
+		if _, err := parseKubernetesVersion(version); err != nil {
+			return err
+		}
+		versions[version] = true
+	}
+	if !versions[raw.DefaultVersion] {
+		return fmt.Errorf("default version %s is not in the version matrix", raw.DefaultVersion)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaultVersion = raw.DefaultVersion
+	c.versions = versions
+	c.modTime = fi.ModTime()
+	return nil
+}
+
+// watch reloads the matrix whenever the file is modified.
+// The previous matrix is kept if the modified file is invalid.
+func (c *versionMatrix) watch(ctx context.Context, interval time.Duration) {
+	watchFile(ctx, c.path, interval, c.lastModified, c.load)
+}
+
+func (c *versionMatrix) lastModified() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.modTime
+}
+
+func (c *versionMatrix) defaults() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.defaultVersion
+}
+
+// validateUpgrade checks if the cluster can be upgraded from the current version to the target version.
+// Only a patch upgrade or an upgrade to the next minor version is allowed.
+func (c *versionMatrix) validateUpgrade(current string, target string) error {
+	c.mu.RLock()
+	supported := c.versions[target]
+	c.mu.RUnlock()
+	if !supported {
+		return fmt.Errorf("Unsupported kubernetes version %s", target)
+	}
+
+	cur, err := parseKubernetesVersion(current)
+	if err != nil {
+		return err
+	}
+	tgt, err := parseKubernetesVersion(target)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case tgt.major != cur.major:
+		return fmt.Errorf("Can not upgrade major version from %s to %s", current, target)
+	case tgt.minor == cur.minor && tgt.patch > cur.patch:
+		return nil
+	case tgt.minor == cur.minor+1:
+		return nil
+	case tgt.minor < cur.minor || (tgt.minor == cur.minor && tgt.patch <= cur.patch):
+		return fmt.Errorf("Can not downgrade or reinstall version from %s to %s", current, target)
+	default:
+		return fmt.Errorf("Can not skip minor versions from %s to %s. upgrade one minor version at a time", current, target)
+	}
+}
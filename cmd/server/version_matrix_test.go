@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionMatrixValidateUpgrade(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "k8s-versions.json")
+	writeTestFile(t, path, `{"defaultVersion": "v1.23.16", "versions": ["v1.22.17", "v1.23.16", "v1.23.17", "v1.24.10", "v1.25.6"]}`)
+
+	m, err := newVersionMatrix(path)
+	require.NoError(t, err)
+	require.Equal(t, "v1.23.16", m.defaults())
+
+	testCases := []struct {
+		name    string
+		current string
+		target  string
+		ok      bool
+	}{
+		{name: "OK_NEXT_MINOR", current: "v1.23.16", target: "v1.24.10", ok: true},
+		{name: "OK_PATCH", current: "v1.23.16", target: "v1.23.17", ok: true},
+		{name: "SKIP_MINOR", current: "v1.23.16", target: "v1.25.6", ok: false},
+		{name: "DOWNGRADE", current: "v1.23.16", target: "v1.22.17", ok: false},
+		{name: "SAME_VERSION", current: "v1.23.16", target: "v1.23.16", ok: false},
+		{name: "UNSUPPORTED_VERSION", current: "v1.23.16", target: "v1.24.1", ok: false},
+		{name: "INVALID_CURRENT_VERSION", current: "1.23", target: "v1.24.10", ok: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := m.validateUpgrade(tc.current, tc.target)
+			if tc.ok {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestVersionMatrixInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "k8s-versions.json")
+
+	writeTestFile(t, path, `{"defaultVersion": "v1.23.16", "versions": ["1.23.16"]}`)
+	_, err := newVersionMatrix(path)
+	require.Error(t, err)
+
+	writeTestFile(t, path, `{"defaultVersion": "v1.26.0", "versions": ["v1.23.16"]}`)
+	_, err = newVersionMatrix(path)
+	require.Error(t, err)
+}
@@ -11,6 +11,9 @@ require (
 	github.com/openinfradev/tks-proto v0.0.6-0.20221117013032-f3e8aa863671
 	github.com/stretchr/testify v1.7.0
 	google.golang.org/genproto v0.0.0-20211013025323-ce878158c4d4 // indirect
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.28.1
+	gopkg.in/yaml.v2 v2.3.0
 )
 
 replace github.com/openinfradev/tks-cluster-lcm => ./